@@ -7,11 +7,20 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/hra42/iot-hub-statuspage/internal/certs"
+	"github.com/hra42/iot-hub-statuspage/internal/diskhealth"
 	"github.com/hra42/iot-hub-statuspage/internal/haproxy"
+	"github.com/hra42/iot-hub-statuspage/internal/homeassistant"
 	"github.com/hra42/iot-hub-statuspage/internal/metrics"
+	"github.com/hra42/iot-hub-statuspage/internal/mqtt"
+	"github.com/hra42/iot-hub-statuspage/internal/nagios"
+	"github.com/hra42/iot-hub-statuspage/internal/pi"
+	"github.com/hra42/iot-hub-statuspage/internal/push"
 	"github.com/hra42/iot-hub-statuspage/internal/storage"
 	"github.com/hra42/iot-hub-statuspage/internal/web"
 )
@@ -19,7 +28,7 @@ import (
 func main() {
 	// Log the current user
 	log.Printf("Starting statuspage as UID: %d, GID: %d", os.Getuid(), os.Getgid())
-	
+
 	// Build PostgreSQL connection string from environment variables
 	dbHost := getEnv("POSTGRES_HOST", "localhost")
 	dbPort := getEnv("POSTGRES_PORT", "5432")
@@ -27,17 +36,17 @@ func main() {
 	dbPassword := getEnv("POSTGRES_PASSWORD", "")
 	dbName := getEnv("POSTGRES_DB", "statuspage")
 	dbSSLMode := getEnv("POSTGRES_SSLMODE", "disable")
-	
+
 	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
 		dbHost, dbPort, dbUser, dbPassword, dbName, dbSSLMode)
-	
+
 	// Initialize storage
 	db, err := storage.NewDB(connStr)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer db.Close()
-	
+
 	// Verify database connection
 	if err := db.Ping(); err != nil {
 		log.Fatalf("Failed to ping database: %v", err)
@@ -47,8 +56,175 @@ func main() {
 	// Initialize HAProxy client
 	haproxyClient := haproxy.NewClient(getEnv("HAPROXY_SOCKET", "/var/run/haproxy/admin.sock"))
 
+	// Docker hosts and container filter rules
+	var collectorConfig metrics.Config
+	if collectorConfig.DockerHosts, err = metrics.ParseDockerHosts(getEnv("DOCKER_HOSTS", "")); err != nil {
+		log.Fatalf("Invalid DOCKER_HOSTS: %v", err)
+	}
+	if collectorConfig.DockerFilter.Include, err = metrics.ParseContainerRules(getEnv("DOCKER_INCLUDE", "")); err != nil {
+		log.Fatalf("Invalid DOCKER_INCLUDE: %v", err)
+	}
+	if collectorConfig.DockerFilter.Exclude, err = metrics.ParseContainerRules(getEnv("DOCKER_EXCLUDE", "")); err != nil {
+		log.Fatalf("Invalid DOCKER_EXCLUDE: %v", err)
+	}
+
+	collectorConfig.SystemdUnits = getEnvList("SYSTEMD_UNITS")
+
+	// MQTT broker and device monitoring
+	if broker := getEnv("MQTT_BROKER", ""); broker != "" {
+		deviceTopics, err := mqtt.ParseDeviceTopics(getEnv("MQTT_DEVICE_TOPICS", ""))
+		if err != nil {
+			log.Fatalf("Invalid MQTT_DEVICE_TOPICS: %v", err)
+		}
+		collectorConfig.MQTT = &mqtt.Config{
+			Broker:   broker,
+			Username: getEnv("MQTT_USERNAME", ""),
+			Password: getEnv("MQTT_PASSWORD", ""),
+			ClientID: getEnv("MQTT_CLIENT_ID", ""),
+			Devices:  deviceTopics,
+		}
+
+		if getEnv("MQTT_PUBLISH", "false") == "true" {
+			collectorConfig.MQTTPublish = &mqtt.PublisherConfig{
+				TopicPrefix:     getEnv("MQTT_TOPIC_PREFIX", "statuspage"),
+				DiscoveryPrefix: getEnv("MQTT_DISCOVERY_PREFIX", "homeassistant"),
+			}
+			collectorConfig.MQTT.StatusTopic = collectorConfig.MQTTPublish.StatusTopic()
+		}
+	}
+
+	// Home Assistant monitoring
+	if haURL := getEnv("HOMEASSISTANT_URL", ""); haURL != "" {
+		collectorConfig.HomeAssistant = &homeassistant.Config{
+			URL:      haURL,
+			Token:    getEnv("HOMEASSISTANT_TOKEN", ""),
+			Entities: getEnvList("HOMEASSISTANT_ENTITIES"),
+		}
+	}
+
+	// Raspberry Pi telemetry
+	piReader := pi.NewReader()
+	piReader.SysRoot = getEnv("SYSFS_ROOT", piReader.SysRoot)
+	piReader.Vcgencmd = getEnv("VCGENCMD", piReader.Vcgencmd)
+	collectorConfig.Pi = &metrics.PiConfig{
+		Reader:       piReader,
+		WarningTemp:  getEnvFloat("PI_TEMP_WARNING", 70),
+		CriticalTemp: getEnvFloat("PI_TEMP_CRITICAL", 80),
+	}
+
+	// Per mount disk monitoring
+	collectorConfig.Mounts = getEnvList("DISK_MOUNTS")
+	if len(collectorConfig.Mounts) == 0 {
+		collectorConfig.Mounts = []string{"/"}
+	}
+	diskHealth := diskhealth.NewReader()
+	diskHealth.SysRoot = piReader.SysRoot
+	diskHealth.Smartctl = getEnv("SMARTCTL", diskHealth.Smartctl)
+	collectorConfig.DiskHealth = diskHealth
+
+	// Network interfaces and WAN quality
+	collectorConfig.Interfaces = getEnvList("NETWORK_INTERFACES")
+	if target := getEnv("WAN_TARGET", ""); target != "" {
+		interval, err := time.ParseDuration(getEnv("WAN_INTERVAL", "1m"))
+		if err != nil || interval <= 0 {
+			log.Fatalf("Invalid WAN_INTERVAL: %q", getEnv("WAN_INTERVAL", "1m"))
+		}
+		collectorConfig.WAN = &metrics.WANConfig{
+			Target:   target,
+			DNSName:  getEnv("WAN_DNS_NAME", "example.com"),
+			Interval: interval,
+		}
+	}
+
+	// Hosts checked with ping
+	if collectorConfig.Probes, err = metrics.ParseProbeHosts(getEnv("PING_HOSTS", "Pi5=192.168.2.136,Pi5-2=192.168.2.135")); err != nil {
+		log.Fatalf("Invalid PING_HOSTS: %v", err)
+	}
+
+	// Nagios compatible check plugins
+	checkInterval, err := time.ParseDuration(getEnv("CHECK_INTERVAL", "1m"))
+	if err != nil || checkInterval <= 0 {
+		log.Fatalf("Invalid CHECK_INTERVAL: %q", getEnv("CHECK_INTERVAL", "1m"))
+	}
+	checkTimeout, err := time.ParseDuration(getEnv("CHECK_TIMEOUT", "10s"))
+	if err != nil || checkTimeout <= 0 {
+		log.Fatalf("Invalid CHECK_TIMEOUT: %q", getEnv("CHECK_TIMEOUT", "10s"))
+	}
+	if dir := getEnv("CHECKS_DIR", ""); dir != "" {
+		checks, err := nagios.DirChecks(dir, checkInterval, checkTimeout)
+		if err != nil {
+			log.Fatalf("Failed to read CHECKS_DIR: %v", err)
+		}
+		collectorConfig.Checks = append(collectorConfig.Checks, checks...)
+	}
+	if file := getEnv("CHECKS_FILE", ""); file != "" {
+		checks, err := nagios.LoadChecks(file, checkInterval, checkTimeout)
+		if err != nil {
+			log.Fatalf("Failed to load CHECKS_FILE: %v", err)
+		}
+		collectorConfig.Checks = append(collectorConfig.Checks, checks...)
+	}
+	checkNames := make(map[string]bool, len(collectorConfig.Checks))
+	for _, check := range collectorConfig.Checks {
+		if checkNames[check.Name] {
+			log.Fatalf("Check %q is configured twice", check.Name)
+		}
+		checkNames[check.Name] = true
+	}
+	if concurrency := getEnv("CHECK_CONCURRENCY", ""); concurrency != "" {
+		if collectorConfig.CheckConcurrency, err = strconv.Atoi(concurrency); err != nil || collectorConfig.CheckConcurrency <= 0 {
+			log.Fatalf("Invalid CHECK_CONCURRENCY: %q", concurrency)
+		}
+	}
+
+	// TLS certificate expiry
+	certTargets, err := certs.ParseTargets(getEnv("CERT_TARGETS", ""))
+	if err != nil {
+		log.Fatalf("Invalid CERT_TARGETS: %v", err)
+	}
+	certHAProxy := getEnv("CERT_HAPROXY", "false") == "true"
+	if len(certTargets) > 0 || certHAProxy {
+		collectorConfig.Certificates = &metrics.CertConfig{
+			Targets:      certTargets,
+			HAProxy:      certHAProxy,
+			WarningDays:  getEnvDays("CERT_WARNING_DAYS", 14),
+			CriticalDays: getEnvDays("CERT_CRITICAL_DAYS", 7),
+		}
+	}
+
+	// Per source collection intervals
+	if collectorConfig.Intervals, err = metrics.ParseIntervals(getEnv("COLLECT_INTERVALS", "")); err != nil {
+		log.Fatalf("Invalid COLLECT_INTERVALS: %v", err)
+	}
+
 	// Initialize metrics collector
-	collector := metrics.NewCollector(db, haproxyClient)
+	collector := metrics.NewCollector(db, haproxyClient, collectorConfig)
+
+	// Push checks of jobs that report in on their own
+	pushChecks, err := push.ParseChecks(getEnv("PUSH_CHECKS", ""))
+	if err != nil {
+		log.Fatalf("Invalid PUSH_CHECKS: %v", err)
+	}
+	var pushMonitor *push.Monitor
+	if len(pushChecks) > 0 {
+		// A ping is shown without waiting for the next collection
+		if pushMonitor, err = push.NewMonitor(db, pushChecks, func() { collector.Trigger("push") }); err != nil {
+			log.Fatalf("Failed to initialize push checks: %v", err)
+		}
+		source := metrics.NewSource("push", func(ctx context.Context) (*metrics.Result, error) {
+			services, values := pushMonitor.Report()
+			return &metrics.Result{Services: services, Metrics: values}, nil
+		})
+		if err := collector.Register(source, 10*time.Second, 5*time.Second); err != nil {
+			log.Fatalf("Failed to register push checks: %v", err)
+		}
+	}
+
+	// Initialize web server, its SSE connections are collected as a source
+	server := web.NewServer(db, haproxyClient, collector, pushMonitor)
+	if err := collector.Register(server.EventSource(), 30*time.Second, 5*time.Second); err != nil {
+		log.Fatalf("Failed to register SSE metrics: %v", err)
+	}
 
 	// Start metrics collection
 	ctx, cancel := context.WithCancel(context.Background())
@@ -59,9 +235,6 @@ func main() {
 	// Wait a moment for initial metrics collection
 	time.Sleep(2 * time.Second)
 
-	// Initialize web server
-	server := web.NewServer(db, haproxyClient, collector)
-	
 	srv := &http.Server{
 		Addr:    ":" + getEnv("PORT", "8080"),
 		Handler: server.Router(),
@@ -96,4 +269,41 @@ func getEnv(key, defaultValue string) string {
 		return value
 	}
 	return defaultValue
-}
\ No newline at end of file
+}
+
+// getEnvDays reads a non-negative number of days.
+func getEnvDays(key string, defaultValue float64) float64 {
+	value := getEnv(key, "")
+	if value == "" {
+		return defaultValue
+	}
+	days, err := strconv.ParseFloat(value, 64)
+	if err != nil || days < 0 {
+		log.Fatalf("Invalid %s: %q", key, value)
+	}
+	return days
+}
+
+// getEnvFloat reads a number, e.g. a temperature threshold.
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value := getEnv(key, "")
+	if value == "" {
+		return defaultValue
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Fatalf("Invalid %s: %q", key, value)
+	}
+	return number
+}
+
+// getEnvList reads a comma separated list, skipping empty entries.
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
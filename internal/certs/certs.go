@@ -0,0 +1,165 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Target is a TLS endpoint whose certificate is checked.
+type Target struct {
+	Name string
+	// Address is host:port
+	Address string
+	// ServerName is sent as SNI and verified against the certificate
+	ServerName string
+}
+
+// ParseTargets parses a comma separated list of [name=]host[:port][/sni]
+// entries such as "example.com,mqtt=broker.lan:8883/mqtt.example.com". The
+// port defaults to 443, the SNI to the host and the name to the SNI.
+func ParseTargets(spec string) ([]Target, error) {
+	var targets []Target
+	names := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, address, found := strings.Cut(entry, "=")
+		if !found {
+			name, address = "", entry
+		}
+		address, serverName, _ := strings.Cut(address, "/")
+
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			host, port = address, "443"
+		}
+		host = strings.Trim(host, "[]")
+		if host == "" || port == "" {
+			return nil, fmt.Errorf("invalid certificate target %q, expected [name=]host[:port][/sni]", entry)
+		}
+		if serverName == "" {
+			serverName = host
+		}
+		if name = strings.TrimSpace(name); name == "" {
+			name = serverName
+		}
+		if names[name] {
+			return nil, fmt.Errorf("certificate target %s is configured twice", name)
+		}
+		names[name] = true
+		targets = append(targets, Target{
+			Name:       name,
+			Address:    net.JoinHostPort(host, port),
+			ServerName: serverName,
+		})
+	}
+	return targets, nil
+}
+
+// Certificate describes a certificate and the chain it was served with.
+type Certificate struct {
+	Subject  string
+	Issuer   string
+	DNSNames []string
+	// NotAfter is the earliest expiry in the chain, which is usually the
+	// leaf but can be an intermediate.
+	NotAfter time.Time
+	// Error is why the chain does not validate, empty when it does
+	Error string
+}
+
+// DaysLeft returns the days until the certificate expires, negative once
+// it expired.
+func (c Certificate) DaysLeft(now time.Time) float64 {
+	return c.NotAfter.Sub(now).Hours() / 24
+}
+
+// Inspect connects to target and reads the certificate chain. Validation
+// problems are reported in the certificate; an error is only returned when
+// no certificate could be read.
+func Inspect(ctx context.Context, target Target) (*Certificate, error) {
+	dialer := &tls.Dialer{Config: &tls.Config{
+		ServerName: target.ServerName,
+		// The chain is verified below, so invalid certificates are
+		// still read and reported.
+		InsecureSkipVerify: true,
+	}}
+	conn, err := dialer.DialContext(ctx, "tcp", target.Address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	chain := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no certificate presented")
+	}
+	leaf := chain[0]
+	cert := &Certificate{
+		Subject:  NameOf(leaf.Subject),
+		Issuer:   NameOf(leaf.Issuer),
+		DNSNames: leaf.DNSNames,
+		NotAfter: leaf.NotAfter,
+	}
+	for _, c := range chain[1:] {
+		if c.NotAfter.Before(cert.NotAfter) {
+			cert.NotAfter = c.NotAfter
+		}
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		DNSName:       target.ServerName,
+		Intermediates: intermediates,
+	}); err != nil {
+		cert.Error = err.Error()
+	}
+	return cert, nil
+}
+
+// NameOf returns a short name such as "Let's Encrypt R10" for the subject
+// or issuer of a certificate.
+func NameOf(name pkix.Name) string {
+	var organization string
+	if len(name.Organization) > 0 {
+		organization = name.Organization[0]
+	}
+	return joinName(organization, name.CommonName)
+}
+
+// ParseName returns the short name of an OpenSSL one line name such as
+// "/C=US/O=Let's Encrypt/CN=R10".
+func ParseName(s string) string {
+	var organization, commonName string
+	for _, part := range strings.Split(s, "/") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "O":
+			organization = value
+		case "CN":
+			commonName = value
+		}
+	}
+	return joinName(organization, commonName)
+}
+
+func joinName(organization, commonName string) string {
+	switch {
+	case organization == "" || strings.Contains(commonName, organization):
+		return commonName
+	case commonName == "":
+		return organization
+	}
+	return organization + " " + commonName
+}
@@ -0,0 +1,167 @@
+package diskhealth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+// Reader reads wear indicators of block devices. The paths can point at a
+// fixture tree.
+type Reader struct {
+	// SysRoot is the sysfs mount, usually /sys
+	SysRoot string
+	// Smartctl is the smartctl binary. SMART is not read when it is empty
+	// or cannot be run.
+	Smartctl string
+}
+
+func NewReader() *Reader {
+	return &Reader{SysRoot: "/sys", Smartctl: "smartctl"}
+}
+
+var (
+	// mmcblk0p2, nvme0n1p1
+	numberedPartition = regexp.MustCompile(`^((?:mmcblk|nvme\d+n)\d+)p\d+$`)
+	// sda1, vdb2
+	letteredPartition = regexp.MustCompile(`^([a-z]+[a-z])\d+$`)
+)
+
+// ParentDevice returns the disk a partition belongs to, e.g. mmcblk0 for
+// mmcblk0p2 and sda for sda1. Names that are no partition are returned as is.
+func ParentDevice(name string) string {
+	if strings.HasPrefix(name, "mmcblk") || strings.HasPrefix(name, "nvme") {
+		if m := numberedPartition.FindStringSubmatch(name); m != nil {
+			return m[1]
+		}
+		return name
+	}
+	if m := letteredPartition.FindStringSubmatch(name); m != nil {
+		return m[1]
+	}
+	return name
+}
+
+// Wear reads the wear indicators of a disk such as mmcblk0 or sda. eMMC
+// devices report them through sysfs, other disks through SMART. It returns
+// nil when neither is readable; SD cards expose no wear information.
+func (r *Reader) Wear(ctx context.Context, disk string) *types.DiskWear {
+	if wear, err := r.emmcWear(disk); err == nil {
+		return wear
+	}
+	if r.Smartctl == "" || strings.HasPrefix(disk, "mmcblk") {
+		return nil
+	}
+	wear, err := r.smartWear(ctx, disk)
+	if err != nil {
+		return nil
+	}
+	return wear
+}
+
+// emmcWear reads the JEDEC life time estimates. Each estimate is reported
+// in steps of 10% of the life time used, 0x0B meaning exceeded.
+func (r *Reader) emmcWear(disk string) (*types.DiskWear, error) {
+	device := filepath.Join(r.SysRoot, "block", disk, "device")
+	lifeTime, err := os.ReadFile(filepath.Join(device, "life_time"))
+	if err != nil {
+		return nil, err
+	}
+
+	wear := &types.DiskWear{Source: "emmc"}
+	var used float64
+	for _, field := range strings.Fields(string(lifeTime)) {
+		step, err := strconv.ParseUint(field, 0, 8)
+		if err != nil || step == 0 {
+			continue
+		}
+		if percent := float64(step) * 10; percent > used {
+			used = percent
+		}
+	}
+	if used > 0 {
+		wear.LifeUsedPercent = &used
+	}
+
+	if preEOL, err := os.ReadFile(filepath.Join(device, "pre_eol_info")); err == nil {
+		switch strings.TrimSpace(string(preEOL)) {
+		case "0x01", "0x1":
+			wear.PreEOL = "normal"
+		case "0x02", "0x2":
+			wear.PreEOL = "warning"
+		case "0x03", "0x3":
+			wear.PreEOL = "urgent"
+		}
+	}
+	return wear, nil
+}
+
+type smartctlOutput struct {
+	SmartStatus *struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+	NVMeLog *struct {
+		PercentageUsed float64 `json:"percentage_used"`
+	} `json:"nvme_smart_health_information_log"`
+	ATAAttributes *struct {
+		Table []struct {
+			ID    int     `json:"id"`
+			Value float64 `json:"value"`
+		} `json:"table"`
+	} `json:"ata_smart_attributes"`
+}
+
+// Normalised ATA attributes counting down from 100 as the drive wears
+var ataLifeAttributes = map[int]bool{
+	177: true, // Wear_Leveling_Count
+	202: true, // Percent_Lifetime_Remain
+	231: true, // SSD_Life_Left
+	233: true, // Media_Wearout_Indicator
+}
+
+func (r *Reader) smartWear(ctx context.Context, disk string) (*types.DiskWear, error) {
+	out, err := exec.CommandContext(ctx, r.Smartctl, "-j", "-H", "-A", "/dev/"+disk).Output()
+	// smartctl uses the exit status as a bit mask; only the lowest two
+	// bits mean that the device could not be queried at all
+	var exitErr *exec.ExitError
+	if err != nil && (!errors.As(err, &exitErr) || exitErr.ExitCode()&0x3 != 0) {
+		return nil, fmt.Errorf("failed to run %s on %s: %w", r.Smartctl, disk, err)
+	}
+
+	var parsed smartctlOutput
+	if err := json.Unmarshal(out, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to decode smartctl output: %w", err)
+	}
+
+	wear := &types.DiskWear{Source: "smart"}
+	if parsed.SmartStatus != nil {
+		passed := parsed.SmartStatus.Passed
+		wear.SMARTPassed = &passed
+	}
+	switch {
+	case parsed.NVMeLog != nil:
+		used := parsed.NVMeLog.PercentageUsed
+		wear.LifeUsedPercent = &used
+	case parsed.ATAAttributes != nil:
+		for _, attr := range parsed.ATAAttributes.Table {
+			if ataLifeAttributes[attr.ID] {
+				used := 100 - attr.Value
+				wear.LifeUsedPercent = &used
+				break
+			}
+		}
+	}
+	if wear.SMARTPassed == nil && wear.LifeUsedPercent == nil {
+		return nil, fmt.Errorf("no SMART data for %s", disk)
+	}
+	return wear, nil
+}
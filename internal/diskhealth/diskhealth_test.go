@@ -0,0 +1,152 @@
+package diskhealth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// writeFiles creates a fixture tree below root from paths and contents.
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// fakeSmartctl writes a smartctl that prints output and exits with code.
+func fakeSmartctl(t *testing.T, root, output string, code int) string {
+	t.Helper()
+	script := "#!/bin/sh\ncat <<'EOF'\n" + output + "\nEOF\nexit " + strconv.Itoa(code) + "\n"
+	writeFiles(t, root, map[string]string{"bin/smartctl": script})
+	return filepath.Join(root, "bin/smartctl")
+}
+
+func TestParentDevice(t *testing.T) {
+	tests := map[string]string{
+		"mmcblk0p2": "mmcblk0",
+		"mmcblk0":   "mmcblk0",
+		"nvme0n1p1": "nvme0n1",
+		"nvme0n1":   "nvme0n1",
+		"sda1":      "sda",
+		"vdb12":     "vdb",
+		"sda":       "sda",
+		"dm-0":      "dm-0",
+	}
+	for name, want := range tests {
+		if got := ParentDevice(name); got != want {
+			t.Errorf("ParentDevice(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestEMMCWear(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"block/mmcblk0/device/life_time":    "0x02 0x03\n",
+		"block/mmcblk0/device/pre_eol_info": "0x01\n",
+	})
+
+	wear := (&Reader{SysRoot: root}).Wear(context.Background(), "mmcblk0")
+	if wear == nil {
+		t.Fatal("Wear = nil for an eMMC device")
+	}
+	if wear.Source != "emmc" || wear.PreEOL != "normal" {
+		t.Errorf("wear = %+v, want emmc with normal pre-EOL", wear)
+	}
+	// The worse of both estimates counts
+	if wear.LifeUsedPercent == nil || *wear.LifeUsedPercent != 30 {
+		t.Errorf("LifeUsedPercent = %v, want 30", wear.LifeUsedPercent)
+	}
+}
+
+func TestSDCardWithoutWear(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{"block/mmcblk0/device/name": "SD64G\n"})
+	smartctl := fakeSmartctl(t, root, `{"smart_status": {"passed": true}}`, 0)
+
+	// SD cards are never queried with smartctl
+	if wear := (&Reader{SysRoot: root, Smartctl: smartctl}).Wear(context.Background(), "mmcblk0"); wear != nil {
+		t.Errorf("Wear = %+v for an SD card, want nil", wear)
+	}
+}
+
+func TestSMARTWear(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		code     int
+		wantNil  bool
+		wantUsed float64
+	}{
+		{
+			name:     "nvme",
+			output:   `{"smart_status": {"passed": true}, "nvme_smart_health_information_log": {"percentage_used": 7}}`,
+			wantUsed: 7,
+		},
+		{
+			name:     "ata",
+			output:   `{"smart_status": {"passed": true}, "ata_smart_attributes": {"table": [{"id": 9, "value": 99}, {"id": 177, "value": 92}]}}`,
+			wantUsed: 8,
+		},
+		{
+			// Bit 2 only reports a failed SMART command, the output is valid
+			name:     "partial failure",
+			output:   `{"smart_status": {"passed": false}, "nvme_smart_health_information_log": {"percentage_used": 100}}`,
+			code:     4,
+			wantUsed: 100,
+		},
+		{
+			name:    "device open failed",
+			output:  `{}`,
+			code:    2,
+			wantNil: true,
+		},
+		{
+			name:    "no SMART data",
+			output:  `{"device": {"name": "/dev/sda"}}`,
+			wantNil: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			reader := &Reader{SysRoot: root, Smartctl: fakeSmartctl(t, root, tt.output, tt.code)}
+
+			wear := reader.Wear(context.Background(), "sda")
+			if tt.wantNil {
+				if wear != nil {
+					t.Errorf("Wear = %+v, want nil", wear)
+				}
+				return
+			}
+			if wear == nil {
+				t.Fatal("Wear = nil")
+			}
+			if wear.Source != "smart" || wear.SMARTPassed == nil {
+				t.Errorf("wear = %+v, want smart with a status", wear)
+			}
+			if wear.LifeUsedPercent == nil || *wear.LifeUsedPercent != tt.wantUsed {
+				t.Errorf("LifeUsedPercent = %v, want %v", wear.LifeUsedPercent, tt.wantUsed)
+			}
+		})
+	}
+}
+
+func TestWearWithoutSmartctl(t *testing.T) {
+	root := t.TempDir()
+	if wear := (&Reader{SysRoot: root}).Wear(context.Background(), "sda"); wear != nil {
+		t.Errorf("Wear = %+v without smartctl, want nil", wear)
+	}
+	if wear := (&Reader{SysRoot: root, Smartctl: "smartctl-not-installed"}).Wear(context.Background(), "sda"); wear != nil {
+		t.Errorf("Wear = %+v with a missing smartctl, want nil", wear)
+	}
+}
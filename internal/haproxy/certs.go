@@ -0,0 +1,116 @@
+package haproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+// Certificate is a certificate file loaded by HAProxy, as reported by
+// "show ssl cert".
+type Certificate struct {
+	File     string
+	Subject  string
+	Issuer   string
+	DNSNames []string
+	NotAfter time.Time
+}
+
+// opensslTime is the layout of dates in "show ssl cert" output.
+const opensslTime = "Jan _2 15:04:05 2006 MST"
+
+// GetCertificates lists the certificate files HAProxy loaded and reads
+// their details. Files that fail to be read are logged and skipped.
+func (c *Client) GetCertificates(ctx context.Context) ([]Certificate, error) {
+	output, err := c.command(ctx, "show ssl cert")
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []Certificate
+	for _, line := range strings.Split(output, "\n") {
+		file := strings.TrimSpace(line)
+		// Comments and uncommitted transactions, which are prefixed by *
+		if file == "" || strings.HasPrefix(file, "#") || strings.HasPrefix(file, "*") {
+			continue
+		}
+		details, err := c.command(ctx, "show ssl cert "+file)
+		if err != nil {
+			log.Printf("Warning: Failed to read HAProxy certificate %s: %v", file, err)
+			continue
+		}
+		cert, err := parseCertificate(details)
+		if err != nil {
+			log.Printf("Warning: Failed to parse HAProxy certificate %s: %v", file, err)
+			continue
+		}
+		if cert.File == "" {
+			cert.File = file
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// parseCertificate parses the "key: value" lines HAProxy prints for a
+// certificate file. Only the first Subject and Issuer belong to the leaf;
+// the chain follows as "Chain Subject" and "Chain Issuer".
+func parseCertificate(output string) (Certificate, error) {
+	var cert Certificate
+	for _, line := range strings.Split(output, "\n") {
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "Filename":
+			cert.File = value
+		case "Subject":
+			cert.Subject = value
+		case "Issuer":
+			cert.Issuer = value
+		case "Subject Alternative Name":
+			for _, name := range strings.Split(value, ",") {
+				if name, ok := strings.CutPrefix(strings.TrimSpace(name), "DNS:"); ok {
+					cert.DNSNames = append(cert.DNSNames, name)
+				}
+			}
+		case "notAfter":
+			notAfter, err := time.Parse(opensslTime, value)
+			if err != nil {
+				return cert, fmt.Errorf("invalid notAfter %q: %w", value, err)
+			}
+			cert.NotAfter = notAfter
+		}
+	}
+	if cert.NotAfter.IsZero() {
+		return cert, fmt.Errorf("no notAfter in output")
+	}
+	return cert, nil
+}
+
+// command sends a command over the runtime API and returns the response.
+// HAProxy closes the connection after one command.
+func (c *Client) command(ctx context.Context, command string) (string, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(command + "\n")); err != nil {
+		return "", fmt.Errorf("failed to send command: %w", err)
+	}
+	output, err := io.ReadAll(conn)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	if strings.HasPrefix(string(output), "Unknown command") {
+		return "", fmt.Errorf("command %q not supported", command)
+	}
+	return string(output), nil
+}
@@ -0,0 +1,89 @@
+package haproxy
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSocket serves responses on a runtime API socket like HAProxy does,
+// one command per connection. Other commands are answered as unknown.
+func fakeSocket(t *testing.T, responses map[string]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "haproxy.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			command, _ := bufio.NewReader(conn).ReadString('\n')
+			if response, ok := responses[strings.TrimSpace(command)]; ok {
+				conn.Write([]byte(response))
+			} else {
+				conn.Write([]byte("Unknown command\n"))
+			}
+			conn.Close()
+		}
+	}()
+	return path
+}
+
+const siteCert = `Filename: /etc/haproxy/certs/site.pem
+Status: Used
+Serial: 0A1B
+notBefore: Jun  1 00:00:00 2025 GMT
+notAfter: Aug 30 23:59:59 2025 GMT
+Subject Alternative Name: DNS:example.com, DNS:www.example.com
+Subject: /CN=example.com
+Issuer: /C=US/O=Let's Encrypt/CN=R11
+Chain Subject: /C=US/O=Let's Encrypt/CN=R11
+Chain Issuer: /C=US/O=Internet Security Research Group/CN=ISRG Root X1
+`
+
+func TestGetCertificates(t *testing.T) {
+	socket := fakeSocket(t, map[string]string{
+		"show ssl cert": "# filename\n/etc/haproxy/certs/site.pem\n/etc/haproxy/certs/broken.pem\n/etc/haproxy/certs/gone.pem\n*/etc/haproxy/certs/new.pem\n",
+		"show ssl cert /etc/haproxy/certs/site.pem":   siteCert,
+		"show ssl cert /etc/haproxy/certs/broken.pem": "Filename: /etc/haproxy/certs/broken.pem\nnotAfter: soon\n",
+	})
+
+	// A file that fails to be read or parsed does not hide the others
+	certs, err := NewClient(socket).GetCertificates(context.Background())
+	if err != nil {
+		t.Fatalf("GetCertificates: %v", err)
+	}
+	if len(certs) != 1 {
+		t.Fatalf("got %d certificates, want 1: %+v", len(certs), certs)
+	}
+	cert := certs[0]
+	if cert.File != "/etc/haproxy/certs/site.pem" || cert.Subject != "/CN=example.com" {
+		t.Errorf("certificate = %+v", cert)
+	}
+	// The chain does not overwrite the leaf
+	if cert.Issuer != "/C=US/O=Let's Encrypt/CN=R11" {
+		t.Errorf("Issuer = %q, want the leaf issuer", cert.Issuer)
+	}
+	if len(cert.DNSNames) != 2 || cert.DNSNames[1] != "www.example.com" {
+		t.Errorf("DNSNames = %q", cert.DNSNames)
+	}
+	if cert.NotAfter.Year() != 2025 || cert.NotAfter.Month() != 8 || cert.NotAfter.Day() != 30 {
+		t.Errorf("NotAfter = %s", cert.NotAfter)
+	}
+}
+
+func TestGetCertificatesUnsupported(t *testing.T) {
+	// HAProxy before 2.2 has no "show ssl cert"
+	if _, err := NewClient(fakeSocket(t, nil)).GetCertificates(context.Background()); err == nil {
+		t.Error("GetCertificates returned no error without \"show ssl cert\"")
+	}
+}
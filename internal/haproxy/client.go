@@ -2,6 +2,7 @@ package haproxy
 
 import (
 	"bufio"
+	"context"
 	"encoding/csv"
 	"fmt"
 	"io"
@@ -9,6 +10,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
 )
 
 type Client struct {
@@ -20,21 +23,38 @@ type Stats struct {
 }
 
 type Backend struct {
-	Name         string
-	Status       string
-	Active       bool
-	CheckStatus  string
-	CheckCode    int
+	Name          string
+	Status        string
+	Health        types.Health
+	ServersUp     int
+	ServersTotal  int
+	CheckStatus   string
+	CheckCode     int
+	CheckDuration int
+	LastChange    int
+	Downtime      int
+	ConnRate      int
+	ConnRateMax   int
+	SessionRate   int
+	SessionCur    int
+	SessionMax    int
+	BytesIn       int64
+	BytesOut      int64
+	// Servers are the server rows of the backend in configuration order
+	Servers []Server
+}
+
+// Server is a server row of a backend.
+type Server struct {
+	Name          string
+	Status        string
+	Health        types.Health
+	CheckStatus   string
 	CheckDuration int
-	LastChange   int
-	Downtime     int
-	ConnRate     int
-	ConnRateMax  int
-	SessionRate  int
-	SessionCur   int
-	SessionMax   int
-	BytesIn      int64
-	BytesOut     int64
+	LastChange    int
+	SessionRate   int
+	SessionCur    int
+	Weight        int
 }
 
 func NewClient(socketPath string) *Client {
@@ -43,15 +63,40 @@ func NewClient(socketPath string) *Client {
 	}
 }
 
-func (c *Client) GetStats() (*Stats, error) {
-	conn, err := net.Dial("unix", c.socketPath)
+// socketTimeout bounds a command on the socket, also when ctx has no
+// deadline.
+const socketTimeout = 5 * time.Second
+
+// dial connects to the socket. The connection is closed when ctx is done,
+// so a stuck HAProxy never blocks the caller past its deadline.
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", c.socketPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to HAProxy socket: %w", err)
 	}
-	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(socketTimeout))
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	return &ctxConn{Conn: conn, stop: stop}, nil
+}
+
+// ctxConn stops closing the connection on cancellation once it is closed.
+type ctxConn struct {
+	net.Conn
+	stop func() bool
+}
+
+func (c *ctxConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
 
-	// Set timeout
-	conn.SetDeadline(time.Now().Add(5 * time.Second))
+func (c *Client) GetStats(ctx context.Context) (*Stats, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
 
 	// Send command
 	_, err = conn.Write([]byte("show stat\n"))
@@ -59,15 +104,17 @@ func (c *Client) GetStats() (*Stats, error) {
 		return nil, fmt.Errorf("failed to send command: %w", err)
 	}
 
-	// Read response
-	reader := csv.NewReader(bufio.NewReader(conn))
-	reader.Comment = '#'
-	
-	// Read header
-	header, err := reader.Read()
+	// Read header, which HAProxy prefixes with "# "
+	buffered := bufio.NewReader(conn)
+	line, err := buffered.ReadString('\n')
 	if err != nil {
 		return nil, fmt.Errorf("failed to read header: %w", err)
 	}
+	header := strings.Split(strings.TrimPrefix(strings.TrimSpace(line), "# "), ",")
+
+	// Read response
+	reader := csv.NewReader(buffered)
+	reader.Comment = '#'
 
 	// Create column index map
 	colIndex := make(map[string]int)
@@ -79,6 +126,11 @@ func (c *Client) GetStats() (*Stats, error) {
 		Backends: make([]Backend, 0),
 	}
 
+	// Server rows precede their BACKEND row, so collect them per proxy first
+	type serverCount struct{ up, total int }
+	servers := make(map[string]*serverCount)
+	serverRows := make(map[string][]Server)
+
 	// Read data rows
 	for {
 		record, err := reader.Read()
@@ -89,71 +141,145 @@ func (c *Client) GetStats() (*Stats, error) {
 			return nil, fmt.Errorf("failed to read row: %w", err)
 		}
 
-		// Only process BACKEND type entries
-		if record[colIndex["svname"]] == "BACKEND" {
-			backend := Backend{
-				Name:        record[colIndex["pxname"]],
-				Status:      record[colIndex["status"]],
-				Active:      record[colIndex["status"]] == "UP",
-			}
+		svname := record[colIndex["svname"]]
+		if svname == "FRONTEND" {
+			continue
+		}
 
-			// Parse numeric fields
-			if val := record[colIndex["check_status"]]; val != "" {
-				backend.CheckStatus = val
-			}
-			if val := record[colIndex["check_code"]]; val != "" {
-				backend.CheckCode, _ = strconv.Atoi(val)
-			}
-			if val := record[colIndex["check_duration"]]; val != "" {
-				backend.CheckDuration, _ = strconv.Atoi(val)
-			}
-			if val := record[colIndex["lastchg"]]; val != "" {
-				backend.LastChange, _ = strconv.Atoi(val)
-			}
-			if val := record[colIndex["downtime"]]; val != "" {
-				backend.Downtime, _ = strconv.Atoi(val)
-			}
-			if val := record[colIndex["rate"]]; val != "" {
-				backend.ConnRate, _ = strconv.Atoi(val)
-			}
-			if val := record[colIndex["rate_max"]]; val != "" {
-				backend.ConnRateMax, _ = strconv.Atoi(val)
-			}
-			if val := record[colIndex["stot"]]; val != "" {
-				backend.SessionRate, _ = strconv.Atoi(val)
-			}
-			if val := record[colIndex["scur"]]; val != "" {
-				backend.SessionCur, _ = strconv.Atoi(val)
-			}
-			if val := record[colIndex["smax"]]; val != "" {
-				backend.SessionMax, _ = strconv.Atoi(val)
+		// Count server rows so backends can report partial outages.
+		// Servers in maintenance or drain are excluded on purpose.
+		if svname != "BACKEND" {
+			pxname := record[colIndex["pxname"]]
+			count, ok := servers[pxname]
+			if !ok {
+				count = &serverCount{}
+				servers[pxname] = count
 			}
-			if val := record[colIndex["bin"]]; val != "" {
-				backend.BytesIn, _ = strconv.ParseInt(val, 10, 64)
-			}
-			if val := record[colIndex["bout"]]; val != "" {
-				backend.BytesOut, _ = strconv.ParseInt(val, 10, 64)
+			if status := record[colIndex["status"]]; !strings.HasPrefix(status, "MAINT") && status != "DRAIN" {
+				count.total++
+				if strings.HasPrefix(status, "UP") || status == "no check" {
+					count.up++
+				}
 			}
+			serverRows[pxname] = append(serverRows[pxname], parseServer(record, colIndex))
+			continue
+		}
+
+		backend := Backend{
+			Name:   record[colIndex["pxname"]],
+			Status: record[colIndex["status"]],
+		}
+		if count, ok := servers[backend.Name]; ok {
+			backend.ServersUp = count.up
+			backend.ServersTotal = count.total
+		}
+		backend.Servers = serverRows[backend.Name]
+		backend.Health = BackendHealth(backend.Status, backend.ServersUp, backend.ServersTotal)
 
-			stats.Backends = append(stats.Backends, backend)
+		// Parse numeric fields
+		if val := record[colIndex["check_status"]]; val != "" {
+			backend.CheckStatus = val
+		}
+		if val := record[colIndex["check_code"]]; val != "" {
+			backend.CheckCode, _ = strconv.Atoi(val)
+		}
+		if val := record[colIndex["check_duration"]]; val != "" {
+			backend.CheckDuration, _ = strconv.Atoi(val)
+		}
+		if val := record[colIndex["lastchg"]]; val != "" {
+			backend.LastChange, _ = strconv.Atoi(val)
+		}
+		if val := record[colIndex["downtime"]]; val != "" {
+			backend.Downtime, _ = strconv.Atoi(val)
+		}
+		if val := record[colIndex["rate"]]; val != "" {
+			backend.ConnRate, _ = strconv.Atoi(val)
+		}
+		if val := record[colIndex["rate_max"]]; val != "" {
+			backend.ConnRateMax, _ = strconv.Atoi(val)
+		}
+		if val := record[colIndex["stot"]]; val != "" {
+			backend.SessionRate, _ = strconv.Atoi(val)
+		}
+		if val := record[colIndex["scur"]]; val != "" {
+			backend.SessionCur, _ = strconv.Atoi(val)
+		}
+		if val := record[colIndex["smax"]]; val != "" {
+			backend.SessionMax, _ = strconv.Atoi(val)
+		}
+		if val := record[colIndex["bin"]]; val != "" {
+			backend.BytesIn, _ = strconv.ParseInt(val, 10, 64)
+		}
+		if val := record[colIndex["bout"]]; val != "" {
+			backend.BytesOut, _ = strconv.ParseInt(val, 10, 64)
 		}
+
+		stats.Backends = append(stats.Backends, backend)
 	}
 
 	return stats, nil
 }
 
-func (c *Client) IsHealthy() bool {
-	stats, err := c.GetStats()
+// parseServer reads a server row of show stat.
+func parseServer(record []string, colIndex map[string]int) Server {
+	server := Server{
+		Name:        record[colIndex["svname"]],
+		Status:      record[colIndex["status"]],
+		CheckStatus: record[colIndex["check_status"]],
+	}
+	server.Health = ServerHealth(server.Status)
+	server.CheckDuration, _ = strconv.Atoi(record[colIndex["check_duration"]])
+	server.LastChange, _ = strconv.Atoi(record[colIndex["lastchg"]])
+	server.SessionRate, _ = strconv.Atoi(record[colIndex["rate"]])
+	server.SessionCur, _ = strconv.Atoi(record[colIndex["scur"]])
+	server.Weight, _ = strconv.Atoi(record[colIndex["weight"]])
+	return server
+}
+
+func (c *Client) IsHealthy(ctx context.Context) bool {
+	stats, err := c.GetStats(ctx)
 	if err != nil {
 		return false
 	}
-	
+
 	// Check if any backend is down
 	for _, backend := range stats.Backends {
-		if !backend.Active {
+		if backend.Health.IsOutage() {
 			return false
 		}
 	}
-	
+
 	return true
-}
\ No newline at end of file
+}
+
+// BackendHealth maps a HAProxy backend status and its server counts onto the
+// shared health model. Transitional states such as "UP 1/3" are reported
+// while checks are failing but the fall threshold has not been reached yet.
+func BackendHealth(status string, serversUp, serversTotal int) types.Health {
+	switch {
+	case status == "DOWN" || strings.HasPrefix(status, "DOWN "):
+		return types.HealthMajorOutage
+	case strings.HasPrefix(status, "MAINT") || status == "DRAIN":
+		return types.HealthMaintenance
+	case status == "NOLB" || strings.HasPrefix(status, "UP "):
+		return types.HealthDegraded
+	case status == "UP":
+		if serversTotal > 0 && serversUp == 0 {
+			return types.HealthMajorOutage
+		}
+		if serversUp < serversTotal {
+			return types.HealthPartialOutage
+		}
+		return types.HealthOperational
+	}
+	return types.HealthUnknown
+}
+
+// ServerHealth maps the status of a server row onto the shared health
+// model. Servers without health checks are assumed to be up.
+func ServerHealth(status string) types.Health {
+	if status == "no check" {
+		return types.HealthOperational
+	}
+	return BackendHealth(status, 0, 0)
+}
@@ -0,0 +1,98 @@
+package haproxy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+func TestBackendHealth(t *testing.T) {
+	tests := []struct {
+		status    string
+		up, total int
+		want      types.Health
+	}{
+		{"UP", 3, 3, types.HealthOperational},
+		{"UP", 0, 0, types.HealthOperational},
+		{"UP", 2, 3, types.HealthPartialOutage},
+		{"UP", 0, 3, types.HealthMajorOutage},
+		{"UP 1/3", 3, 3, types.HealthDegraded},
+		{"NOLB", 3, 3, types.HealthDegraded},
+		{"DOWN", 0, 3, types.HealthMajorOutage},
+		{"DOWN 1/2", 0, 3, types.HealthMajorOutage},
+		{"MAINT", 0, 0, types.HealthMaintenance},
+		{"MAINT (resolution)", 0, 0, types.HealthMaintenance},
+		{"DRAIN", 3, 3, types.HealthMaintenance},
+		{"", 0, 0, types.HealthUnknown},
+		{"DOWNTIME", 0, 0, types.HealthUnknown},
+	}
+	for _, tt := range tests {
+		if got := BackendHealth(tt.status, tt.up, tt.total); got != tt.want {
+			t.Errorf("BackendHealth(%q, %d, %d) = %q, want %q", tt.status, tt.up, tt.total, got, tt.want)
+		}
+	}
+}
+
+func TestServerHealth(t *testing.T) {
+	tests := []struct {
+		status string
+		want   types.Health
+	}{
+		{"UP", types.HealthOperational},
+		{"no check", types.HealthOperational},
+		{"UP 1/3", types.HealthDegraded},
+		{"DOWN", types.HealthMajorOutage},
+		{"MAINT", types.HealthMaintenance},
+		{"DRAIN", types.HealthMaintenance},
+	}
+	for _, tt := range tests {
+		if got := ServerHealth(tt.status); got != tt.want {
+			t.Errorf("ServerHealth(%q) = %q, want %q", tt.status, got, tt.want)
+		}
+	}
+}
+
+const stats = `# pxname,svname,status,check_status,check_code,check_duration,lastchg,downtime,rate,rate_max,stot,scur,smax,bin,bout,weight
+web,FRONTEND,OPEN,,,,,,,,,,,,,
+api,a,UP,L7OK,200,3,60,0,0,0,0,0,0,0,0,1
+api,b,DOWN,L4CON,,1,30,30,0,0,0,0,0,0,0,1
+api,c,MAINT,,,,10,0,0,0,0,0,0,0,0,1
+api,BACKEND,UP,,,,60,0,0,0,0,0,0,0,0,2
+db,a,DOWN,L4TOUT,,,30,30,0,0,0,0,0,0,0,1
+db,b,DRAIN,L7OK,200,2,30,0,0,0,0,0,0,0,0,0
+db,BACKEND,UP,,,,30,30,0,0,0,0,0,0,0,0
+static,a,no check,,,,90,0,0,0,0,0,0,0,0,1
+static,BACKEND,UP,,,,90,0,0,0,0,0,0,0,0,1
+`
+
+func TestGetStatsCountsServers(t *testing.T) {
+	socket := fakeSocket(t, map[string]string{"show stat": stats})
+	got, err := NewClient(socket).GetStats(context.Background())
+	if err != nil {
+		t.Fatalf("GetStats: %v", err)
+	}
+
+	// Servers in maintenance or drain do not count towards an outage
+	want := map[string]struct {
+		up, total int
+		health    types.Health
+	}{
+		"api":    {1, 2, types.HealthPartialOutage},
+		"db":     {0, 1, types.HealthMajorOutage},
+		"static": {1, 1, types.HealthOperational},
+	}
+	if len(got.Backends) != len(want) {
+		t.Fatalf("got %d backends, want %d", len(got.Backends), len(want))
+	}
+	for _, backend := range got.Backends {
+		w := want[backend.Name]
+		if backend.ServersUp != w.up || backend.ServersTotal != w.total || backend.Health != w.health {
+			t.Errorf("%s: %d/%d servers up, health %q, want %d/%d, %q",
+				backend.Name, backend.ServersUp, backend.ServersTotal, backend.Health, w.up, w.total, w.health)
+		}
+	}
+	if servers := got.Backends[0].Servers; len(servers) != 3 || servers[2].Health != types.HealthMaintenance {
+		t.Errorf("api servers = %+v", servers)
+	}
+}
@@ -0,0 +1,169 @@
+package homeassistant
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+type Config struct {
+	// URL is the base URL of Home Assistant, e.g. http://192.168.2.136:8123
+	URL string
+	// Token is a long-lived access token of an admin user
+	Token string
+	// Entities are globs of entity IDs reported as services,
+	// e.g. light.* or switch.kitchen_*
+	Entities []string
+}
+
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// CoreConfig is the part of /api/config the status page uses.
+type CoreConfig struct {
+	Version      string `json:"version"`
+	State        string `json:"state"`
+	SafeMode     bool   `json:"safe_mode"`
+	RecoveryMode bool   `json:"recovery_mode"`
+}
+
+type EntityState struct {
+	EntityID    string                 `json:"entity_id"`
+	State       string                 `json:"state"`
+	Attributes  map[string]interface{} `json:"attributes"`
+	LastChanged time.Time              `json:"last_changed"`
+}
+
+// ConfigEntry is a configured integration.
+type ConfigEntry struct {
+	EntryID string `json:"entry_id"`
+	Domain  string `json:"domain"`
+	Title   string `json:"title"`
+	// State is e.g. loaded, setup_error, setup_retry or not_loaded
+	State  string `json:"state"`
+	Reason string `json:"reason"`
+	// DisabledBy is set for entries disabled on purpose
+	DisabledBy string `json:"disabled_by"`
+}
+
+// NewClient creates a client for the Home Assistant REST API at baseURL.
+func NewClient(baseURL, token string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *Client) GetConfig(ctx context.Context) (*CoreConfig, error) {
+	var config CoreConfig
+	if err := c.get(ctx, "/api/config", &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (c *Client) GetStates(ctx context.Context) ([]EntityState, error) {
+	var states []EntityState
+	if err := c.get(ctx, "/api/states", &states); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// GetConfigEntries lists the configured integrations. The endpoint is the
+// one used by the frontend and requires an admin token.
+func (c *Client) GetConfigEntries(ctx context.Context) ([]ConfigEntry, error) {
+	var entries []ConfigEntry
+	if err := c.get(ctx, "/api/config/config_entries/entry", &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (c *Client) get(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to query %s: %s", endpoint, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", endpoint, err)
+	}
+	return nil
+}
+
+// FriendlyName returns the friendly_name attribute, or the entity ID.
+func (s EntityState) FriendlyName() string {
+	if name, ok := s.Attributes["friendly_name"].(string); ok && name != "" {
+		return name
+	}
+	return s.EntityID
+}
+
+// Failed reports whether the integration should be loaded but is not.
+func (e ConfigEntry) Failed() bool {
+	if e.DisabledBy != "" {
+		return false
+	}
+	switch e.State {
+	case "setup_error", "setup_retry", "migration_error", "failed_unload":
+		return true
+	}
+	return false
+}
+
+// EntityHealth maps an entity state onto the shared health model. Entities
+// are only judged by their availability, not by their value.
+func EntityHealth(state string) types.Health {
+	switch state {
+	case "unavailable":
+		return types.HealthMajorOutage
+	case "unknown":
+		return types.HealthUnknown
+	}
+	return types.HealthOperational
+}
+
+// CoreHealth maps the state of Home Assistant itself onto the shared health
+// model.
+func CoreHealth(config *CoreConfig, failedIntegrations int) types.Health {
+	switch {
+	case config.State != "" && config.State != "RUNNING":
+		// Starting, stopping or writing its final state
+		return types.HealthMaintenance
+	case config.SafeMode || config.RecoveryMode || failedIntegrations > 0:
+		return types.HealthDegraded
+	}
+	return types.HealthOperational
+}
+
+// MatchEntity reports whether entityID matches any of the globs.
+func MatchEntity(globs []string, entityID string) bool {
+	for _, glob := range globs {
+		if matched, _ := path.Match(glob, entityID); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package homeassistant
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+const testToken = "test-token"
+
+// newTestServer stands in for the Home Assistant API. Requests without the
+// test token are rejected like Home Assistant does.
+func newTestServer(t *testing.T, responses map[string]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			http.Error(w, "401: Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGetConfig(t *testing.T) {
+	server := newTestServer(t, map[string]string{
+		"/api/config": `{"version": "2025.7.1", "state": "RUNNING", "safe_mode": false, "recovery_mode": false}`,
+	})
+
+	config, err := NewClient(server.URL+"/", testToken).GetConfig(context.Background())
+	if err != nil {
+		t.Fatalf("GetConfig: %v", err)
+	}
+	if config.Version != "2025.7.1" || config.State != "RUNNING" {
+		t.Errorf("config = %+v", config)
+	}
+	if health := CoreHealth(config, 0); health != types.HealthOperational {
+		t.Errorf("CoreHealth = %q, want operational", health)
+	}
+}
+
+func TestGetStates(t *testing.T) {
+	server := newTestServer(t, map[string]string{
+		"/api/states": `[
+			{"entity_id": "light.kitchen", "state": "on", "attributes": {"friendly_name": "Kitchen"}, "last_changed": "2025-07-01T10:00:00+00:00"},
+			{"entity_id": "sensor.garden", "state": "unavailable", "attributes": {}, "last_changed": "2025-07-01T11:00:00+00:00"},
+			{"entity_id": "switch.pump", "state": "unknown", "attributes": {"friendly_name": ""}, "last_changed": "2025-07-01T12:00:00+00:00"}
+		]`,
+	})
+
+	states, err := NewClient(server.URL, testToken).GetStates(context.Background())
+	if err != nil {
+		t.Fatalf("GetStates: %v", err)
+	}
+	want := []struct {
+		id, name string
+		health   types.Health
+	}{
+		{"light.kitchen", "Kitchen", types.HealthOperational},
+		{"sensor.garden", "sensor.garden", types.HealthMajorOutage},
+		{"switch.pump", "switch.pump", types.HealthUnknown},
+	}
+	if len(states) != len(want) {
+		t.Fatalf("got %d states, want %d", len(states), len(want))
+	}
+	for i, w := range want {
+		state := states[i]
+		if state.EntityID != w.id || state.FriendlyName() != w.name {
+			t.Errorf("state %d = %s (%s), want %s (%s)", i, state.EntityID, state.FriendlyName(), w.id, w.name)
+		}
+		if health := EntityHealth(state.State); health != w.health {
+			t.Errorf("EntityHealth(%q) = %q, want %q", state.State, health, w.health)
+		}
+	}
+}
+
+func TestGetConfigEntries(t *testing.T) {
+	server := newTestServer(t, map[string]string{
+		"/api/config/config_entries/entry": `[
+			{"entry_id": "1", "domain": "hue", "title": "Hue", "state": "loaded"},
+			{"entry_id": "2", "domain": "zha", "title": "Zigbee", "state": "setup_retry", "reason": "timeout"},
+			{"entry_id": "3", "domain": "cast", "title": "Cast", "state": "not_loaded", "disabled_by": "user"}
+		]`,
+	})
+
+	entries, err := NewClient(server.URL, testToken).GetConfigEntries(context.Background())
+	if err != nil {
+		t.Fatalf("GetConfigEntries: %v", err)
+	}
+	var failed []string
+	for _, entry := range entries {
+		if entry.Failed() {
+			failed = append(failed, entry.Domain)
+		}
+	}
+	if len(failed) != 1 || failed[0] != "zha" {
+		t.Errorf("failed integrations = %q, want [zha]", failed)
+	}
+}
+
+func TestRejectedToken(t *testing.T) {
+	server := newTestServer(t, nil)
+
+	_, err := NewClient(server.URL, "wrong-token").GetConfig(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("GetConfig with a wrong token: error = %v, want 401", err)
+	}
+}
+
+func TestNonOKStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	_, err := NewClient(server.URL, testToken).GetStates(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("GetStates: error = %v, want 502", err)
+	}
+}
+
+func TestCoreHealth(t *testing.T) {
+	tests := []struct {
+		config CoreConfig
+		failed int
+		want   types.Health
+	}{
+		{CoreConfig{State: "RUNNING"}, 0, types.HealthOperational},
+		{CoreConfig{State: "STARTING"}, 0, types.HealthMaintenance},
+		{CoreConfig{State: "RUNNING", RecoveryMode: true}, 0, types.HealthDegraded},
+		{CoreConfig{State: "RUNNING"}, 2, types.HealthDegraded},
+	}
+	for _, tt := range tests {
+		if got := CoreHealth(&tt.config, tt.failed); got != tt.want {
+			t.Errorf("CoreHealth(%+v, %d) = %q, want %q", tt.config, tt.failed, got, tt.want)
+		}
+	}
+}
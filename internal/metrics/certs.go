@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hra42/iot-hub-statuspage/internal/certs"
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+const certGroup = "Certificates"
+
+// CertConfig configures the TLS certificate checks.
+type CertConfig struct {
+	// Targets are the endpoints whose certificates are checked
+	Targets []certs.Target
+	// HAProxy also checks the certificate files loaded by HAProxy
+	HAProxy bool
+	// WarningDays and CriticalDays are the days before expiry at which a
+	// certificate is degraded and a major outage
+	WarningDays  float64
+	CriticalDays float64
+}
+
+// collectCertificates connects to the targets in parallel and reads the
+// certificates loaded by HAProxy.
+func (c *Collector) collectCertificates(ctx context.Context) (*Result, error) {
+	type inspection struct {
+		cert *certs.Certificate
+		err  error
+	}
+	inspections := make([]inspection, len(c.certs.Targets))
+	var wg sync.WaitGroup
+	for i, target := range c.certs.Targets {
+		wg.Add(1)
+		go func(i int, target certs.Target) {
+			defer wg.Done()
+			cert, err := certs.Inspect(ctx, target)
+			inspections[i] = inspection{cert, err}
+		}(i, target)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	result := &Result{}
+	for i, target := range c.certs.Targets {
+		cert, err := inspections[i].cert, inspections[i].err
+		if err != nil {
+			result.Services = append(result.Services, types.ServiceStatus{
+				Name:    target.Name,
+				Group:   certGroup,
+				Status:  "unreachable",
+				Health:  types.HealthUnknown,
+				Details: fmt.Sprintf("Failed to read certificate from %s: %v", target.Address, err),
+			})
+			continue
+		}
+		c.addCertificate(result, target.Name, *cert, now)
+	}
+
+	if !c.certs.HAProxy {
+		return result, nil
+	}
+	loaded, err := c.haproxy.GetCertificates(ctx)
+	if err != nil {
+		return result, fmt.Errorf("failed to read HAProxy certificates: %w", err)
+	}
+	for _, cert := range loaded {
+		c.addCertificate(result, filepath.Base(cert.File), certs.Certificate{
+			Subject:  certs.ParseName(cert.Subject),
+			Issuer:   certs.ParseName(cert.Issuer),
+			DNSNames: cert.DNSNames,
+			NotAfter: cert.NotAfter,
+		}, now)
+	}
+	return result, nil
+}
+
+// addCertificate adds the service and metrics of a certificate to result.
+func (c *Collector) addCertificate(result *Result, name string, cert certs.Certificate, now time.Time) {
+	days := cert.DaysLeft(now)
+	labels := map[string]string{"cert": name}
+	// The issuer changes with a renewal, so it is only shown in the details
+	// and the series of a certificate stays the same
+	result.Metrics = append(result.Metrics,
+		types.Metric{
+			Name:   "cert_expiry_days",
+			Value:  days,
+			Labels: map[string]string{"cert": name, "subject": cert.Subject},
+		},
+		types.Metric{Name: "cert_valid", Value: boolValue(cert.Error == "" && days > 0), Labels: labels},
+	)
+
+	status := types.ServiceStatus{Name: name, Group: certGroup}
+	switch {
+	case days <= 0:
+		status.Status = "expired"
+		status.Health = types.HealthMajorOutage
+		status.Details = fmt.Sprintf("Expired %s ago", formatDuration(now.Sub(cert.NotAfter)))
+	case cert.Error != "":
+		status.Status = "invalid"
+		status.Health = types.HealthMajorOutage
+		status.Details = cert.Error
+	default:
+		status.Status = "valid"
+		switch {
+		case days <= c.certs.CriticalDays:
+			status.Health = types.HealthMajorOutage
+		case days <= c.certs.WarningDays:
+			status.Health = types.HealthDegraded
+		default:
+			status.Health = types.HealthOperational
+		}
+		status.Details = fmt.Sprintf("Expires in %s", formatDuration(cert.NotAfter.Sub(now)))
+	}
+	if cert.Issuer != "" {
+		status.Details += ", issued by " + cert.Issuer
+	}
+	result.Services = append(result.Services, status)
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hra42/iot-hub-statuspage/internal/certs"
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+func TestAddCertificate(t *testing.T) {
+	c := &Collector{certs: &CertConfig{WarningDays: 14, CriticalDays: 3}}
+	now := time.Now()
+	result := &Result{}
+	c.addCertificate(result, "site", certs.Certificate{
+		Subject:  "example.com",
+		Issuer:   "R11",
+		NotAfter: now.Add(10 * 24 * time.Hour),
+	}, now)
+
+	if len(result.Services) != 1 {
+		t.Fatalf("got %d services, want 1", len(result.Services))
+	}
+	status := result.Services[0]
+	if status.Health != types.HealthDegraded {
+		t.Errorf("health = %q within the warning days, want degraded", status.Health)
+	}
+	if !strings.HasSuffix(status.Details, ", issued by R11") {
+		t.Errorf("details = %q", status.Details)
+	}
+
+	for _, metric := range result.Metrics {
+		if metric.Name != "cert_expiry_days" {
+			continue
+		}
+		// A renewal by another CA continues the series
+		if _, ok := metric.Labels["issuer"]; ok || metric.Labels["cert"] != "site" || metric.Labels["subject"] != "example.com" {
+			t.Errorf("cert_expiry_days labels = %v, want cert and subject", metric.Labels)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hra42/iot-hub-statuspage/internal/nagios"
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+const (
+	checkGroup = "Checks"
+	// defaultCheckConcurrency is the number of plugins run at once when
+	// no limit is configured.
+	defaultCheckConcurrency = 4
+)
+
+// checkRunner runs Nagios compatible plugins on their own intervals and
+// keeps the latest result of each. The checks source reports them.
+type checkRunner struct {
+	checks []nagios.Check
+	limit  chan struct{}
+
+	mu      sync.Mutex
+	results map[string]checkResult
+	// pending holds the metrics of runs not reported yet
+	pending []types.Metric
+}
+
+type checkResult struct {
+	nagios.Result
+	ranAt   time.Time
+	changed time.Time
+}
+
+func newCheckRunner(checks []nagios.Check, concurrency int) *checkRunner {
+	if concurrency <= 0 {
+		concurrency = defaultCheckConcurrency
+	}
+	return &checkRunner{
+		checks:  checks,
+		limit:   make(chan struct{}, concurrency),
+		results: make(map[string]checkResult, len(checks)),
+	}
+}
+
+// run schedules every check until ctx is done.
+func (r *checkRunner) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, check := range r.checks {
+		wg.Add(1)
+		go func(check nagios.Check) {
+			defer wg.Done()
+			ticker := time.NewTicker(check.Interval)
+			defer ticker.Stop()
+			for {
+				r.runCheck(ctx, check)
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(check)
+	}
+	wg.Wait()
+}
+
+func (r *checkRunner) runCheck(ctx context.Context, check nagios.Check) {
+	// The timeout starts once a slot is free
+	select {
+	case r.limit <- struct{}{}:
+	case <-ctx.Done():
+		return
+	}
+	runCtx, cancel := context.WithTimeout(ctx, check.Timeout)
+	result := nagios.Run(runCtx, check.Command)
+	cancel()
+	<-r.limit
+	if ctx.Err() != nil {
+		// Shutting down, the run was cut short
+		return
+	}
+
+	labels := map[string]string{"check": check.Name}
+	metrics := []types.Metric{
+		{Name: "check_state", Value: float64(result.State), Labels: labels},
+		{Name: "check_duration", Value: float64(result.Duration.Microseconds()) / 1000, Unit: types.UnitMilliseconds, Labels: labels},
+	}
+	for _, perf := range result.Perfdata {
+		metrics = append(metrics, perfdataMetrics(check.Name, perf)...)
+	}
+
+	now := time.Now()
+	r.mu.Lock()
+	previous, seen := r.results[check.Name]
+	changed := now
+	if seen && previous.State == result.State {
+		changed = previous.changed
+	}
+	r.results[check.Name] = checkResult{Result: result, ranAt: now, changed: changed}
+	r.pending = append(r.pending, metrics...)
+	r.mu.Unlock()
+}
+
+// perfdataMetrics converts a performance value and its plain thresholds into
+// metrics labeled with the check and the perfdata label.
+func perfdataMetrics(check string, perf nagios.Perfdata) []types.Metric {
+	unit, scale := perfdataUnit(perf.UOM)
+	labels := map[string]string{"check": check, "label": perf.Label}
+	metrics := []types.Metric{{Name: "check_value", Value: perf.Value * scale, Unit: unit, Labels: labels}}
+	if perf.Warning != nil {
+		metrics = append(metrics, types.Metric{Name: "check_warning", Value: *perf.Warning * scale, Unit: unit, Labels: labels})
+	}
+	if perf.Critical != nil {
+		metrics = append(metrics, types.Metric{Name: "check_critical", Value: *perf.Critical * scale, Unit: unit, Labels: labels})
+	}
+	return metrics
+}
+
+// perfdataUnit maps a perfdata unit of measurement onto a metric unit and
+// the factor to convert the value.
+func perfdataUnit(uom string) (types.Unit, float64) {
+	switch strings.ToUpper(uom) {
+	case "%":
+		return types.UnitPercent, 1
+	case "S":
+		return types.UnitSeconds, 1
+	case "MS":
+		return types.UnitMilliseconds, 1
+	case "US":
+		return types.UnitMilliseconds, 0.001
+	case "B":
+		return types.UnitBytes, 1
+	case "KB":
+		return types.UnitBytes, 1 << 10
+	case "MB":
+		return types.UnitBytes, 1 << 20
+	case "GB":
+		return types.UnitBytes, 1 << 30
+	case "TB":
+		return types.UnitBytes, 1 << 40
+	}
+	return types.UnitNone, 1
+}
+
+// collect returns the latest state of every check and the metrics of the
+// runs since the previous call.
+func (r *checkRunner) collect() ([]types.ServiceStatus, []types.Metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	services := make([]types.ServiceStatus, 0, len(r.checks))
+	for _, check := range r.checks {
+		result, ok := r.results[check.Name]
+		if !ok {
+			services = append(services, types.ServiceStatus{
+				Name:    check.Name,
+				Group:   checkGroup,
+				Status:  "pending",
+				Health:  types.HealthUnknown,
+				Details: "Not run yet",
+			})
+			continue
+		}
+		services = append(services, checkServiceStatus(check, result))
+	}
+
+	metrics := r.pending
+	r.pending = nil
+	return services, metrics
+}
+
+func checkServiceStatus(check nagios.Check, result checkResult) types.ServiceStatus {
+	status := types.ServiceStatus{
+		Name:       check.Name,
+		Group:      checkGroup,
+		Status:     result.State.String(),
+		Details:    result.Output,
+		LastChange: formatDuration(time.Since(result.changed)),
+	}
+	switch result.State {
+	case nagios.OK:
+		status.Health = types.HealthOperational
+		status.Uptime = status.LastChange
+	case nagios.Warning:
+		status.Health = types.HealthDegraded
+	case nagios.Critical:
+		status.Health = types.HealthMajorOutage
+	default:
+		status.Health = types.HealthUnknown
+	}
+	return status
+}
+
+// collectChecks reports the check results. The plugins run on their own
+// schedule, so this never blocks on a plugin.
+func (c *Collector) collectChecks(ctx context.Context) (*Result, error) {
+	services, metrics := c.checks.collect()
+	return &Result{Services: services, Metrics: metrics}, nil
+}
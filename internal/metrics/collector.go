@@ -4,294 +4,318 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"os/exec"
 	"sync"
 	"time"
 
-	dockercontainer "github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/client"
-	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/disk"
-	"github.com/shirou/gopsutil/v3/host"
-	"github.com/shirou/gopsutil/v3/mem"
 	"github.com/shirou/gopsutil/v3/net"
-	
+	"github.com/shirou/gopsutil/v3/process"
+
+	"github.com/hra42/iot-hub-statuspage/internal/diskhealth"
 	"github.com/hra42/iot-hub-statuspage/internal/haproxy"
+	"github.com/hra42/iot-hub-statuspage/internal/homeassistant"
+	"github.com/hra42/iot-hub-statuspage/internal/mqtt"
+	"github.com/hra42/iot-hub-statuspage/internal/nagios"
+	"github.com/hra42/iot-hub-statuspage/internal/pubsub"
 	"github.com/hra42/iot-hub-statuspage/internal/storage"
+	"github.com/hra42/iot-hub-statuspage/internal/systemd"
 	"github.com/hra42/iot-hub-statuspage/internal/types"
 )
 
 type Collector struct {
-	db           *storage.DB
-	haproxy      *haproxy.Client
-	dockerClient *client.Client
-	mu           sync.RWMutex
-	current      types.SystemMetrics
-	dockerStatus []types.ServiceStatus
-	lastNetworkIn  float64
-	lastNetworkOut float64
-	lastCollectTime time.Time
+	db                    *storage.DB
+	haproxy               *haproxy.Client
+	docker                []*dockerWatcher
+	systemd               *systemd.Client
+	mqtt                  *mqtt.Monitor
+	publisher             *mqtt.Publisher
+	homeAssistant         *homeassistant.Client
+	homeAssistantEntities []string
+	homeAssistantFailing  map[string]bool
+	pi                    *PiConfig
+	mounts                []string
+	diskHealth            *diskhealth.Reader
+	diskWearCache         map[string]cachedWear
+	systemdUnits          []string
+	probes                []ProbeHost
+	checks                *checkRunner
+	certs                 *CertConfig
+	snapshots             *pubsub.Hub[types.Snapshot]
+	publishMu             sync.Mutex
+	mu                    sync.RWMutex
+	current               types.SystemMetrics
+	lastInterfaces        map[string]net.IOCountersStat
+	lastInterfacesTime    time.Time
+	interfaces            []string
+	wan                   *WANConfig
+	processes             map[int32]*process.Process
+	lastDiskIO            map[string]disk.IOCountersStat
+	lastDiskIOTime        time.Time
+	registry              Registry
+	intervals             map[string]time.Duration
+	readings              map[string]*types.SourceReading
+	sourceStatus          map[string]*types.SourceStatus
+	pendingMetrics        []storage.SystemMetric
+	pendingStatuses       []storage.ServiceStatus
 }
 
-func NewCollector(db *storage.DB, haproxy *haproxy.Client) *Collector {
-	dockerClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		log.Printf("Warning: Failed to create Docker client: %v. Docker monitoring disabled.", err)
-		dockerClient = nil
-	}
+// Config holds the optional settings of the collector.
+type Config struct {
+	// DockerHosts lists the Docker endpoints to monitor. Without any, the
+	// daemon configured by the DOCKER_* environment variables is used.
+	DockerHosts []DockerHost
+	// DockerFilter selects the containers that are monitored
+	DockerFilter ContainerFilter
+	// SystemdUnits lists the units read over D-Bus
+	SystemdUnits []string
+	// MQTT enables broker and device monitoring when set
+	MQTT *mqtt.Config
+	// MQTTPublish publishes the collected state on the MQTT connection
+	MQTTPublish *mqtt.PublisherConfig
+	// HomeAssistant enables monitoring of Home Assistant when set
+	HomeAssistant *homeassistant.Config
+	// Pi enables the Raspberry Pi telemetry when set
+	Pi *PiConfig
+	// Mounts lists the mountpoints whose usage and I/O are collected
+	Mounts []string
+	// DiskHealth reads wear indicators of the disks behind Mounts
+	DiskHealth *diskhealth.Reader
+	// Interfaces are globs of the network interfaces to collect. Without
+	// any, all but loopback and virtual interfaces are collected.
+	Interfaces []string
+	// WAN enables the internet connectivity check when set
+	WAN *WANConfig
+	// Probes lists the hosts that are pinged
+	Probes []ProbeHost
+	// Checks lists the Nagios compatible plugins to run
+	Checks []nagios.Check
+	// CheckConcurrency limits how many plugins run at once
+	CheckConcurrency int
+	// Certificates enables the TLS certificate checks when set
+	Certificates *CertConfig
+	// Intervals overrides the collection interval of sources by name
+	Intervals map[string]time.Duration
+}
 
-	return &Collector{
-		db:           db,
-		haproxy:      haproxy,
-		dockerClient: dockerClient,
+func NewCollector(db *storage.DB, haproxy *haproxy.Client, cfg Config) *Collector {
+	c := &Collector{
+		db:            db,
+		haproxy:       haproxy,
+		snapshots:     pubsub.New[types.Snapshot](),
+		mounts:        cfg.Mounts,
+		diskHealth:    cfg.DiskHealth,
+		diskWearCache: make(map[string]cachedWear),
+		interfaces:    cfg.Interfaces,
+		wan:           cfg.WAN,
+		probes:        cfg.Probes,
+		certs:         cfg.Certificates,
+		intervals:     cfg.Intervals,
+		readings:      make(map[string]*types.SourceReading),
+		sourceStatus:  make(map[string]*types.SourceStatus),
 	}
-}
 
-func (c *Collector) Start(ctx context.Context) {
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-
-	// Collect initial metrics
-	c.collect()
-
-	for {
-		select {
-		case <-ticker.C:
-			c.collect()
-		case <-ctx.Done():
-			return
+	if cfg.MQTT != nil {
+		c.mqtt = mqtt.NewMonitor(*cfg.MQTT, c.publish)
+		if cfg.MQTTPublish != nil {
+			c.publisher = mqtt.NewPublisher(c.mqtt, *cfg.MQTTPublish)
 		}
 	}
-}
 
-func (c *Collector) collect() {
-	// Collect system metrics
-	metrics := types.SystemMetrics{}
-
-	// Prepare slices for bulk insert
-	var systemMetrics []storage.SystemMetric
-	var serviceStatuses []storage.ServiceStatus
-
-	// CPU usage
-	cpuPercent, err := cpu.Percent(time.Second, false)
-	if err == nil && len(cpuPercent) > 0 {
-		metrics.CPUPercent = cpuPercent[0]
-		systemMetrics = append(systemMetrics, storage.SystemMetric{
-			MetricType: "cpu",
-			Value:      metrics.CPUPercent,
-		})
+	// Units are keyed by their full name, also when they cannot be read
+	for _, unit := range cfg.SystemdUnits {
+		c.systemdUnits = append(c.systemdUnits, systemd.UnitName(unit))
 	}
 
-	// Memory usage
-	vmStat, err := mem.VirtualMemory()
-	if err == nil {
-		metrics.MemoryPercent = vmStat.UsedPercent
-		metrics.MemoryUsed = vmStat.Used
-		metrics.MemoryTotal = vmStat.Total
-		systemMetrics = append(systemMetrics, storage.SystemMetric{
-			MetricType: "memory",
-			Value:      metrics.MemoryPercent,
-		})
-		systemMetrics = append(systemMetrics, storage.SystemMetric{
-			MetricType: "memory_used",
-			Value:      float64(vmStat.Used),
-		})
-		systemMetrics = append(systemMetrics, storage.SystemMetric{
-			MetricType: "memory_total",
-			Value:      float64(vmStat.Total),
-		})
-	} else {
-		log.Printf("Error getting memory stats: %v", err)
+	if cfg.HomeAssistant != nil {
+		c.homeAssistant = homeassistant.NewClient(cfg.HomeAssistant.URL, cfg.HomeAssistant.Token)
+		c.homeAssistantEntities = cfg.HomeAssistant.Entities
 	}
 
-	// Disk usage
-	diskStat, err := disk.Usage("/")
-	if err == nil {
-		metrics.DiskPercent = diskStat.UsedPercent
-		metrics.DiskUsed = diskStat.Used
-		metrics.DiskTotal = diskStat.Total
-		systemMetrics = append(systemMetrics, storage.SystemMetric{
-			MetricType: "disk",
-			Value:      metrics.DiskPercent,
-		})
-		systemMetrics = append(systemMetrics, storage.SystemMetric{
-			MetricType: "disk_used",
-			Value:      float64(diskStat.Used),
-		})
-		systemMetrics = append(systemMetrics, storage.SystemMetric{
-			MetricType: "disk_total",
-			Value:      float64(diskStat.Total),
-		})
+	if cfg.Pi != nil && cfg.Pi.Reader.Available() {
+		c.pi = cfg.Pi
 	}
 
-	// Network stats - calculate rate (bytes per second)
-	netStats, err := net.IOCounters(false)
-	if err == nil && len(netStats) > 0 {
-		currentNetworkIn := float64(netStats[0].BytesRecv)
-		currentNetworkOut := float64(netStats[0].BytesSent)
-		
-		// Calculate rates if we have previous values
-		if c.lastCollectTime.IsZero() {
-			// First collection, just store the values
-			metrics.NetworkIn = 0
-			metrics.NetworkOut = 0
-		} else {
-			timeDiff := time.Since(c.lastCollectTime).Seconds()
-			if timeDiff > 0 {
-				metrics.NetworkIn = (currentNetworkIn - c.lastNetworkIn) / timeDiff
-				metrics.NetworkOut = (currentNetworkOut - c.lastNetworkOut) / timeDiff
-				
-				// Ensure non-negative values (in case of counter reset)
-				if metrics.NetworkIn < 0 {
-					metrics.NetworkIn = 0
-				}
-				if metrics.NetworkOut < 0 {
-					metrics.NetworkOut = 0
-				}
-			}
-		}
-		
-		// Store current values for next calculation
-		c.lastNetworkIn = currentNetworkIn
-		c.lastNetworkOut = currentNetworkOut
-		
-		systemMetrics = append(systemMetrics, storage.SystemMetric{
-			MetricType: "network_in_rate",
-			Value:      metrics.NetworkIn,
-		})
-		systemMetrics = append(systemMetrics, storage.SystemMetric{
-			MetricType: "network_out_rate",
-			Value:      metrics.NetworkOut,
-		})
+	if len(cfg.Checks) > 0 {
+		c.checks = newCheckRunner(cfg.Checks, cfg.CheckConcurrency)
 	}
 
-	// System uptime
-	uptimeInfo, err := host.Uptime()
-	if err == nil {
-		metrics.Uptime = time.Duration(uptimeInfo) * time.Second
+	hosts := cfg.DockerHosts
+	if len(hosts) == 0 {
+		hosts = []DockerHost{{}}
 	}
-
-	// Database size and connection check
-	dbSize, err := c.db.GetDatabaseSize()
-	if err == nil {
-		metrics.DatabaseSize = dbSize
-		metrics.DatabaseConnected = true
-		systemMetrics = append(systemMetrics, storage.SystemMetric{
-			MetricType: "database_size",
-			Value:      float64(dbSize),
-		})
-	} else {
-		log.Printf("Error getting database size: %v", err)
-		metrics.DatabaseConnected = false
-		// Try a simple ping to check if it's just a size query issue
-		if pingErr := c.db.Ping(); pingErr == nil {
-			metrics.DatabaseConnected = true
+	for _, host := range hosts {
+		dockerClient, err := newDockerClient(host)
+		if err != nil {
+			log.Printf("Warning: Failed to create Docker client for host %q: %v. Docker monitoring of this host disabled.", host.Name, err)
+			continue
 		}
+		c.docker = append(c.docker, newDockerWatcher(host.Name, dockerClient, cfg.DockerFilter, c.handleDockerChange))
 	}
 
-	// Collect HAProxy stats
-	if stats, err := c.haproxy.GetStats(); err == nil {
-		metrics.HAProxyConnected = true
-		for _, backend := range stats.Backends {
-			status := "UP"
-			if !backend.Active {
-				status = "DOWN"
-			}
-			serviceStatuses = append(serviceStatuses, storage.ServiceStatus{
-				Service: fmt.Sprintf("haproxy_%s", backend.Name),
-				Status:  status,
-				Details: "",
-			})
-		}
-	} else {
-		metrics.HAProxyConnected = false
-		log.Printf("Error getting HAProxy stats: %v", err)
-	}
+	c.registerBuiltins()
 
-	// Collect Docker container stats
-	if c.dockerClient != nil {
-		c.collectDockerStats(&serviceStatuses)
-		metrics.DockerConnected = true
-	} else {
-		metrics.DockerConnected = false
-	}
+	return c
+}
+
+// Register adds a source to the collector. It must be called before Start,
+// which applies the configured interval overrides to it. The interval must
+// be positive.
+func (c *Collector) Register(source Source, interval, timeout time.Duration) error {
+	return c.registry.Register(source, interval, timeout)
+}
 
-	// Check host connectivity
-	metrics.Pi5Connected = pingHost("192.168.2.136")
-	metrics.Pi52Connected = pingHost("192.168.2.135")
+func (c *Collector) trackSource(entry registration) {
+	name := entry.source.Name()
+	c.sourceStatus[name] = &types.SourceStatus{
+		Name:       name,
+		IntervalMs: float64(entry.interval.Milliseconds()),
+		TimeoutMs:  float64(entry.timeout.Milliseconds()),
+	}
+}
 
-	// Update current metrics and last collect time
+func (c *Collector) Start(ctx context.Context) {
 	c.mu.Lock()
-	c.current = metrics
-	c.lastCollectTime = time.Now()
+	applyIntervals(c.registry.entries, c.intervals)
+	for _, entry := range c.registry.entries {
+		c.trackSource(entry)
+	}
 	c.mu.Unlock()
 
-	// Perform bulk insert in a single transaction
-	if err := c.db.BulkInsert(systemMetrics, serviceStatuses); err != nil {
-		log.Printf("Failed to perform bulk insert: %v", err)
+	for _, watcher := range c.docker {
+		go watcher.run(ctx)
+	}
+	if c.checks != nil {
+		go c.checks.run(ctx)
+	}
+	if c.mqtt != nil {
+		c.mqtt.Start()
+		defer c.mqtt.Stop()
+	}
+
+	var sources sync.WaitGroup
+	for _, entry := range c.registry.entries {
+		sources.Add(1)
+		go func(entry registration) {
+			defer sources.Done()
+			c.runSource(ctx, entry)
+		}(entry)
 	}
+
+	c.runFlush(ctx, &sources)
 }
 
-func (c *Collector) collectDockerStats(serviceStatuses *[]storage.ServiceStatus) {
-	containers, err := c.dockerClient.ContainerList(context.Background(), dockercontainer.ListOptions{All: true})
-	if err != nil {
-		log.Printf("Failed to list containers: %v", err)
-		// Mark Docker as disconnected if we can't list containers
-		c.mu.Lock()
-		c.current.DockerConnected = false
-		c.mu.Unlock()
-		return
-	}
-
-	dockerStatus := make([]types.ServiceStatus, 0)
-	
-	for _, container := range containers {
-		name := container.Names[0]
-		if len(name) > 0 && name[0] == '/' {
-			name = name[1:] // Remove leading slash
+// registerBuiltins registers the enabled built-in sources with their default
+// interval and timeout. Their order is the order on the dashboard.
+func (c *Collector) registerBuiltins() {
+	builtin := func(name string, interval, timeout time.Duration, collect func(context.Context) (*Result, error)) {
+		if err := c.registry.Register(sourceFunc{name: name, collect: collect}, interval, timeout); err != nil {
+			log.Printf("Warning: %v. Source disabled.", err)
 		}
+	}
 
-		status := types.ServiceStatus{
-			Name:    name,
-			Status:  container.State,
-			Healthy: container.State == "running",
-		}
+	builtin("system", 5*time.Second, 4*time.Second, c.collectHost)
+	builtin("processes", 10*time.Second, 5*time.Second, c.collectProcessSource)
+	builtin("network", 5*time.Second, 4*time.Second, c.collectNetworkSource)
+	builtin("database", 30*time.Second, 5*time.Second, c.collectDatabase)
+	builtin("haproxy", 5*time.Second, 5*time.Second, c.collectHAProxy)
+	if len(c.probes) > 0 {
+		builtin("probes", 10*time.Second, 5*time.Second, c.collectProbes)
+	}
+	if len(c.mounts) > 0 {
+		builtin("mounts", 10*time.Second, 8*time.Second, c.collectMountSource)
+	}
+	if c.pi != nil {
+		builtin("pi", 10*time.Second, 5*time.Second, c.collectPiSource)
+	}
+	if len(c.docker) > 0 {
+		builtin("docker", 10*time.Second, 8*time.Second, c.collectDocker)
+	}
+	if len(c.systemdUnits) > 0 {
+		builtin("systemd", 10*time.Second, 5*time.Second, c.collectSystemd)
+	}
+	if c.homeAssistant != nil {
+		builtin("homeassistant", 30*time.Second, 10*time.Second, c.collectHomeAssistant)
+	}
+	if c.checks != nil {
+		builtin("checks", 5*time.Second, time.Second, c.collectChecks)
+	}
+	if c.certs != nil {
+		builtin("certs", time.Hour, 30*time.Second, c.collectCertificates)
+	}
+	if c.mqtt != nil {
+		builtin("mqtt", 5*time.Second, time.Second, c.collectMQTT)
+		builtin("device", 5*time.Second, time.Second, c.collectDevices)
+	}
+	if c.wan != nil {
+		builtin("wan", c.wan.Interval, time.Duration(wanPingCount+10)*time.Second, c.collectWAN)
+	}
+}
 
-		// Get more detailed status
-		inspect, err := c.dockerClient.ContainerInspect(context.Background(), container.ID)
-		if err == nil {
-			if inspect.State.Health != nil {
-				status.Healthy = inspect.State.Health.Status == "healthy"
-				// Only set details if there's an actual issue
-				if inspect.State.Health.Status != "healthy" {
-					status.Details = inspect.State.Health.Status
-				}
-			}
-			
-			if inspect.State.StartedAt != "" {
-				startTime, _ := time.Parse(time.RFC3339, inspect.State.StartedAt)
-				uptime := time.Since(startTime)
-				status.Uptime = formatDuration(uptime)
-			}
+func (c *Collector) collectProcessSource(ctx context.Context) (*Result, error) {
+	var metrics types.SystemMetrics
+	result := &Result{}
+	err := c.collectProcesses(ctx, &metrics, &result.Metrics)
+	result.apply = func(current *types.SystemMetrics) {
+		if metrics.Processes != nil {
+			current.Processes = metrics.Processes
 		}
+	}
+	return result, err
+}
 
-		dockerStatus = append(dockerStatus, status)
-		
-		// Add to bulk insert
-		healthStatus := "UP"
-		if !status.Healthy {
-			healthStatus = "DOWN"
-		}
-		*serviceStatuses = append(*serviceStatuses, storage.ServiceStatus{
-			Service: fmt.Sprintf("docker_%s", name),
-			Status:  healthStatus,
-			Details: status.Details,
-		})
+func (c *Collector) collectNetworkSource(ctx context.Context) (*Result, error) {
+	var metrics types.SystemMetrics
+	result := &Result{}
+	err := c.collectInterfaces(ctx, &metrics, &result.Metrics)
+	result.apply = func(current *types.SystemMetrics) {
+		current.Interfaces = metrics.Interfaces
 	}
+	return result, err
+}
 
-	c.mu.Lock()
-	c.dockerStatus = dockerStatus
-	c.mu.Unlock()
+func (c *Collector) collectMountSource(ctx context.Context) (*Result, error) {
+	var metrics types.SystemMetrics
+	result := &Result{}
+	err := c.collectMounts(ctx, &metrics, &result.Metrics)
+	result.apply = func(current *types.SystemMetrics) {
+		current.Mounts = metrics.Mounts
+	}
+	return result, err
+}
+
+func (c *Collector) collectPiSource(ctx context.Context) (*Result, error) {
+	var metrics types.SystemMetrics
+	result := &Result{}
+	err := c.collectPi(ctx, &metrics, &result.Metrics)
+	if metrics.Pi != nil {
+		result.Services = c.piServices(*metrics.Pi)
+	}
+	result.apply = func(current *types.SystemMetrics) {
+		current.Pi = metrics.Pi
+	}
+	return result, err
+}
+
+// collectDatabase reads the database size, which doubles as connection
+// check.
+func (c *Collector) collectDatabase(ctx context.Context) (*Result, error) {
+	dbSize, err := c.db.GetDatabaseSize(ctx)
+	connected := err == nil
+	result := &Result{}
+	if err == nil {
+		result.Metrics = append(result.Metrics, types.Metric{
+			Name:  "database_size",
+			Value: float64(dbSize),
+			Unit:  types.UnitBytes,
+			Title: "Database Size",
+			Icon:  "fas fa-database text-indigo-400",
+		})
+	} else if pingErr := c.db.PingContext(ctx); pingErr == nil {
+		// Only the size query failed
+		connected = true
+	}
+	result.Connections = []types.HostConnection{{Name: "PostgreSQL", Connected: connected, Icon: "fas fa-database"}}
+	return result, err
 }
 
 func (c *Collector) GetCurrentMetrics() types.SystemMetrics {
@@ -300,10 +324,25 @@ func (c *Collector) GetCurrentMetrics() types.SystemMetrics {
 	return c.current
 }
 
-func (c *Collector) GetDockerStatus() []types.ServiceStatus {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return c.dockerStatus
+// handleDockerChange records a container transition with the next flush,
+// so short restarts between two collections still show up in the history.
+// It runs on the event stream of the host, so it never waits for the
+// database.
+func (c *Collector) handleDockerChange(status types.ServiceStatus, removed bool) {
+	c.mu.Lock()
+	if !removed {
+		c.pendingStatuses = append(c.pendingStatuses, storage.ServiceStatus{
+			Service: types.ServiceKey("docker", status),
+			Status:  string(status.Health),
+			Details: status.Details,
+		})
+	}
+	// Show the change before the next collection of the source
+	if reading, ok := c.readings["docker"]; ok {
+		reading.Services = withServiceIDs("docker", c.dockerStatuses())
+	}
+	c.mu.Unlock()
+	c.publish()
 }
 
 func formatDuration(d time.Duration) string {
@@ -319,13 +358,18 @@ func formatDuration(d time.Duration) string {
 	return fmt.Sprintf("%dm", minutes)
 }
 
-func pingHost(host string) bool {
-	// Use ping command with timeout
-	cmd := exec.Command("ping", "-c", "1", "-W", "2", host)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		log.Printf("Ping to %s failed: %v, output: %s", host, err, string(output))
-		return false
+// formatBytes formats a byte count with a binary unit, e.g. "1.5 GB".
+func formatBytes(bytes float64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%.0f B", bytes)
+	}
+
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
 	}
-	return true
-}
\ No newline at end of file
+
+	return fmt.Sprintf("%.1f %cB", bytes/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+
+	"github.com/hra42/iot-hub-statuspage/internal/diskhealth"
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+// diskWearInterval limits how often wear indicators are read, as running
+// smartctl is comparatively expensive and wear changes slowly.
+const diskWearInterval = 10 * time.Minute
+
+type cachedWear struct {
+	wear   *types.DiskWear
+	readAt time.Time
+}
+
+// collectMounts reads usage, I/O and wear of the configured mountpoints and
+// appends them for the bulk insert.
+func (c *Collector) collectMounts(ctx context.Context, metrics *types.SystemMetrics, systemMetrics *[]types.Metric) error {
+	if len(c.mounts) == 0 {
+		return nil
+	}
+	var errs []error
+
+	// Later entries shadow earlier mounts on the same mountpoint
+	partitions := make(map[string]disk.PartitionStat)
+	if stats, err := disk.PartitionsWithContext(ctx, true); err == nil {
+		for _, partition := range stats {
+			partitions[partition.Mountpoint] = partition
+		}
+	} else {
+		errs = append(errs, fmt.Errorf("failed to list mounts: %w", err))
+	}
+
+	counters, err := disk.IOCountersWithContext(ctx)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to get disk I/O counters: %w", err))
+	}
+	now := time.Now()
+	elapsed := now.Sub(c.lastDiskIOTime).Seconds()
+
+	for _, mountpoint := range c.mounts {
+		status := types.MountStatus{Mountpoint: mountpoint}
+		usage, err := disk.UsageWithContext(ctx, mountpoint)
+		if err != nil {
+			status.Error = err.Error()
+			metrics.Mounts = append(metrics.Mounts, status)
+			continue
+		}
+		status.Total = usage.Total
+		status.Used = usage.Used
+		status.UsedPercent = usage.UsedPercent
+		status.InodesPercent = usage.InodesUsedPercent
+		status.Fstype = usage.Fstype
+
+		if partition, ok := partitions[mountpoint]; ok {
+			status.Device = partition.Device
+			status.Fstype = partition.Fstype
+			status.ReadOnly = slices.Contains(partition.Opts, "ro")
+		}
+
+		device := filepath.Base(status.Device)
+		current, ok := counters[device]
+		previous, seen := c.lastDiskIO[device]
+		if ok && seen && !c.lastDiskIOTime.IsZero() && elapsed > 0 {
+			status.ReadRate = counterRate(previous.ReadBytes, current.ReadBytes, elapsed)
+			status.WriteRate = counterRate(previous.WriteBytes, current.WriteBytes, elapsed)
+			status.ReadLatencyMs = latency(previous.ReadTime, current.ReadTime, previous.ReadCount, current.ReadCount)
+			status.WriteLatencyMs = latency(previous.WriteTime, current.WriteTime, previous.WriteCount, current.WriteCount)
+		}
+
+		if c.diskHealth != nil && status.Device != "" {
+			status.Wear = c.diskWear(ctx, diskhealth.ParentDevice(device))
+		}
+
+		metrics.Mounts = append(metrics.Mounts, status)
+		*systemMetrics = append(*systemMetrics, mountMetrics(status)...)
+	}
+
+	c.lastDiskIO = counters
+	c.lastDiskIOTime = now
+	return errors.Join(errs...)
+}
+
+func (c *Collector) diskWear(ctx context.Context, device string) *types.DiskWear {
+	if cached, ok := c.diskWearCache[device]; ok && time.Since(cached.readAt) < diskWearInterval {
+		return cached.wear
+	}
+	wear := c.diskHealth.Wear(ctx, device)
+	c.diskWearCache[device] = cachedWear{wear: wear, readAt: time.Now()}
+	return wear
+}
+
+func mountMetrics(status types.MountStatus) []types.Metric {
+	labels := map[string]string{"mount": status.Mountpoint}
+	metrics := []types.Metric{
+		{Name: "mount_usage", Value: status.UsedPercent, Labels: labels},
+		{Name: "mount_used", Value: float64(status.Used), Labels: labels},
+		{Name: "mount_inode_usage", Value: status.InodesPercent, Labels: labels},
+		{Name: "mount_read_only", Value: boolValue(status.ReadOnly), Labels: labels},
+		{Name: "disk_read_rate", Value: status.ReadRate, Labels: labels},
+		{Name: "disk_write_rate", Value: status.WriteRate, Labels: labels},
+		{Name: "disk_read_latency", Value: status.ReadLatencyMs, Labels: labels},
+		{Name: "disk_write_latency", Value: status.WriteLatencyMs, Labels: labels},
+	}
+	if status.Wear != nil && status.Wear.LifeUsedPercent != nil {
+		metrics = append(metrics, types.Metric{Name: "disk_life_used", Value: *status.Wear.LifeUsedPercent, Labels: labels})
+	}
+	return metrics
+}
+
+// counterRate returns the per second rate of a monotonic counter, or 0
+// when it was reset in between.
+func counterRate(previous, current uint64, seconds float64) float64 {
+	if current < previous {
+		return 0
+	}
+	return float64(current-previous) / seconds
+}
+
+// latency returns the average time in milliseconds spent per operation.
+func latency(previousTime, currentTime, previousOps, currentOps uint64) float64 {
+	if currentOps <= previousOps || currentTime < previousTime {
+		return 0
+	}
+	return float64(currentTime-previousTime) / float64(currentOps-previousOps)
+}
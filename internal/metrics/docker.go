@@ -0,0 +1,706 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dockercontainer "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/client"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+const (
+	// dockerReconcileInterval is how often the event-fed cache is checked
+	// against a full container listing, in case an event was missed.
+	dockerReconcileInterval = time.Minute
+	// dockerRetryDelay is the wait before resubscribing after the event
+	// stream broke.
+	dockerRetryDelay = 5 * time.Second
+	// statsConcurrency is the number of containers sampled at once.
+	statsConcurrency = 4
+)
+
+// containerState is the cached view of a single container.
+type containerState struct {
+	ID           string
+	Name         string
+	Image        string
+	State        string
+	HealthStatus string
+	StartedAt    time.Time
+	RestartCount int
+	ExitCode     int
+	OOMKilled    bool
+	// RestartPolicy is the restart policy name, e.g. "no" or "always"
+	RestartPolicy string
+	Labels        map[string]string
+}
+
+// containerUsage is the latest resource sample of a running container,
+// along with the raw counters needed to compute rates on the next sample.
+type containerUsage struct {
+	CPUPercent  float64
+	MemoryUsed  uint64
+	MemoryLimit uint64
+	NetworkIn   float64
+	NetworkOut  float64
+
+	read      time.Time
+	cpuTotal  uint64
+	systemCPU uint64
+	rxBytes   uint64
+	txBytes   uint64
+}
+
+func (s containerState) serviceStatus(host string, usage containerUsage) types.ServiceStatus {
+	status := types.ServiceStatus{
+		Name:        s.Name,
+		Host:        host,
+		DisplayName: s.Labels[labelName],
+		Description: s.Labels[labelDescription],
+		Group:       s.Labels[labelGroup],
+		Status:      s.State,
+		Health:      dockerHealth(s.State, s.HealthStatus),
+		Container: &types.ContainerInfo{
+			Image:        s.Image,
+			RestartCount: s.RestartCount,
+			ExitCode:     s.ExitCode,
+			OOMKilled:    s.OOMKilled,
+		},
+	}
+	if status.Group == "" {
+		status.Group = s.Labels[labelCompose]
+	}
+	// Only set details if there's an actual issue
+	switch {
+	case s.State == "exited" || s.State == "dead":
+		status.Details = fmt.Sprintf("Exited with code %d", s.ExitCode)
+		if s.OOMKilled {
+			status.Details += " (OOM killed)"
+		}
+	case s.HealthStatus != "" && s.HealthStatus != "healthy":
+		status.Details = s.HealthStatus
+	}
+	if s.State == "running" {
+		if !s.StartedAt.IsZero() {
+			status.Uptime = formatDuration(time.Since(s.StartedAt))
+		}
+		status.Container.CPUPercent = usage.CPUPercent
+		status.Container.MemoryUsed = usage.MemoryUsed
+		status.Container.MemoryLimit = usage.MemoryLimit
+		status.Container.NetworkIn = usage.NetworkIn
+		status.Container.NetworkOut = usage.NetworkOut
+	}
+	return status
+}
+
+// dockerWatcher keeps a live cache of container state. It is fed by the
+// Docker events API and reconciled against ContainerList on a slow interval.
+type dockerWatcher struct {
+	host       string
+	client     *client.Client
+	filter     ContainerFilter
+	mu         sync.RWMutex
+	containers map[string]containerState
+	usage      map[string]containerUsage
+	connected  bool
+	// onChange is called whenever a container's status or health changes,
+	// and with removed set when a container is gone.
+	onChange func(status types.ServiceStatus, removed bool)
+}
+
+func newDockerWatcher(host string, dockerClient *client.Client, filter ContainerFilter, onChange func(types.ServiceStatus, bool)) *dockerWatcher {
+	return &dockerWatcher{
+		host:       host,
+		client:     dockerClient,
+		filter:     filter,
+		containers: make(map[string]containerState),
+		usage:      make(map[string]containerUsage),
+		onChange:   onChange,
+	}
+}
+
+// run subscribes to container events until ctx is cancelled, resubscribing
+// and reconciling whenever the stream breaks.
+func (w *dockerWatcher) run(ctx context.Context) {
+	for {
+		// Subscribe before reconciling so no event between the two is lost
+		subCtx, cancel := context.WithCancel(ctx)
+		messages, errs := w.client.Events(subCtx, events.ListOptions{
+			Filters: filters.NewArgs(
+				filters.Arg("type", string(events.ContainerEventType)),
+				filters.Arg("event", string(events.ActionCreate)),
+				filters.Arg("event", string(events.ActionStart)),
+				filters.Arg("event", string(events.ActionRestart)),
+				filters.Arg("event", string(events.ActionDie)),
+				filters.Arg("event", string(events.ActionStop)),
+				filters.Arg("event", string(events.ActionOOM)),
+				filters.Arg("event", string(events.ActionPause)),
+				filters.Arg("event", string(events.ActionUnPause)),
+				filters.Arg("event", string(events.ActionRename)),
+				filters.Arg("event", string(events.ActionDestroy)),
+				filters.Arg("event", string(events.ActionHealthStatus)),
+			),
+		})
+
+		err := w.reconcile(ctx)
+		if err == nil {
+			err = w.consume(ctx, messages, errs)
+		}
+		cancel()
+
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Docker event stream of host %q interrupted: %v", w.host, err)
+		w.setConnected(false)
+
+		select {
+		case <-time.After(dockerRetryDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// consume handles events and periodic reconciliation until the stream fails.
+func (w *dockerWatcher) consume(ctx context.Context, messages <-chan events.Message, errs <-chan error) error {
+	ticker := time.NewTicker(dockerReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg := <-messages:
+			w.handleEvent(ctx, msg)
+		case err := <-errs:
+			return err
+		case <-ticker.C:
+			if err := w.reconcile(ctx); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// handleEvent applies a container event to the cache. The transition is
+// taken from the event itself, since by the time the container is inspected
+// it may have moved on, e.g. restarted right after dying. Inspecting only
+// adds what events lack.
+func (w *dockerWatcher) handleEvent(ctx context.Context, msg events.Message) {
+	id := msg.Actor.ID
+	if msg.Action == events.ActionDestroy {
+		w.remove(id)
+		return
+	}
+
+	w.mu.RLock()
+	state, known := w.containers[id]
+	w.mu.RUnlock()
+	if !known {
+		state = containerState{ID: id, Image: msg.Actor.Attributes["image"]}
+	}
+	state = state.apply(msg)
+
+	inspected, err := w.inspect(ctx, id)
+	switch {
+	case err == nil:
+		state = state.enrich(inspected)
+	case !known:
+		// Without its labels the filter cannot tell whether to show it
+		log.Printf("Failed to inspect container %s after %s event: %v", msg.Actor.Attributes["name"], msg.Action, err)
+		return
+	}
+	if msg.Action == events.ActionOOM {
+		log.Printf("Container %s was killed by the OOM killer", state.Name)
+	}
+	w.update(state)
+}
+
+// apply returns the state after a container event.
+func (s containerState) apply(msg events.Message) containerState {
+	attributes := msg.Actor.Attributes
+	if name := attributes["name"]; name != "" {
+		s.Name = name
+	}
+
+	switch msg.Action {
+	case events.ActionCreate:
+		s.State = "created"
+	case events.ActionStart, events.ActionRestart:
+		s.State = "running"
+		if msg.TimeNano != 0 {
+			s.StartedAt = time.Unix(0, msg.TimeNano)
+		}
+		s.HealthStatus = ""
+		s.ExitCode = 0
+		s.OOMKilled = false
+	case events.ActionUnPause:
+		s.State = "running"
+	case events.ActionPause:
+		s.State = "paused"
+	case events.ActionDie:
+		s.State = "exited"
+		s.ExitCode, _ = strconv.Atoi(attributes["exitCode"])
+	case events.ActionStop:
+		// Follows the die event, which carries the exit code
+		s.State = "exited"
+	case events.ActionOOM:
+		s.OOMKilled = true
+	default:
+		if status, ok := strings.CutPrefix(string(msg.Action), string(events.ActionHealthStatus)+":"); ok {
+			s.HealthStatus = strings.TrimSpace(status)
+		}
+	}
+	return s
+}
+
+// enrich adds what events lack from an inspection of the container. Values
+// of the current run are only taken while the container is still in the
+// state of the event; otherwise a later event reports where it went.
+func (s containerState) enrich(inspected containerState) containerState {
+	s.Name = inspected.Name
+	s.Image = inspected.Image
+	s.Labels = inspected.Labels
+	s.RestartCount = inspected.RestartCount
+	s.RestartPolicy = inspected.RestartPolicy
+	if inspected.State != s.State {
+		return s
+	}
+	s.StartedAt = inspected.StartedAt
+	s.OOMKilled = s.OOMKilled || inspected.OOMKilled
+	if s.ExitCode == 0 {
+		s.ExitCode = inspected.ExitCode
+	}
+	if s.HealthStatus == "" {
+		s.HealthStatus = inspected.HealthStatus
+	}
+	return s
+}
+
+// reconcile replaces the cache with a full listing of all containers.
+func (w *dockerWatcher) reconcile(ctx context.Context) error {
+	containers, err := w.client.ContainerList(ctx, dockercontainer.ListOptions{All: true})
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %w", err)
+	}
+	w.setConnected(true)
+
+	seen := make(map[string]bool, len(containers))
+	for _, container := range containers {
+		seen[container.ID] = true
+
+		state, err := w.inspect(ctx, container.ID)
+		if err != nil {
+			// Fall back to what the listing told us
+			state = containerState{
+				ID:     container.ID,
+				Name:   container.ID,
+				State:  string(container.State),
+				Labels: container.Labels,
+			}
+			if len(container.Names) > 0 {
+				state.Name = strings.TrimPrefix(container.Names[0], "/")
+			}
+		}
+		w.update(state)
+	}
+
+	w.mu.RLock()
+	var gone []string
+	for id := range w.containers {
+		if !seen[id] {
+			gone = append(gone, id)
+		}
+	}
+	w.mu.RUnlock()
+	for _, id := range gone {
+		w.remove(id)
+	}
+
+	return nil
+}
+
+func (w *dockerWatcher) inspect(ctx context.Context, id string) (containerState, error) {
+	inspect, err := w.client.ContainerInspect(ctx, id)
+	if err != nil {
+		return containerState{}, err
+	}
+
+	state := containerState{
+		ID:           inspect.ID,
+		Name:         strings.TrimPrefix(inspect.Name, "/"),
+		RestartCount: inspect.RestartCount,
+	}
+	if inspect.Config != nil {
+		state.Image = inspect.Config.Image
+		state.Labels = inspect.Config.Labels
+	}
+	if inspect.HostConfig != nil {
+		state.RestartPolicy = string(inspect.HostConfig.RestartPolicy.Name)
+	}
+	if inspect.State != nil {
+		state.State = string(inspect.State.Status)
+		state.ExitCode = inspect.State.ExitCode
+		state.OOMKilled = inspect.State.OOMKilled
+		if inspect.State.Health != nil {
+			state.HealthStatus = string(inspect.State.Health.Status)
+		}
+		if inspect.State.StartedAt != "" {
+			state.StartedAt, _ = time.Parse(time.RFC3339Nano, inspect.State.StartedAt)
+		}
+	}
+	return state, nil
+}
+
+// update stores state and reports a change if the visible status moved.
+// Containers hidden by the filter are cached but never reported, since
+// state rules may include them again later.
+func (w *dockerWatcher) update(state containerState) {
+	w.mu.Lock()
+	previous, existed := w.containers[state.ID]
+	w.containers[state.ID] = state
+	w.mu.Unlock()
+
+	changed := !existed ||
+		previous.State != state.State ||
+		previous.HealthStatus != state.HealthStatus ||
+		!previous.StartedAt.Equal(state.StartedAt)
+	if changed && w.onChange != nil && w.filter.allows(state) {
+		w.onChange(state.serviceStatus(w.host, containerUsage{}), false)
+	}
+}
+
+// remove drops a container from the cache and reports it as gone if it was
+// shown.
+func (w *dockerWatcher) remove(id string) {
+	w.mu.Lock()
+	state, existed := w.containers[id]
+	delete(w.containers, id)
+	delete(w.usage, id)
+	w.mu.Unlock()
+
+	if existed && w.onChange != nil && w.filter.allows(state) {
+		w.onChange(state.serviceStatus(w.host, containerUsage{}), true)
+	}
+}
+
+func (w *dockerWatcher) setConnected(connected bool) {
+	w.mu.Lock()
+	w.connected = connected
+	w.mu.Unlock()
+}
+
+func (w *dockerWatcher) isConnected() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.connected
+}
+
+// statuses returns the cached containers allowed by the filter, sorted by
+// group and name.
+func (w *dockerWatcher) statuses() []types.ServiceStatus {
+	w.mu.RLock()
+	statuses := make([]types.ServiceStatus, 0, len(w.containers))
+	for id, state := range w.containers {
+		if w.filter.allows(state) {
+			statuses = append(statuses, state.serviceStatus(w.host, w.usage[id]))
+		}
+	}
+	w.mu.RUnlock()
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Group != statuses[j].Group {
+			return statuses[i].Group < statuses[j].Group
+		}
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// collectDocker samples container resource usage and reports the
+// containers of every connected host. Container state itself comes from the
+// event-fed caches.
+func (c *Collector) collectDocker(ctx context.Context) (*Result, error) {
+	result := &Result{}
+	var disconnected []string
+	for _, watcher := range c.docker {
+		connected := watcher.isConnected()
+		name := "Docker"
+		if watcher.host != "" {
+			name = fmt.Sprintf("Docker (%s)", watcher.host)
+		}
+		result.Connections = append(result.Connections, types.HostConnection{Name: name, Connected: connected, Icon: "fab fa-docker"})
+		if !connected {
+			disconnected = append(disconnected, watcher.host)
+			continue
+		}
+		result.Metrics = append(result.Metrics, watcher.collectStats(ctx)...)
+		result.Metrics = append(result.Metrics, watcher.stateMetrics()...)
+		result.Services = append(result.Services, watcher.statuses()...)
+	}
+
+	if len(disconnected) > 0 {
+		return result, fmt.Errorf("not connected to docker host(s) %q", disconnected)
+	}
+	return result, nil
+}
+
+// dockerStatuses returns the containers of all connected hosts.
+func (c *Collector) dockerStatuses() []types.ServiceStatus {
+	var statuses []types.ServiceStatus
+	for _, watcher := range c.docker {
+		if watcher.isConnected() {
+			statuses = append(statuses, watcher.statuses()...)
+		}
+	}
+	return statuses
+}
+
+// InspectContainer returns the details of a container on a host, including
+// the recent output of its health check, newest first.
+func (c *Collector) InspectContainer(ctx context.Context, host, name string) (*types.ContainerDetails, error) {
+	for _, watcher := range c.docker {
+		if watcher.host == host {
+			return watcher.details(ctx, name)
+		}
+	}
+	return nil, fmt.Errorf("unknown docker host %q", host)
+}
+
+func (w *dockerWatcher) details(ctx context.Context, name string) (*types.ContainerDetails, error) {
+	inspect, err := w.client.ContainerInspect(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container %s: %w", name, err)
+	}
+
+	details := &types.ContainerDetails{
+		ID:           inspect.ID,
+		ImageID:      inspect.Image,
+		Command:      strings.TrimSpace(inspect.Path + " " + strings.Join(inspect.Args, " ")),
+		RestartCount: inspect.RestartCount,
+	}
+	details.Created, _ = time.Parse(time.RFC3339Nano, inspect.Created)
+	if inspect.Config != nil {
+		details.Image = inspect.Config.Image
+	}
+	if inspect.HostConfig != nil {
+		details.RestartPolicy = string(inspect.HostConfig.RestartPolicy.Name)
+	}
+	if inspect.State != nil {
+		details.StartedAt, _ = time.Parse(time.RFC3339Nano, inspect.State.StartedAt)
+		details.FinishedAt, _ = time.Parse(time.RFC3339Nano, inspect.State.FinishedAt)
+		if health := inspect.State.Health; health != nil {
+			details.HealthStatus = string(health.Status)
+			details.FailingStreak = health.FailingStreak
+			// Docker keeps the log oldest first
+			for i := len(health.Log) - 1; i >= 0; i-- {
+				if result := health.Log[i]; result != nil {
+					details.HealthLog = append(details.HealthLog, types.HealthCheck{
+						Start:    result.Start,
+						End:      result.End,
+						ExitCode: result.ExitCode,
+						Output:   strings.TrimSpace(result.Output),
+					})
+				}
+			}
+		}
+	}
+	return details, nil
+}
+
+// collectStats samples resource usage of every running container using the
+// one-shot stats API and returns the values as labeled metrics. CPU and
+// network rates are computed against the previous sample, so the first
+// sample of a container only reports memory.
+func (w *dockerWatcher) collectStats(ctx context.Context) []types.Metric {
+	w.mu.RLock()
+	running := make([]containerState, 0, len(w.containers))
+	for _, state := range w.containers {
+		if state.State == "running" && w.filter.allows(state) {
+			running = append(running, state)
+		}
+	}
+	w.mu.RUnlock()
+
+	// One-shot stats take about a second per container, so sample a few in
+	// parallel
+	var (
+		metrics   []types.Metric
+		metricsMu sync.Mutex
+		wg        sync.WaitGroup
+	)
+	limit := make(chan struct{}, statsConcurrency)
+	for _, state := range running {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(state containerState) {
+			defer func() {
+				<-limit
+				wg.Done()
+			}()
+			sample := w.sampleContainer(ctx, state)
+			metricsMu.Lock()
+			metrics = append(metrics, sample...)
+			metricsMu.Unlock()
+		}(state)
+	}
+	wg.Wait()
+	return metrics
+}
+
+// stateMetrics returns the last exit code and whether the container was
+// OOM killed for every shown container, running or not, labeled with its
+// image so a crash can be traced to the image that caused it.
+func (w *dockerWatcher) stateMetrics() []types.Metric {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	var metrics []types.Metric
+	for _, state := range w.containers {
+		if !w.filter.allows(state) {
+			continue
+		}
+		labels := map[string]string{"container": types.QualifiedName(w.host, state.Name), "image": state.Image}
+		if w.host != "" {
+			labels["host"] = w.host
+		}
+		oomKilled := 0.0
+		if state.OOMKilled {
+			oomKilled = 1
+		}
+		metrics = append(metrics,
+			types.Metric{Name: "container_exit_code", Value: float64(state.ExitCode), Labels: labels},
+			types.Metric{Name: "container_oom_killed", Value: oomKilled, Labels: labels},
+		)
+	}
+	return metrics
+}
+
+// sampleContainer samples one container and returns its metrics, or nil
+// when the stats could not be read.
+func (w *dockerWatcher) sampleContainer(ctx context.Context, state containerState) []types.Metric {
+	w.mu.RLock()
+	previous := w.usage[state.ID]
+	w.mu.RUnlock()
+
+	usage, err := w.sampleStats(ctx, state.ID, previous)
+	if err != nil {
+		log.Printf("Failed to get stats for container %s: %v", state.Name, err)
+		return nil
+	}
+
+	w.mu.Lock()
+	if _, ok := w.containers[state.ID]; ok {
+		w.usage[state.ID] = usage
+	}
+	w.mu.Unlock()
+
+	labels := map[string]string{"container": types.QualifiedName(w.host, state.Name)}
+	if w.host != "" {
+		labels["host"] = w.host
+	}
+	return []types.Metric{
+		{Name: "container_cpu", Value: usage.CPUPercent, Labels: labels},
+		{Name: "container_memory_used", Value: float64(usage.MemoryUsed), Labels: labels},
+		{Name: "container_memory_limit", Value: float64(usage.MemoryLimit), Labels: labels},
+		{Name: "container_network_in_rate", Value: usage.NetworkIn, Labels: labels},
+		{Name: "container_network_out_rate", Value: usage.NetworkOut, Labels: labels},
+		{Name: "container_restarts", Value: float64(state.RestartCount), Labels: labels},
+	}
+}
+
+func (w *dockerWatcher) sampleStats(ctx context.Context, id string, previous containerUsage) (containerUsage, error) {
+	resp, err := w.client.ContainerStatsOneShot(ctx, id)
+	if err != nil {
+		return containerUsage{}, err
+	}
+	defer resp.Body.Close()
+
+	var stats dockercontainer.StatsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return containerUsage{}, fmt.Errorf("failed to decode stats: %w", err)
+	}
+
+	usage := containerUsage{
+		MemoryLimit: stats.MemoryStats.Limit,
+		read:        stats.Read,
+		cpuTotal:    stats.CPUStats.CPUUsage.TotalUsage,
+		systemCPU:   stats.CPUStats.SystemUsage,
+	}
+
+	// Match `docker stats`, which does not count the page cache
+	usage.MemoryUsed = stats.MemoryStats.Usage
+	if inactive, ok := stats.MemoryStats.Stats["inactive_file"]; ok && inactive < usage.MemoryUsed {
+		usage.MemoryUsed -= inactive
+	} else if inactive, ok := stats.MemoryStats.Stats["total_inactive_file"]; ok && inactive < usage.MemoryUsed {
+		usage.MemoryUsed -= inactive
+	}
+
+	for _, network := range stats.Networks {
+		usage.rxBytes += network.RxBytes
+		usage.txBytes += network.TxBytes
+	}
+
+	if previous.read.IsZero() {
+		return usage, nil
+	}
+
+	cpuDelta := float64(usage.cpuTotal) - float64(previous.cpuTotal)
+	systemDelta := float64(usage.systemCPU) - float64(previous.systemCPU)
+	if cpuDelta > 0 && systemDelta > 0 {
+		cpus := float64(stats.CPUStats.OnlineCPUs)
+		if cpus == 0 {
+			cpus = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+		}
+		usage.CPUPercent = cpuDelta / systemDelta * cpus * 100
+	}
+
+	// Counters restart from zero when the container restarts
+	if elapsed := usage.read.Sub(previous.read).Seconds(); elapsed > 0 {
+		if usage.rxBytes >= previous.rxBytes {
+			usage.NetworkIn = float64(usage.rxBytes-previous.rxBytes) / elapsed
+		}
+		if usage.txBytes >= previous.txBytes {
+			usage.NetworkOut = float64(usage.txBytes-previous.txBytes) / elapsed
+		}
+	}
+
+	return usage, nil
+}
+
+// dockerHealth maps a container state and its health check status onto the
+// shared health model. healthStatus is empty for containers without a
+// HEALTHCHECK.
+func dockerHealth(state, healthStatus string) types.Health {
+	switch state {
+	case "running":
+		switch healthStatus {
+		case "", "healthy":
+			return types.HealthOperational
+		case "starting":
+			return types.HealthDegraded
+		case "unhealthy":
+			return types.HealthMajorOutage
+		}
+		return types.HealthUnknown
+	case "restarting":
+		return types.HealthDegraded
+	case "paused":
+		return types.HealthMaintenance
+	case "exited", "dead", "stopped":
+		return types.HealthMajorOutage
+	}
+	return types.HealthUnknown
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Labels containers can set to control how they appear on the status page.
+const (
+	labelEnable      = "statuspage.enable"
+	labelName        = "statuspage.name"
+	labelDescription = "statuspage.description"
+	labelGroup       = "statuspage.group"
+	labelCompose     = "com.docker.compose.project"
+	labelOneOff      = "com.docker.compose.oneoff"
+)
+
+// ContainerRule matches containers by name glob, label or state.
+type ContainerRule struct {
+	Kind  string // "name", "label" or "state"
+	Key   string // glob for name, label key, or state
+	Value string // expected label value, empty matches any value
+}
+
+// ContainerFilter decides which containers are shown. A container is shown
+// if it matches any include rule (or there are none) and no exclude rule.
+// Containers labeled statuspage.enable=false are always hidden. One-off
+// containers that exited are hidden unless an include rule or the label
+// statuspage.enable=true asks for them.
+type ContainerFilter struct {
+	Include []ContainerRule
+	Exclude []ContainerRule
+}
+
+// ParseContainerRules parses a comma separated list of rules such as
+// "name:zigbee*,label:statuspage.enable=true,state:exited". A rule without
+// a prefix is treated as a name glob.
+func ParseContainerRules(spec string) ([]ContainerRule, error) {
+	var rules []ContainerRule
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		kind, value, found := strings.Cut(part, ":")
+		if !found {
+			kind, value = "name", part
+		}
+
+		rule := ContainerRule{Kind: kind, Key: value}
+		switch kind {
+		case "name":
+			if _, err := path.Match(value, ""); err != nil {
+				return nil, fmt.Errorf("invalid name pattern %q: %w", value, err)
+			}
+		case "label":
+			rule.Key, rule.Value, _ = strings.Cut(value, "=")
+		case "state":
+		default:
+			return nil, fmt.Errorf("unknown container rule %q", part)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (r ContainerRule) matches(state containerState) bool {
+	switch r.Kind {
+	case "name":
+		matched, _ := path.Match(r.Key, state.Name)
+		return matched
+	case "label":
+		value, ok := state.Labels[r.Key]
+		return ok && (r.Value == "" || value == r.Value)
+	case "state":
+		return state.State == r.Key
+	}
+	return false
+}
+
+// oneOff reports whether the container is a one-off run that exited, e.g.
+// of `docker run` or `docker compose run`, rather than a service that went
+// down. Services of Compose projects are not restarted by default either,
+// so only their run containers count.
+func (s containerState) oneOff() bool {
+	if s.State != "exited" || (s.RestartPolicy != "" && s.RestartPolicy != "no") {
+		return false
+	}
+	_, compose := s.Labels[labelCompose]
+	return !compose || strings.EqualFold(s.Labels[labelOneOff], "true")
+}
+
+func (f ContainerFilter) allows(state containerState) bool {
+	if state.Labels[labelEnable] == "false" {
+		return false
+	}
+
+	included := len(f.Include) == 0
+	explicit := state.Labels[labelEnable] == "true"
+	for _, rule := range f.Include {
+		if rule.matches(state) {
+			included, explicit = true, true
+			break
+		}
+	}
+	if !included || (!explicit && state.oneOff()) {
+		return false
+	}
+
+	for _, rule := range f.Exclude {
+		if rule.matches(state) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseContainerRules(t *testing.T) {
+	rules, err := ParseContainerRules(" zigbee*, name:mqtt-?,label:statuspage.enable=true,label:com.docker.compose.project,state:exited,")
+	if err != nil {
+		t.Fatalf("ParseContainerRules: %v", err)
+	}
+	want := []ContainerRule{
+		{Kind: "name", Key: "zigbee*"},
+		{Kind: "name", Key: "mqtt-?"},
+		{Kind: "label", Key: "statuspage.enable", Value: "true"},
+		{Kind: "label", Key: "com.docker.compose.project"},
+		{Kind: "state", Key: "exited"},
+	}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("rules = %+v, want %+v", rules, want)
+	}
+
+	if rules, err := ParseContainerRules(""); err != nil || rules != nil {
+		t.Errorf("ParseContainerRules(\"\") = %v, %v, want no rules", rules, err)
+	}
+	for _, spec := range []string{"name:[zigbee", "image:nginx"} {
+		if _, err := ParseContainerRules(spec); err == nil {
+			t.Errorf("ParseContainerRules(%q) returned no error", spec)
+		}
+	}
+}
+
+func TestContainerFilterAllows(t *testing.T) {
+	rules := func(spec string) []ContainerRule {
+		parsed, err := ParseContainerRules(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	running := containerState{Name: "zigbee2mqtt", State: "running", RestartPolicy: "unless-stopped"}
+	labeled := func(state containerState, labels map[string]string) containerState {
+		state.Labels = labels
+		return state
+	}
+	oneOff := containerState{Name: "busybox-run", State: "exited", RestartPolicy: "no"}
+	compose := map[string]string{labelCompose: "iot"}
+
+	tests := []struct {
+		name    string
+		filter  ContainerFilter
+		state   containerState
+		allowed bool
+	}{
+		{"no rules", ContainerFilter{}, running, true},
+		{"included by name", ContainerFilter{Include: rules("zigbee*")}, running, true},
+		{"not included", ContainerFilter{Include: rules("mqtt*")}, running, false},
+		{"excluded", ContainerFilter{Exclude: rules("state:running")}, running, false},
+		{"exclude wins over include", ContainerFilter{Include: rules("zigbee*"), Exclude: rules("zigbee2*")}, running, false},
+		{"disabled by label", ContainerFilter{Include: rules("zigbee*")}, labeled(running, map[string]string{labelEnable: "false"}), false},
+		{"enabled by label", ContainerFilter{Include: rules("label:statuspage.enable=true")}, labeled(running, map[string]string{labelEnable: "true"}), true},
+		{"label without the value", ContainerFilter{Include: rules("label:statuspage.enable=true")}, labeled(running, map[string]string{labelEnable: "yes"}), false},
+		{"enabled but excluded", ContainerFilter{Exclude: rules("zigbee*")}, labeled(running, map[string]string{labelEnable: "true"}), false},
+
+		// Exited one-off runs are hidden by default
+		{"exited one-off", ContainerFilter{}, oneOff, false},
+		{"exited one-off without inspection", ContainerFilter{}, containerState{Name: "run", State: "exited"}, false},
+		{"running one-off", ContainerFilter{}, containerState{Name: "run", State: "running", RestartPolicy: "no"}, true},
+		{"exited with a restart policy", ContainerFilter{}, containerState{Name: "db", State: "exited", RestartPolicy: "always"}, true},
+		{"exited Compose service", ContainerFilter{}, labeled(oneOff, compose), true},
+		{"exited Compose run", ContainerFilter{}, labeled(oneOff, map[string]string{labelCompose: "iot", labelOneOff: "True"}), false},
+		{"exited one-off enabled by label", ContainerFilter{}, labeled(oneOff, map[string]string{labelEnable: "true"}), true},
+		{"exited one-off included by rule", ContainerFilter{Include: rules("busybox*")}, oneOff, true},
+		{"exited one-off included by state", ContainerFilter{Include: rules("state:exited")}, oneOff, true},
+	}
+	for _, tt := range tests {
+		if allowed := tt.filter.allows(tt.state); allowed != tt.allowed {
+			t.Errorf("%s: allows = %v, want %v", tt.name, allowed, tt.allowed)
+		}
+	}
+}
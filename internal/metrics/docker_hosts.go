@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+// DockerHost is a named Docker (or Podman) API endpoint.
+type DockerHost struct {
+	// Name namespaces the host's containers. It is empty for the single
+	// default host configured from the environment.
+	Name string
+	// Endpoint is a unix://, tcp:// or ssh:// URL
+	Endpoint string
+	// CertPath is a directory with ca.pem, cert.pem and key.pem for tcp://
+	// endpoints protected by TLS
+	CertPath string
+}
+
+// ParseDockerHosts parses a comma separated list of name=endpoint pairs,
+// e.g. "pi5=unix:///var/run/docker.sock,pi52=tcp://192.168.2.135:2376?cert_path=/certs/pi52".
+func ParseDockerHosts(spec string) ([]DockerHost, error) {
+	var hosts []DockerHost
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, endpoint, found := strings.Cut(part, "=")
+		if !found || name == "" || endpoint == "" {
+			return nil, fmt.Errorf("invalid docker host %q, expected name=endpoint", part)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate docker host %q", name)
+		}
+		seen[name] = true
+
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid endpoint of docker host %q: %w", name, err)
+		}
+		switch u.Scheme {
+		case "unix", "tcp", "ssh":
+		default:
+			return nil, fmt.Errorf("unsupported scheme %q of docker host %q", u.Scheme, name)
+		}
+
+		host := DockerHost{Name: name, CertPath: u.Query().Get("cert_path")}
+		u.RawQuery = ""
+		host.Endpoint = u.String()
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// newDockerClient creates an API client for host. Without an endpoint the
+// standard DOCKER_* environment variables are used.
+func newDockerClient(host DockerHost) (*client.Client, error) {
+	opts := []client.Opt{client.WithAPIVersionNegotiation()}
+
+	switch {
+	case host.Endpoint == "":
+		opts = append(opts, client.FromEnv)
+	case strings.HasPrefix(host.Endpoint, "ssh://"):
+		u, err := url.Parse(host.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		// The host name is ignored, all requests go through the dialer
+		opts = append(opts, client.WithHost("http://docker.example.com"), client.WithDialContext(sshDialer(u)))
+	default:
+		opts = append(opts, client.WithHost(host.Endpoint))
+		if host.CertPath != "" {
+			opts = append(opts, client.WithTLSClientConfig(
+				filepath.Join(host.CertPath, "ca.pem"),
+				filepath.Join(host.CertPath, "cert.pem"),
+				filepath.Join(host.CertPath, "key.pem"),
+			))
+		}
+	}
+
+	return client.NewClientWithOpts(opts...)
+}
+
+// sshDialer connects to the remote daemon through `docker system dial-stdio`
+// over ssh, the same way the docker CLI does for ssh:// hosts.
+func sshDialer(u *url.URL) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		args := []string{"-o", "BatchMode=yes"}
+		if u.Port() != "" {
+			args = append(args, "-p", u.Port())
+		}
+		target := u.Hostname()
+		if u.User != nil {
+			target = u.User.Username() + "@" + target
+		}
+		args = append(args, "--", target, "docker", "system", "dial-stdio")
+
+		// Not bound to ctx: the connection outlives the dialing request
+		cmd := exec.Command("ssh", args...)
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, fmt.Errorf("failed to start ssh: %w", err)
+		}
+		return &commandConn{cmd: cmd, stdin: stdin, stdout: stdout, remote: u.Host}, nil
+	}
+}
+
+// commandConn is a net.Conn backed by the stdin and stdout of a process.
+type commandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	remote string
+}
+
+func (c *commandConn) Read(p []byte) (int, error)  { return c.stdout.Read(p) }
+func (c *commandConn) Write(p []byte) (int, error) { return c.stdin.Write(p) }
+
+func (c *commandConn) Close() error {
+	c.stdin.Close()
+	c.stdout.Close()
+	if c.cmd.Process != nil {
+		c.cmd.Process.Kill()
+	}
+	c.cmd.Wait()
+	return nil
+}
+
+func (c *commandConn) LocalAddr() net.Addr  { return commandAddr("local") }
+func (c *commandConn) RemoteAddr() net.Addr { return commandAddr(c.remote) }
+
+// Deadlines are not supported on pipes; requests are bounded by contexts.
+func (c *commandConn) SetDeadline(time.Time) error      { return nil }
+func (c *commandConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *commandConn) SetWriteDeadline(time.Time) error { return nil }
+
+type commandAddr string
+
+func (a commandAddr) Network() string { return "ssh" }
+func (a commandAddr) String() string  { return string(a) }
@@ -0,0 +1,182 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/client"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+// fakeDocker serves container inspections of the Docker API. Containers
+// not in inspections are unknown.
+func fakeDocker(t *testing.T, inspections map[string]string) *client.Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for id, inspection := range inspections {
+			if strings.HasSuffix(r.URL.Path, "/containers/"+id+"/json") {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(inspection))
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message": "No such container"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	dockerClient, err := client.NewClientWithOpts(client.WithHost("tcp://"+server.Listener.Addr().String()), client.WithVersion("1.43"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dockerClient
+}
+
+// runningMosquitto is the inspection of a container that already restarted.
+const runningMosquitto = `{
+	"Id": "c0ffee",
+	"Name": "/mosquitto",
+	"RestartCount": 3,
+	"State": {"Status": "running", "StartedAt": "2025-07-01T10:00:05Z"},
+	"Config": {"Image": "eclipse-mosquitto:2", "Labels": {"com.docker.compose.project": "iot"}}
+}`
+
+func containerEvent(id string, action events.Action, attributes map[string]string) events.Message {
+	return events.Message{
+		Type:     events.ContainerEventType,
+		Action:   action,
+		Actor:    events.Actor{ID: id, Attributes: attributes},
+		TimeNano: time.Now().UnixNano(),
+	}
+}
+
+func TestDockerEventsRecordQuickRestarts(t *testing.T) {
+	var changes []types.ServiceStatus
+	w := newDockerWatcher("", fakeDocker(t, map[string]string{"c0ffee": runningMosquitto}), ContainerFilter{}, func(status types.ServiceStatus, removed bool) {
+		changes = append(changes, status)
+	})
+	w.update(containerState{ID: "c0ffee", Name: "mosquitto", State: "running", StartedAt: time.Now().Add(-time.Hour)})
+	changes = nil
+
+	// The container died and restarted before it was inspected
+	ctx := context.Background()
+	w.handleEvent(ctx, containerEvent("c0ffee", events.ActionDie, map[string]string{"name": "mosquitto", "exitCode": "137"}))
+	w.handleEvent(ctx, containerEvent("c0ffee", events.ActionStart, map[string]string{"name": "mosquitto"}))
+
+	if len(changes) != 2 {
+		t.Fatalf("got %d changes, want the death and the start: %+v", len(changes), changes)
+	}
+	died := changes[0]
+	if died.Status != "exited" || died.Health != types.HealthMajorOutage || died.Details != "Exited with code 137" {
+		t.Errorf("death = %s %s %q, want exited with code 137", died.Status, died.Health, died.Details)
+	}
+	// Inspecting still adds what the event lacks
+	if died.Group != "iot" || died.Container.Image != "eclipse-mosquitto:2" || died.Container.RestartCount != 3 {
+		t.Errorf("death was not enriched by the inspection: %+v %+v", died, died.Container)
+	}
+	if started := changes[1]; started.Status != "running" || started.Health != types.HealthOperational {
+		t.Errorf("start = %s %s, want running", started.Status, started.Health)
+	}
+
+	// An unknown container that cannot be inspected is not guessed at
+	w.handleEvent(ctx, containerEvent("gone", events.ActionStart, map[string]string{"name": "oneoff"}))
+	if len(w.statuses()) != 1 {
+		t.Errorf("statuses = %+v, want only mosquitto", w.statuses())
+	}
+}
+
+func TestContainerStateApply(t *testing.T) {
+	started := time.Date(2025, 7, 1, 10, 0, 0, 0, time.UTC)
+	running := containerState{Name: "zigbee2mqtt", State: "running", StartedAt: started.Add(-time.Hour), HealthStatus: "healthy"}
+	event := func(action events.Action, attributes map[string]string) events.Message {
+		return events.Message{Action: action, Actor: events.Actor{Attributes: attributes}, TimeNano: started.UnixNano()}
+	}
+
+	tests := []struct {
+		name  string
+		state containerState
+		event events.Message
+		check func(containerState) bool
+	}{
+		{"die", running, event(events.ActionDie, map[string]string{"exitCode": "1"}), func(s containerState) bool {
+			return s.State == "exited" && s.ExitCode == 1 && s.HealthStatus == "healthy"
+		}},
+		{"oom", running, event(events.ActionOOM, nil), func(s containerState) bool {
+			return s.State == "running" && s.OOMKilled
+		}},
+		{"start", containerState{State: "exited", ExitCode: 1, OOMKilled: true}, event(events.ActionStart, nil), func(s containerState) bool {
+			return s.State == "running" && s.StartedAt.Equal(started) && s.ExitCode == 0 && !s.OOMKilled
+		}},
+		{"health", running, event(events.ActionHealthStatusUnhealthy, nil), func(s containerState) bool {
+			return s.HealthStatus == "unhealthy"
+		}},
+		{"pause", running, event(events.ActionPause, nil), func(s containerState) bool {
+			return s.State == "paused"
+		}},
+		{"unpause keeps the start", containerState{State: "paused", StartedAt: started.Add(-time.Hour)}, event(events.ActionUnPause, nil), func(s containerState) bool {
+			return s.State == "running" && s.StartedAt.Equal(started.Add(-time.Hour))
+		}},
+		{"rename", running, event(events.ActionRename, map[string]string{"name": "z2m"}), func(s containerState) bool {
+			return s.Name == "z2m" && s.State == "running"
+		}},
+	}
+	for _, tt := range tests {
+		if got := tt.state.apply(tt.event); !tt.check(got) {
+			t.Errorf("%s: state = %+v", tt.name, got)
+		}
+	}
+}
+
+func TestDockerStateMetrics(t *testing.T) {
+	w := newDockerWatcher("nas", nil, ContainerFilter{}, nil)
+	w.update(containerState{ID: "1", Name: "mosquitto", Image: "eclipse-mosquitto:2", State: "exited", ExitCode: 137, OOMKilled: true, RestartPolicy: "on-failure"})
+	w.update(containerState{ID: "2", Name: "hidden", Image: "busybox", State: "exited", ExitCode: 1, Labels: map[string]string{labelEnable: "false"}})
+
+	metrics := w.stateMetrics()
+	want := map[string]float64{"container_exit_code": 137, "container_oom_killed": 1}
+	if len(metrics) != len(want) {
+		t.Fatalf("got %d metrics, want %d: %+v", len(metrics), len(want), metrics)
+	}
+	for _, metric := range metrics {
+		if metric.Value != want[metric.Name] {
+			t.Errorf("%s = %v, want %v", metric.Name, metric.Value, want[metric.Name])
+		}
+		labels := map[string]string{"container": "nas/mosquitto", "host": "nas", "image": "eclipse-mosquitto:2"}
+		if !reflect.DeepEqual(metric.Labels, labels) {
+			t.Errorf("%s labels = %v, want %v", metric.Name, metric.Labels, labels)
+		}
+	}
+}
+
+func TestDockerHealth(t *testing.T) {
+	tests := []struct {
+		state, healthStatus string
+		want                types.Health
+	}{
+		{"running", "", types.HealthOperational},
+		{"running", "healthy", types.HealthOperational},
+		{"running", "starting", types.HealthDegraded},
+		{"running", "unhealthy", types.HealthMajorOutage},
+		{"running", "none", types.HealthUnknown},
+		{"restarting", "", types.HealthDegraded},
+		{"paused", "healthy", types.HealthMaintenance},
+		{"exited", "", types.HealthMajorOutage},
+		{"dead", "", types.HealthMajorOutage},
+		{"stopped", "", types.HealthMajorOutage},
+		{"created", "", types.HealthUnknown},
+		{"removing", "", types.HealthUnknown},
+	}
+	for _, tt := range tests {
+		if got := dockerHealth(tt.state, tt.healthStatus); got != tt.want {
+			t.Errorf("dockerHealth(%q, %q) = %q, want %q", tt.state, tt.healthStatus, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+// collectHAProxy reads the backend states from the HAProxy stats socket,
+// along with the session counts and check durations of the backends.
+func (c *Collector) collectHAProxy(ctx context.Context) (*Result, error) {
+	stats, err := c.haproxy.GetStats(ctx)
+	result := &Result{
+		Connections: []types.HostConnection{{Name: "HAProxy", Connected: err == nil, Icon: "fas fa-network-wired"}},
+	}
+	if err != nil {
+		return result, err
+	}
+
+	for _, backend := range stats.Backends {
+		status := types.ServiceStatus{
+			Name:   backend.Name,
+			Status: backend.Status,
+			Health: backend.Health,
+		}
+
+		// Calculate uptime/downtime
+		if backend.LastChange > 0 {
+			status.LastChange = formatDuration(time.Duration(backend.LastChange) * time.Second)
+		}
+
+		switch backend.Health {
+		case types.HealthOperational:
+			status.Uptime = status.LastChange
+		case types.HealthPartialOutage:
+			status.Uptime = status.LastChange
+			status.Details = fmt.Sprintf("%d/%d servers up", backend.ServersUp, backend.ServersTotal)
+		case types.HealthMajorOutage:
+			status.Details = fmt.Sprintf("Down for %s", status.LastChange)
+		default:
+			status.Details = fmt.Sprintf("%s for %s", backend.Status, status.LastChange)
+		}
+
+		result.Services = append(result.Services, status)
+
+		labels := map[string]string{"backend": backend.Name}
+		result.Metrics = append(result.Metrics,
+			types.Metric{Name: "haproxy_sessions", Value: float64(backend.SessionCur), Labels: labels},
+			types.Metric{Name: "haproxy_session_rate", Value: float64(backend.ConnRate), Unit: types.UnitPerSecond, Labels: labels},
+		)
+		for _, server := range backend.Servers {
+			// Servers without health checks have no duration
+			if server.CheckStatus == "" {
+				continue
+			}
+			result.Metrics = append(result.Metrics, types.Metric{
+				Name:   "haproxy_check_duration",
+				Value:  float64(server.CheckDuration),
+				Unit:   types.UnitMilliseconds,
+				Labels: map[string]string{"backend": backend.Name, "server": server.Name},
+			})
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hra42/iot-hub-statuspage/internal/homeassistant"
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+const homeAssistantGroup = "Home Assistant"
+
+// collectHomeAssistant reads the state of Home Assistant, its integrations
+// and the configured entities.
+func (c *Collector) collectHomeAssistant(ctx context.Context) (*Result, error) {
+	result := &Result{}
+	services, err := c.readHomeAssistant(ctx, &result.Metrics)
+	result.Services = services
+	return result, err
+}
+
+// readHomeAssistant returns the core and entity statuses. Errors are
+// returned alongside the statuses read so far.
+func (c *Collector) readHomeAssistant(ctx context.Context, systemMetrics *[]types.Metric) ([]types.ServiceStatus, error) {
+	core := types.ServiceStatus{
+		Name:        "core",
+		DisplayName: "Home Assistant",
+		Group:       homeAssistantGroup,
+	}
+
+	config, err := c.homeAssistant.GetConfig(ctx)
+	if err != nil {
+		core.Status = "unreachable"
+		core.Health = types.HealthMajorOutage
+		core.Details = err.Error()
+		return []types.ServiceStatus{core}, err
+	}
+	core.Status = config.State
+	core.Description = fmt.Sprintf("Version %s", config.Version)
+
+	// Integration failures only degrade the core service, a missing admin
+	// token should not turn it red
+	var errs []error
+	var failed []string
+	if entries, err := c.homeAssistant.GetConfigEntries(ctx); err == nil {
+		for _, entry := range entries {
+			if entry.Failed() {
+				failed = append(failed, fmt.Sprintf("%s (%s)", entry.Title, entry.State))
+			}
+		}
+		*systemMetrics = append(*systemMetrics, types.Metric{
+			Name:  "homeassistant_failed_integrations",
+			Value: float64(len(failed)),
+		})
+	} else {
+		errs = append(errs, err)
+	}
+
+	core.Health = homeassistant.CoreHealth(config, len(failed))
+	switch {
+	case len(failed) > 0:
+		core.Details = fmt.Sprintf("Integrations failed: %s", strings.Join(failed, ", "))
+	case config.SafeMode || config.RecoveryMode:
+		core.Details = "Running in recovery mode"
+	}
+
+	states, err := c.homeAssistant.GetStates(ctx)
+	if err != nil {
+		return []types.ServiceStatus{core}, errors.Join(append(errs, err)...)
+	}
+
+	unavailable := 0
+	var entities []types.ServiceStatus
+	for _, state := range states {
+		if state.State == "unavailable" {
+			unavailable++
+		}
+		if len(c.homeAssistantEntities) > 0 {
+			if homeassistant.MatchEntity(c.homeAssistantEntities, state.EntityID) {
+				entities = append(entities, entityServiceStatus(state))
+			}
+			continue
+		}
+		if status, ok := c.failingEntityStatus(state); ok {
+			entities = append(entities, status)
+		}
+	}
+	*systemMetrics = append(*systemMetrics, types.Metric{
+		Name:  "homeassistant_unavailable_entities",
+		Value: float64(unavailable),
+	})
+
+	sort.Slice(entities, func(i, j int) bool {
+		return entities[i].Name < entities[j].Name
+	})
+	return append([]types.ServiceStatus{core}, entities...), errors.Join(errs...)
+}
+
+// failingEntityStatus reports entities that are unavailable or unknown when
+// no entities are configured. A single device failing only degrades the
+// page. Entities are reported once more when they recover, so the recovery
+// is in their history.
+func (c *Collector) failingEntityStatus(state homeassistant.EntityState) (types.ServiceStatus, bool) {
+	if c.homeAssistantFailing == nil {
+		c.homeAssistantFailing = make(map[string]bool)
+	}
+	status := entityServiceStatus(state)
+	if status.Health == types.HealthOperational {
+		if !c.homeAssistantFailing[state.EntityID] {
+			return status, false
+		}
+		delete(c.homeAssistantFailing, state.EntityID)
+		return status, true
+	}
+	c.homeAssistantFailing[state.EntityID] = true
+	status.Health = types.HealthDegraded
+	return status, true
+}
+
+func entityServiceStatus(state homeassistant.EntityState) types.ServiceStatus {
+	status := types.ServiceStatus{
+		Name:        state.EntityID,
+		DisplayName: state.FriendlyName(),
+		Group:       homeAssistantGroup,
+		Status:      state.State,
+		Health:      homeassistant.EntityHealth(state.State),
+	}
+
+	since := ""
+	if !state.LastChanged.IsZero() {
+		since = formatDuration(time.Since(state.LastChanged))
+	}
+	status.LastChange = since
+	switch status.Health {
+	case types.HealthOperational:
+		status.Uptime = since
+	case types.HealthMajorOutage:
+		status.Details = fmt.Sprintf("Unavailable for %s", since)
+	case types.HealthUnknown:
+		status.Details = "State unknown"
+	}
+	return status
+}
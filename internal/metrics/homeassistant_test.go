@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hra42/iot-hub-statuspage/internal/homeassistant"
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+func TestCollectHomeAssistant(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/config":
+			w.Write([]byte(`{"version": "2025.7.1", "state": "RUNNING"}`))
+		case "/api/states":
+			w.Write([]byte(`[
+				{"entity_id": "light.kitchen", "state": "on", "attributes": {"friendly_name": "Kitchen"}},
+				{"entity_id": "light.garden", "state": "unavailable", "attributes": {}},
+				{"entity_id": "light.porch", "state": "unknown", "attributes": {}},
+				{"entity_id": "sensor.outside", "state": "unavailable", "attributes": {}}
+			]`))
+		default:
+			// The config entries need an admin token
+			http.Error(w, "401: Unauthorized", http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	c := &Collector{
+		homeAssistant:         homeassistant.NewClient(server.URL, "token"),
+		homeAssistantEntities: []string{"light.*"},
+	}
+	result, err := c.collectHomeAssistant(context.Background())
+	if err == nil {
+		t.Error("collectHomeAssistant returned no error for the rejected config entries")
+	}
+
+	want := map[string]types.Health{
+		"core":          types.HealthOperational,
+		"light.garden":  types.HealthMajorOutage,
+		"light.kitchen": types.HealthOperational,
+		"light.porch":   types.HealthUnknown,
+	}
+	if len(result.Services) != len(want) {
+		t.Fatalf("got %d services, want %d: %+v", len(result.Services), len(want), result.Services)
+	}
+	for _, service := range result.Services {
+		if health, ok := want[service.Name]; !ok || service.Health != health {
+			t.Errorf("service %s has health %q, want %q", service.Name, service.Health, want[service.Name])
+		}
+	}
+
+	var unavailable float64 = -1
+	for _, metric := range result.Metrics {
+		if metric.Name == "homeassistant_unavailable_entities" {
+			unavailable = metric.Value
+		}
+	}
+	if unavailable != 2 {
+		t.Errorf("homeassistant_unavailable_entities = %v, want 2", unavailable)
+	}
+}
+
+func TestCollectHomeAssistantUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c := &Collector{homeAssistant: homeassistant.NewClient(server.URL, "token")}
+	result, err := c.collectHomeAssistant(context.Background())
+	if err == nil {
+		t.Fatal("collectHomeAssistant returned no error for a failing API")
+	}
+	if len(result.Services) != 1 || result.Services[0].Health != types.HealthMajorOutage {
+		t.Errorf("services = %+v, want the core with a major outage", result.Services)
+	}
+}
+
+func TestCollectHomeAssistantFailingEntities(t *testing.T) {
+	var states atomic.Value
+	states.Store(`[
+		{"entity_id": "light.kitchen", "state": "on", "attributes": {}},
+		{"entity_id": "light.garden", "state": "unavailable", "attributes": {}},
+		{"entity_id": "sensor.outside", "state": "unknown", "attributes": {}}
+	]`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/config":
+			w.Write([]byte(`{"version": "2025.7.1", "state": "RUNNING"}`))
+		case "/api/states":
+			w.Write([]byte(states.Load().(string)))
+		default:
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+
+	// Without configured entities, the failing ones are services
+	c := &Collector{homeAssistant: homeassistant.NewClient(server.URL, "token")}
+	collect := func() map[string]types.Health {
+		t.Helper()
+		result, err := c.collectHomeAssistant(context.Background())
+		if err != nil {
+			t.Fatalf("collectHomeAssistant: %v", err)
+		}
+		health := make(map[string]types.Health)
+		for _, service := range result.Services {
+			health[service.Name] = service.Health
+		}
+		return health
+	}
+	want := map[string]types.Health{
+		"core":           types.HealthOperational,
+		"light.garden":   types.HealthDegraded,
+		"sensor.outside": types.HealthDegraded,
+	}
+	if got := collect(); !reflect.DeepEqual(got, want) {
+		t.Errorf("services = %v, want %v", got, want)
+	}
+
+	// A recovered entity is reported once more, then no longer
+	states.Store(`[
+		{"entity_id": "light.kitchen", "state": "on", "attributes": {}},
+		{"entity_id": "light.garden", "state": "on", "attributes": {}},
+		{"entity_id": "sensor.outside", "state": "unknown", "attributes": {}}
+	]`)
+	want["light.garden"] = types.HealthOperational
+	if got := collect(); !reflect.DeepEqual(got, want) {
+		t.Errorf("services after the recovery = %v, want %v", got, want)
+	}
+	delete(want, "light.garden")
+	if got := collect(); !reflect.DeepEqual(got, want) {
+		t.Errorf("services after reporting the recovery = %v, want %v", got, want)
+	}
+}
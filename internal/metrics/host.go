@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/mem"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+// collectHost reads CPU, memory, swap, load, root disk usage and uptime of
+// the host.
+func (c *Collector) collectHost(ctx context.Context) (*Result, error) {
+	result := &Result{}
+	var errs []error
+
+	// CPU usage since the previous run, without blocking
+	cpuPercent, err := cpu.PercentWithContext(ctx, 0, false)
+	if err == nil && len(cpuPercent) > 0 {
+		result.Metrics = append(result.Metrics, types.Metric{
+			Name:  "cpu",
+			Value: cpuPercent[0],
+			Unit:  types.UnitPercent,
+			Title: "CPU Usage",
+			Icon:  "fas fa-microchip text-blue-400",
+		})
+	} else if err != nil {
+		errs = append(errs, fmt.Errorf("cpu: %w", err))
+	}
+
+	if vmStat, err := mem.VirtualMemoryWithContext(ctx); err == nil {
+		result.Metrics = append(result.Metrics,
+			types.Metric{
+				Name:   "memory",
+				Value:  vmStat.UsedPercent,
+				Unit:   types.UnitPercent,
+				Title:  "Memory Usage",
+				Detail: fmt.Sprintf("%s / %s", formatBytes(float64(vmStat.Used)), formatBytes(float64(vmStat.Total))),
+				Icon:   "fas fa-memory text-purple-400",
+			},
+			types.Metric{Name: "memory_used", Value: float64(vmStat.Used), Unit: types.UnitBytes},
+			types.Metric{Name: "memory_total", Value: float64(vmStat.Total), Unit: types.UnitBytes},
+		)
+	} else {
+		errs = append(errs, fmt.Errorf("memory: %w", err))
+	}
+
+	if diskStat, err := disk.UsageWithContext(ctx, "/"); err == nil {
+		result.Metrics = append(result.Metrics,
+			types.Metric{
+				Name:   "disk",
+				Value:  diskStat.UsedPercent,
+				Unit:   types.UnitPercent,
+				Title:  "Disk Usage",
+				Detail: fmt.Sprintf("%s / %s", formatBytes(float64(diskStat.Used)), formatBytes(float64(diskStat.Total))),
+				Icon:   "fas fa-hard-drive text-orange-400",
+			},
+			types.Metric{Name: "disk_used", Value: float64(diskStat.Used), Unit: types.UnitBytes},
+			types.Metric{Name: "disk_total", Value: float64(diskStat.Total), Unit: types.UnitBytes},
+		)
+	} else {
+		errs = append(errs, fmt.Errorf("disk: %w", err))
+	}
+
+	if err := c.collectLoad(ctx, &result.Metrics); err != nil {
+		errs = append(errs, err)
+	}
+
+	if uptime, err := host.UptimeWithContext(ctx); err == nil {
+		result.Metrics = append(result.Metrics, types.Metric{
+			Name:  "uptime",
+			Value: float64(uptime),
+			Unit:  types.UnitSeconds,
+			Title: "System Uptime",
+			Icon:  "fas fa-clock text-green-400",
+		})
+	}
+
+	return result, errors.Join(errs...)
+}
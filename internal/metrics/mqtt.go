@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+// collectMQTT reports the broker statistics gathered by the MQTT monitor
+// since the last collection.
+func (c *Collector) collectMQTT(ctx context.Context) (*Result, error) {
+	stats := c.mqtt.Stats()
+	result := &Result{
+		Connections: []types.HostConnection{{Name: "MQTT Broker", Connected: stats.Connected, Icon: "fas fa-tower-broadcast"}},
+	}
+	if !stats.Connected {
+		return result, fmt.Errorf("not connected to the broker")
+	}
+
+	result.Metrics = []types.Metric{
+		{
+			Name:   "mqtt_clients_connected",
+			Value:  float64(stats.ClientsConnected),
+			Title:  "MQTT Clients",
+			Detail: fmt.Sprintf("%d retained", stats.RetainedMessages),
+			Icon:   "fas fa-tower-broadcast text-teal-400",
+		},
+		{
+			Name:  "mqtt_messages_received_rate",
+			Value: stats.MessagesReceivedPerSecond,
+			Unit:  types.UnitPerSecond,
+			Title: "MQTT Messages In",
+			Icon:  "fas fa-envelope text-teal-400",
+		},
+		{
+			Name:  "mqtt_messages_sent_rate",
+			Value: stats.MessagesSentPerSecond,
+			Unit:  types.UnitPerSecond,
+			Title: "MQTT Messages Out",
+			Icon:  "fas fa-paper-plane text-teal-400",
+		},
+		{Name: "mqtt_retained_messages", Value: float64(stats.RetainedMessages)},
+	}
+	return result, nil
+}
+
+// collectDevices reports the liveness of the devices tracked over MQTT as
+// services.
+func (c *Collector) collectDevices(ctx context.Context) (*Result, error) {
+	result := &Result{}
+	for _, device := range c.mqtt.Devices() {
+		service := types.ServiceStatus{
+			Name:    device.Name,
+			Group:   "Devices",
+			Status:  "online",
+			Health:  types.HealthOperational,
+			Details: device.Details,
+		}
+		if !device.Online {
+			service.Status = "offline"
+			service.Health = types.HealthMajorOutage
+			if !device.LastSeen.IsZero() {
+				service.LastChange = formatDuration(time.Since(device.LastSeen))
+				if service.Details == "" {
+					service.Details = fmt.Sprintf("Last seen %s ago", service.LastChange)
+				}
+			}
+		}
+		result.Services = append(result.Services, service)
+	}
+	return result, nil
+}
+
+// GetDevices returns the liveness of all devices tracked over MQTT.
+func (c *Collector) GetDevices() []types.DeviceStatus {
+	if c.mqtt == nil {
+		return nil
+	}
+	return c.mqtt.Devices()
+}
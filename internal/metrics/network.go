@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"path"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/net"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+// defaultExcludedInterfaces are virtual interfaces whose traffic is already
+// counted on the physical ones.
+var defaultExcludedInterfaces = []string{"lo", "docker*", "br-*", "veth*", "virbr*", "cni*", "flannel*"}
+
+// collectInterfaces computes the per second rates of the included
+// interfaces and the host wide network rate as their sum.
+func (c *Collector) collectInterfaces(ctx context.Context, metrics *types.SystemMetrics, systemMetrics *[]types.Metric) error {
+	counters, err := net.IOCountersWithContext(ctx, true)
+	if err != nil {
+		return fmt.Errorf("failed to get network counters: %w", err)
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(c.lastInterfacesTime).Seconds()
+	previous := c.lastInterfaces
+	c.lastInterfaces = make(map[string]net.IOCountersStat, len(counters))
+	c.lastInterfacesTime = now
+
+	var networkIn, networkOut float64
+	for _, current := range counters {
+		if !c.includeInterface(current.Name) {
+			continue
+		}
+		c.lastInterfaces[current.Name] = current
+
+		last, ok := previous[current.Name]
+		if !ok || elapsed <= 0 {
+			// First sample, rates need two
+			continue
+		}
+
+		stats := types.InterfaceStats{
+			Name:     current.Name,
+			RxRate:   float64(counterDelta(last.BytesRecv, current.BytesRecv)) / elapsed,
+			TxRate:   float64(counterDelta(last.BytesSent, current.BytesSent)) / elapsed,
+			RxErrors: float64(counterDelta(last.Errin, current.Errin)) / elapsed,
+			TxErrors: float64(counterDelta(last.Errout, current.Errout)) / elapsed,
+			RxDrops:  float64(counterDelta(last.Dropin, current.Dropin)) / elapsed,
+			TxDrops:  float64(counterDelta(last.Dropout, current.Dropout)) / elapsed,
+		}
+		metrics.Interfaces = append(metrics.Interfaces, stats)
+		networkIn += stats.RxRate
+		networkOut += stats.TxRate
+
+		labels := map[string]string{"interface": stats.Name}
+		*systemMetrics = append(*systemMetrics,
+			types.Metric{Name: "interface_rx_rate", Value: stats.RxRate, Labels: labels},
+			types.Metric{Name: "interface_tx_rate", Value: stats.TxRate, Labels: labels},
+			types.Metric{Name: "interface_rx_errors", Value: stats.RxErrors, Labels: labels},
+			types.Metric{Name: "interface_tx_errors", Value: stats.TxErrors, Labels: labels},
+			types.Metric{Name: "interface_rx_drops", Value: stats.RxDrops, Labels: labels},
+			types.Metric{Name: "interface_tx_drops", Value: stats.TxDrops, Labels: labels},
+		)
+	}
+
+	if previous == nil {
+		return nil
+	}
+	*systemMetrics = append(*systemMetrics,
+		types.Metric{
+			Name:  "network_in_rate",
+			Value: networkIn,
+			Unit:  types.UnitBytesPerSecond,
+			Title: "Network In",
+			Icon:  "fas fa-download text-cyan-400",
+		},
+		types.Metric{
+			Name:  "network_out_rate",
+			Value: networkOut,
+			Unit:  types.UnitBytesPerSecond,
+			Title: "Network Out",
+			Icon:  "fas fa-upload text-pink-400",
+		},
+	)
+	return nil
+}
+
+// includeInterface matches name against the configured interface globs, or
+// excludes virtual interfaces when none are configured.
+func (c *Collector) includeInterface(name string) bool {
+	if len(c.interfaces) > 0 {
+		return matchAny(c.interfaces, name)
+	}
+	return !matchAny(defaultExcludedInterfaces, name)
+}
+
+func matchAny(globs []string, name string) bool {
+	for _, glob := range globs {
+		if matched, _ := path.Match(glob, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// maxWrapDelta is the largest advance assumed to have wrapped a 32-bit
+// counter. It is more than a gigabit link moves in a collection interval,
+// and anything beyond it is taken for a reset.
+const maxWrapDelta = 1 << 30
+
+// counterDelta returns how much a counter advanced since the previous
+// sample. A counter that went backwards either wrapped around at 32 bits,
+// as some drivers report them, or was reset, e.g. when the interface was
+// recreated. Resets count as no advance, like in counterRate, since the
+// count before it is unknown.
+func counterDelta(previous, current uint64) uint64 {
+	if current >= previous {
+		return current - previous
+	}
+	if previous <= math.MaxUint32 {
+		if delta := math.MaxUint32 - previous + current + 1; delta <= maxWrapDelta {
+			return delta
+		}
+	}
+	return 0
+}
@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		name              string
+		previous, current uint64
+		want              uint64
+	}{
+		{"unchanged", 1000, 1000, 0},
+		{"32-bit increase", 1000, 5000, 4000},
+		{"64-bit increase", 1 << 40, 1<<40 + 1<<33, 1 << 33},
+		{"32-bit wrap", math.MaxUint32 - 99, 400, 500},
+		{"32-bit wrap to zero", math.MaxUint32, 0, 1},
+		// A reset is not a wrap, wherever the counter was
+		{"reset from the upper half", math.MaxUint32/2 + 10, 100, 0},
+		{"reset below the wrap", 1 << 31, 0, 0},
+		{"reset of a 64-bit counter", 1 << 40, 100, 0},
+		{"reset of a small counter", 5000, 100, 0},
+	}
+	for _, tt := range tests {
+		if got := counterDelta(tt.previous, tt.current); got != tt.want {
+			t.Errorf("%s: counterDelta(%d, %d) = %d, want %d", tt.name, tt.previous, tt.current, got, tt.want)
+		}
+	}
+}
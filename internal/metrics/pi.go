@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hra42/iot-hub-statuspage/internal/pi"
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+const piGroup = "Raspberry Pi"
+
+// PiConfig configures the Raspberry Pi telemetry.
+type PiConfig struct {
+	// Reader reads the telemetry. It is ignored on hosts without thermal
+	// zones.
+	Reader *pi.Reader
+	// WarningTemp is the temperature in °C above which the Pi is degraded
+	WarningTemp float64
+	// CriticalTemp is the temperature in °C above which the Pi is a major
+	// outage
+	CriticalTemp float64
+}
+
+// collectPi reads the temperature, clock and power state of the host and
+// appends them for the bulk insert.
+func (c *Collector) collectPi(ctx context.Context, metrics *types.SystemMetrics, systemMetrics *[]types.Metric) error {
+	if c.pi == nil {
+		return nil
+	}
+
+	// The rest of the telemetry is still recorded when vcgencmd fails
+	telemetry, err := c.pi.Reader.Read(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to read throttling state: %w", err)
+	}
+	metrics.Pi = &telemetry
+
+	for _, temp := range telemetry.Temperatures {
+		*systemMetrics = append(*systemMetrics, types.Metric{
+			Name:   "temperature",
+			Value:  temp.Celsius,
+			Labels: map[string]string{"zone": temp.Zone},
+		})
+	}
+	if telemetry.CPUFrequencyMHz > 0 {
+		*systemMetrics = append(*systemMetrics, types.Metric{
+			Name:  "cpu_frequency",
+			Value: telemetry.CPUFrequencyMHz,
+		})
+	}
+	if telemetry.FanRPM != nil {
+		*systemMetrics = append(*systemMetrics, types.Metric{
+			Name:  "fan_speed",
+			Value: *telemetry.FanRPM,
+		})
+	}
+	if throttled := telemetry.Throttled; throttled != nil {
+		*systemMetrics = append(*systemMetrics,
+			types.Metric{Name: "throttled_flags", Value: float64(throttled.Raw)},
+			types.Metric{Name: "under_voltage", Value: boolValue(throttled.UnderVoltage)},
+			types.Metric{Name: "throttled", Value: boolValue(throttled.Throttled || throttled.FrequencyCapped)},
+		)
+	}
+	return err
+}
+
+// piServices maps the telemetry onto services, so the temperature and power
+// state show up in the history and alerts like any other service.
+func (c *Collector) piServices(telemetry types.PiTelemetry) []types.ServiceStatus {
+	var services []types.ServiceStatus
+	if len(telemetry.Temperatures) > 0 {
+		temp := telemetry.MaxTemperature()
+		status := types.ServiceStatus{
+			Name:    "temperature",
+			Group:   piGroup,
+			Details: fmt.Sprintf("%.1f °C", temp),
+		}
+		switch {
+		case temp >= c.pi.CriticalTemp:
+			status.Status = "critical"
+			status.Health = types.HealthMajorOutage
+		case temp >= c.pi.WarningTemp:
+			status.Status = "warning"
+			status.Health = types.HealthDegraded
+		default:
+			status.Status = "ok"
+			status.Health = types.HealthOperational
+		}
+		services = append(services, status)
+	}
+
+	// Under-voltage risks SD card corruption, while throttling only slows
+	// the Pi down
+	if throttled := telemetry.Throttled; throttled != nil {
+		status := types.ServiceStatus{
+			Name:    "power",
+			Group:   piGroup,
+			Details: throttled.Summary(),
+		}
+		switch {
+		case throttled.UnderVoltage:
+			status.Status = "under-voltage"
+			status.Health = types.HealthMajorOutage
+		case throttled.Throttled || throttled.FrequencyCapped:
+			status.Status = "throttled"
+			status.Health = types.HealthDegraded
+		default:
+			status.Status = "ok"
+			status.Health = types.HealthOperational
+		}
+		services = append(services, status)
+	}
+	return services
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
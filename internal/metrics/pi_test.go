@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+func TestPiServices(t *testing.T) {
+	c := &Collector{pi: &PiConfig{WarningTemp: 70, CriticalTemp: 80}}
+	tests := []struct {
+		name      string
+		telemetry types.PiTelemetry
+		want      map[string]types.Health
+	}{
+		{
+			name: "cool and powered",
+			telemetry: types.PiTelemetry{
+				Temperatures: []types.Temperature{{Zone: "cpu-thermal", Celsius: 52}},
+				Throttled:    &types.ThrottledState{UnderVoltageOccurred: true},
+			},
+			want: map[string]types.Health{"temperature": types.HealthOperational, "power": types.HealthOperational},
+		},
+		{
+			name: "hottest zone counts",
+			telemetry: types.PiTelemetry{
+				Temperatures: []types.Temperature{{Zone: "a", Celsius: 50}, {Zone: "b", Celsius: 72}},
+			},
+			want: map[string]types.Health{"temperature": types.HealthDegraded},
+		},
+		{
+			name: "critical and throttled",
+			telemetry: types.PiTelemetry{
+				Temperatures: []types.Temperature{{Zone: "cpu-thermal", Celsius: 85}},
+				Throttled:    &types.ThrottledState{FrequencyCapped: true},
+			},
+			want: map[string]types.Health{"temperature": types.HealthMajorOutage, "power": types.HealthDegraded},
+		},
+		{
+			name: "under-voltage",
+			telemetry: types.PiTelemetry{
+				Throttled: &types.ThrottledState{UnderVoltage: true, Throttled: true},
+			},
+			want: map[string]types.Health{"power": types.HealthMajorOutage},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			services := c.piServices(tt.telemetry)
+			if len(services) != len(tt.want) {
+				t.Fatalf("got %d services, want %d", len(services), len(tt.want))
+			}
+			for _, service := range services {
+				if service.Group != piGroup {
+					t.Errorf("%s: group = %q", service.Name, service.Group)
+				}
+				if want, ok := tt.want[service.Name]; !ok || service.Health != want {
+					t.Errorf("%s: health = %q, want %q", service.Name, service.Health, want)
+				}
+			}
+		})
+	}
+}
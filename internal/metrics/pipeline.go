@@ -0,0 +1,296 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hra42/iot-hub-statuspage/internal/pubsub"
+	"github.com/hra42/iot-hub-statuspage/internal/storage"
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+// flushInterval is how often collected rows are written to the database
+// and the snapshot is published.
+const flushInterval = 5 * time.Second
+
+// ParseIntervals parses per source collection intervals such as
+// "docker=30s,homeassistant=1m".
+func ParseIntervals(spec string) (map[string]time.Duration, error) {
+	intervals := make(map[string]time.Duration)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid interval %q, expected source=duration", entry)
+		}
+		interval, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval for %s: %w", name, err)
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("interval for %s must be positive", name)
+		}
+		intervals[strings.TrimSpace(name)] = interval
+	}
+	return intervals, nil
+}
+
+// applyIntervals overrides the registered intervals of the sources. Timeouts
+// never exceed the interval, so runs of a source do not pile up.
+func applyIntervals(entries []registration, intervals map[string]time.Duration) {
+	known := make(map[string]bool, len(entries))
+	for i := range entries {
+		name := entries[i].source.Name()
+		known[name] = true
+		if interval, ok := intervals[name]; ok {
+			entries[i].interval = interval
+		}
+		if entries[i].timeout > entries[i].interval {
+			entries[i].timeout = entries[i].interval
+		}
+	}
+	for name := range intervals {
+		if !known[name] {
+			log.Printf("Warning: Interval configured for unknown or disabled source %q", name)
+		}
+	}
+}
+
+// withServiceIDs sets the ID of services reported by a source.
+func withServiceIDs(source string, services []types.ServiceStatus) []types.ServiceStatus {
+	for i := range services {
+		services[i].ID = types.ServiceKey(source, services[i])
+	}
+	return services
+}
+
+// runSource runs a source on its interval, and when triggered, until ctx is
+// done.
+func (c *Collector) runSource(ctx context.Context, entry registration) {
+	ticker := time.NewTicker(entry.interval)
+	defer ticker.Stop()
+
+	for {
+		c.runOnce(ctx, entry)
+		select {
+		case <-ticker.C:
+		case <-entry.trigger:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Trigger runs a source right away instead of waiting for its interval, so
+// a change it learned about between two runs shows without delay. Runs of
+// a source never overlap; a trigger during a run starts another one after
+// it.
+func (c *Collector) Trigger(name string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, entry := range c.registry.entries {
+		if entry.source.Name() == name {
+			select {
+			case entry.trigger <- struct{}{}:
+			default:
+				// A run is already pending
+			}
+			return
+		}
+	}
+}
+
+func (c *Collector) runOnce(ctx context.Context, entry registration) {
+	name := entry.source.Name()
+	runCtx, cancel := context.WithTimeout(ctx, entry.timeout)
+	defer cancel()
+
+	start := time.Now()
+	result, err := entry.source.Collect(runCtx)
+	duration := time.Since(start)
+	if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+		if err != nil {
+			err = fmt.Errorf("timed out after %s: %w", entry.timeout, err)
+		} else {
+			err = fmt.Errorf("timed out after %s", entry.timeout)
+		}
+	}
+	if result == nil {
+		result = &Result{}
+	}
+
+	c.mu.Lock()
+	if result.apply != nil {
+		result.apply(&c.current)
+	}
+	c.readings[name] = &types.SourceReading{
+		Source:      name,
+		Metrics:     result.Metrics,
+		Services:    withServiceIDs(name, result.Services),
+		Connections: result.Connections,
+	}
+	for _, metric := range result.Metrics {
+		c.pendingMetrics = append(c.pendingMetrics, storage.SystemMetric{
+			MetricType: metric.Name,
+			Value:      metric.Value,
+			Labels:     metric.Labels,
+		})
+	}
+	c.pendingMetrics = append(c.pendingMetrics, storage.SystemMetric{
+		MetricType: "collect_duration",
+		Value:      float64(duration.Microseconds()) / 1000,
+		Labels:     map[string]string{"source": name},
+	})
+	for _, service := range result.Services {
+		c.pendingStatuses = append(c.pendingStatuses, storage.ServiceStatus{
+			Service: service.ID,
+			Status:  string(service.Health),
+			Details: service.Details,
+		})
+	}
+
+	status := c.sourceStatus[name]
+	previousError := status.LastError
+	failing := status.Failing
+	status.LastRun = start
+	status.DurationMs = float64(duration.Microseconds()) / 1000
+	status.Failing = err != nil
+	if err != nil {
+		status.LastError = err.Error()
+		status.LastErrorAt = time.Now()
+	}
+	c.mu.Unlock()
+
+	// Repeated errors are only logged once
+	switch {
+	case err != nil && (!failing || err.Error() != previousError):
+		log.Printf("Error collecting %s: %v", name, err)
+	case err == nil && failing:
+		log.Printf("Collecting %s recovered", name)
+	}
+
+	c.publish()
+}
+
+// runFlush periodically writes the collected rows and publishes the
+// snapshot, and does a last flush once ctx is done and the sources stopped.
+func (c *Collector) runFlush(ctx context.Context, sources *sync.WaitGroup) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.flush()
+		case <-ctx.Done():
+			sources.Wait()
+			c.flush()
+			return
+		}
+	}
+}
+
+func (c *Collector) flush() {
+	c.mu.Lock()
+	metrics, statuses := c.pendingMetrics, c.pendingStatuses
+	c.pendingMetrics, c.pendingStatuses = nil, nil
+	snapshot := c.current
+	readings := c.readingsLocked()
+	c.mu.Unlock()
+
+	if c.publisher != nil {
+		c.publisher.Publish(snapshot, readings)
+	}
+
+	if len(metrics) == 0 && len(statuses) == 0 {
+		return
+	}
+	if err := c.db.BulkInsert(metrics, statuses); err != nil {
+		log.Printf("Failed to perform bulk insert: %v", err)
+	}
+}
+
+// readingsLocked returns the latest reading of every source that ran, in
+// registration order. c.mu must be held.
+func (c *Collector) readingsLocked() []types.SourceReading {
+	readings := make([]types.SourceReading, 0, len(c.registry.entries))
+	for _, entry := range c.registry.entries {
+		if reading, ok := c.readings[entry.source.Name()]; ok {
+			readings = append(readings, *reading)
+		}
+	}
+	return readings
+}
+
+// GetReadings returns the latest reading of every source.
+func (c *Collector) GetReadings() []types.SourceReading {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.readingsLocked()
+}
+
+// GetServices returns the services reported by all sources.
+func (c *Collector) GetServices() []types.ServiceStatus {
+	return c.Snapshot().Services()
+}
+
+// GetDisplayMetrics returns the metrics of all sources that are shown on
+// the dashboard.
+func (c *Collector) GetDisplayMetrics() []types.Metric {
+	return c.Snapshot().DisplayMetrics()
+}
+
+// GetConnections returns the connections reported by all sources.
+func (c *Collector) GetConnections() []types.HostConnection {
+	return c.Snapshot().Connections()
+}
+
+// Snapshot returns the current state of all sources.
+func (c *Collector) Snapshot() types.Snapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return types.Snapshot{
+		Time:     time.Now(),
+		Readings: c.readingsLocked(),
+		System:   c.current,
+	}
+}
+
+// Subscribe returns a subscription to the snapshots published after every
+// collection and every change between collections, e.g. a container
+// stopping. Subscribers only get the latest snapshot if they fall behind.
+func (c *Collector) Subscribe() *pubsub.Subscription[types.Snapshot] {
+	return c.snapshots.Subscribe()
+}
+
+// publish publishes the current state. Snapshots are taken and published
+// in one step, so a subscriber never gets an older one after a newer one.
+func (c *Collector) publish() {
+	c.publishMu.Lock()
+	defer c.publishMu.Unlock()
+	c.snapshots.Publish(c.Snapshot())
+}
+
+// GetSourceStatus returns the schedule, last duration and last error of
+// every source.
+func (c *Collector) GetSourceStatus() []types.SourceStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	statuses := make([]types.SourceStatus, 0, len(c.registry.entries))
+	for _, entry := range c.registry.entries {
+		// Sources are tracked once the collector started
+		if status, ok := c.sourceStatus[entry.source.Name()]; ok {
+			statuses = append(statuses, *status)
+		}
+	}
+	return statuses
+}
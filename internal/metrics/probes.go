@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+// ProbeHost is a host whose reachability is checked with ping.
+type ProbeHost struct {
+	Name    string
+	Address string
+}
+
+// ParseProbeHosts parses a comma separated list of name=address pairs such
+// as "Pi5=192.168.2.136,NAS=nas.local".
+func ParseProbeHosts(spec string) ([]ProbeHost, error) {
+	var hosts []ProbeHost
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, address, found := strings.Cut(entry, "=")
+		name, address = strings.TrimSpace(name), strings.TrimSpace(address)
+		if !found || name == "" || address == "" {
+			return nil, fmt.Errorf("invalid probe host %q, expected name=address", entry)
+		}
+		hosts = append(hosts, ProbeHost{Name: name, Address: address})
+	}
+	return hosts, nil
+}
+
+// collectProbes pings the monitored hosts in parallel.
+func (c *Collector) collectProbes(ctx context.Context) (*Result, error) {
+	reachable := make([]bool, len(c.probes))
+	var wg sync.WaitGroup
+	for i, probe := range c.probes {
+		wg.Add(1)
+		go func(i int, address string) {
+			defer wg.Done()
+			reachable[i] = pingHost(ctx, address)
+		}(i, probe.Address)
+	}
+	wg.Wait()
+
+	result := &Result{}
+	for i, probe := range c.probes {
+		result.Connections = append(result.Connections, types.HostConnection{
+			Name:      probe.Name,
+			Connected: reachable[i],
+			Address:   probe.Address,
+			Icon:      "fas fa-server",
+		})
+		result.Metrics = append(result.Metrics, types.Metric{
+			Name:   "host_reachable",
+			Value:  boolValue(reachable[i]),
+			Labels: map[string]string{"host": probe.Name},
+		})
+	}
+	return result, nil
+}
+
+func pingHost(ctx context.Context, host string) bool {
+	// Use ping command with timeout
+	cmd := exec.CommandContext(ctx, "ping", "-c", "1", "-W", "2", host)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		log.Printf("Ping to %s failed: %v, output: %s", host, err, string(output))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/process"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+// topProcesses is the number of processes listed per ranking.
+const topProcesses = 5
+
+// collectLoad reads load averages and swap usage.
+func (c *Collector) collectLoad(ctx context.Context, systemMetrics *[]types.Metric) error {
+	var errs []error
+	if avg, err := load.AvgWithContext(ctx); err == nil {
+		*systemMetrics = append(*systemMetrics,
+			types.Metric{
+				Name:   "load1",
+				Value:  avg.Load1,
+				Title:  "Load Average",
+				Detail: fmt.Sprintf("%.2f / %.2f (5m / 15m)", avg.Load5, avg.Load15),
+				Icon:   "fas fa-gauge-high text-yellow-400",
+			},
+			types.Metric{Name: "load5", Value: avg.Load5},
+			types.Metric{Name: "load15", Value: avg.Load15},
+		)
+	} else {
+		errs = append(errs, fmt.Errorf("load average: %w", err))
+	}
+
+	if swap, err := mem.SwapMemoryWithContext(ctx); err == nil {
+		usage := types.Metric{Name: "swap", Value: swap.UsedPercent, Unit: types.UnitPercent}
+		// Hosts without swap do not show the card
+		if swap.Total > 0 {
+			usage.Title = "Swap Usage"
+			usage.Detail = fmt.Sprintf("%s / %s", formatBytes(float64(swap.Used)), formatBytes(float64(swap.Total)))
+			usage.Icon = "fas fa-right-left text-purple-400"
+		}
+		*systemMetrics = append(*systemMetrics,
+			usage,
+			types.Metric{Name: "swap_used", Value: float64(swap.Used), Unit: types.UnitBytes},
+		)
+	} else {
+		errs = append(errs, fmt.Errorf("swap: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// collectProcesses counts processes and threads and captures the busiest
+// processes by CPU and resident memory.
+func (c *Collector) collectProcesses(ctx context.Context, metrics *types.SystemMetrics, systemMetrics *[]types.Metric) error {
+	pids, err := process.PidsWithContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list processes: %w", err)
+	}
+
+	snapshot := &types.ProcessSnapshot{Total: len(pids), CapturedAt: time.Now()}
+	if misc, err := load.MiscWithContext(ctx); err == nil {
+		snapshot.Running = misc.ProcsRunning
+	}
+
+	// Processes are kept between collections, as CPU usage is measured
+	// since the previous call on the same process
+	seen := make(map[int32]*process.Process, len(pids))
+	infos := make([]types.ProcessInfo, 0, len(pids))
+	for _, pid := range pids {
+		proc, ok := c.processes[pid]
+		if !ok {
+			if proc, err = process.NewProcessWithContext(ctx, pid); err != nil {
+				continue
+			}
+		}
+		cpuPercent, err := proc.PercentWithContext(ctx, 0)
+		if err != nil {
+			// Exited in the meantime
+			continue
+		}
+		seen[pid] = proc
+
+		info := types.ProcessInfo{PID: pid, CPUPercent: cpuPercent}
+		info.Name, _ = proc.NameWithContext(ctx)
+		if memory, err := proc.MemoryInfoWithContext(ctx); err == nil {
+			info.RSS = memory.RSS
+		}
+		info.Threads, _ = proc.NumThreadsWithContext(ctx)
+		snapshot.Threads += int(info.Threads)
+		infos = append(infos, info)
+	}
+	c.processes = seen
+
+	snapshot.TopCPU = topBy(infos, func(a, b types.ProcessInfo) bool { return a.CPUPercent > b.CPUPercent })
+	snapshot.TopMemory = topBy(infos, func(a, b types.ProcessInfo) bool { return a.RSS > b.RSS })
+
+	// User and command line are only looked up for the listed processes
+	for _, list := range [][]types.ProcessInfo{snapshot.TopCPU, snapshot.TopMemory} {
+		for i := range list {
+			proc := seen[list[i].PID]
+			list[i].Username, _ = proc.UsernameWithContext(ctx)
+			list[i].Cmdline, _ = proc.CmdlineWithContext(ctx)
+		}
+	}
+
+	metrics.Processes = snapshot
+	*systemMetrics = append(*systemMetrics,
+		types.Metric{
+			Name:   "process_count",
+			Value:  float64(snapshot.Total),
+			Title:  "Processes",
+			Detail: fmt.Sprintf("%d running, %d threads", snapshot.Running, snapshot.Threads),
+			Icon:   "fas fa-list-check text-blue-400",
+		},
+		types.Metric{Name: "thread_count", Value: float64(snapshot.Threads)},
+	)
+	return nil
+}
+
+// topBy returns the first topProcesses processes ordered by less, without
+// reordering infos.
+func topBy(infos []types.ProcessInfo, less func(a, b types.ProcessInfo) bool) []types.ProcessInfo {
+	sorted := make([]types.ProcessInfo, len(infos))
+	copy(sorted, infos)
+	sort.SliceStable(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+	if len(sorted) > topProcesses {
+		sorted = sorted[:topProcesses]
+	}
+	return sorted
+}
+
+func (c *Collector) GetProcesses() *types.ProcessSnapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.current.Processes
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+// Source is something the collector monitors. The collector runs every
+// registered source on its own schedule, stores what it returns and hands
+// it to the dashboard and MQTT without knowing the source.
+type Source interface {
+	// Name identifies the source. It prefixes the stored keys of its
+	// services, e.g. haproxy_<backend>.
+	Name() string
+	// Collect reads the current state. When only part of it could be read,
+	// the partial result is returned alongside the error.
+	Collect(ctx context.Context) (*Result, error)
+}
+
+// Result is what one run of a source produced.
+type Result struct {
+	Metrics     []types.Metric
+	Services    []types.ServiceStatus
+	Connections []types.HostConnection
+
+	// apply merges the structured readings of built-in sources into the
+	// snapshot. It runs under the collector lock.
+	apply func(*types.SystemMetrics)
+}
+
+// Registry holds the sources of a collector with their schedule.
+type Registry struct {
+	entries []registration
+}
+
+type registration struct {
+	source   Source
+	interval time.Duration
+	timeout  time.Duration
+	// trigger requests a run before the interval passed
+	trigger chan struct{}
+}
+
+// Register adds a source that runs every interval and is cancelled after
+// timeout. Timeouts never exceed the interval, so runs do not pile up.
+func (r *Registry) Register(source Source, interval, timeout time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("interval of source %s must be positive, got %s", source.Name(), interval)
+	}
+	if timeout <= 0 || timeout > interval {
+		timeout = interval
+	}
+	r.entries = append(r.entries, registration{
+		source:   source,
+		interval: interval,
+		timeout:  timeout,
+		trigger:  make(chan struct{}, 1),
+	})
+	return nil
+}
+
+// NewSource returns a Source that calls collect, e.g. to adapt a package
+// that reports plain types.
+func NewSource(name string, collect func(ctx context.Context) (*Result, error)) Source {
+	return sourceFunc{name: name, collect: collect}
+}
+
+// sourceFunc adapts a collect function to a Source.
+type sourceFunc struct {
+	name    string
+	collect func(ctx context.Context) (*Result, error)
+}
+
+func (s sourceFunc) Name() string { return s.name }
+
+func (s sourceFunc) Collect(ctx context.Context) (*Result, error) { return s.collect(ctx) }
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hra42/iot-hub-statuspage/internal/haproxy"
+	"github.com/hra42/iot-hub-statuspage/internal/pubsub"
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+func TestRegistryRegister(t *testing.T) {
+	source := sourceFunc{name: "test", collect: func(context.Context) (*Result, error) { return &Result{}, nil }}
+
+	var r Registry
+	for _, interval := range []time.Duration{0, -time.Second} {
+		if err := r.Register(source, interval, time.Second); err == nil {
+			t.Errorf("Register accepted an interval of %s", interval)
+		}
+	}
+	if len(r.entries) != 0 {
+		t.Fatalf("rejected sources were registered: %d", len(r.entries))
+	}
+
+	if err := r.Register(source, 5*time.Second, 10*time.Second); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if entry := r.entries[0]; entry.timeout != 5*time.Second {
+		t.Errorf("timeout = %s, want it capped at the interval of 5s", entry.timeout)
+	}
+}
+
+func TestParseIntervals(t *testing.T) {
+	intervals, err := ParseIntervals("docker=30s, homeassistant=1m,")
+	if err != nil {
+		t.Fatalf("ParseIntervals: %v", err)
+	}
+	if intervals["docker"] != 30*time.Second || intervals["homeassistant"] != time.Minute {
+		t.Errorf("intervals = %v", intervals)
+	}
+
+	for _, spec := range []string{"wan=0s", "wan=-1m", "wan", "wan=soon"} {
+		if _, err := ParseIntervals(spec); err == nil {
+			t.Errorf("ParseIntervals(%q) returned no error", spec)
+		}
+	}
+}
+
+// stuckSocket accepts connections on a unix socket and never answers, like
+// a HAProxy that hangs.
+func stuckSocket(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "haproxy.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				for _, conn := range conns {
+					conn.Close()
+				}
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	return path
+}
+
+func TestRunOnceEnforcesTimeout(t *testing.T) {
+	c := &Collector{
+		haproxy:      haproxy.NewClient(stuckSocket(t)),
+		snapshots:    pubsub.New[types.Snapshot](),
+		readings:     make(map[string]*types.SourceReading),
+		sourceStatus: make(map[string]*types.SourceStatus),
+	}
+	entry := registration{
+		source:   sourceFunc{name: "haproxy", collect: c.collectHAProxy},
+		interval: time.Second,
+		timeout:  100 * time.Millisecond,
+	}
+	c.trackSource(entry)
+
+	start := time.Now()
+	c.runOnce(context.Background(), entry)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("a stuck source ran for %s, want it cancelled after %s", elapsed, entry.timeout)
+	}
+
+	status := c.sourceStatus["haproxy"]
+	if !status.Failing || !strings.Contains(status.LastError, "timed out") {
+		t.Errorf("status = %+v, want a timeout error", status)
+	}
+	if connections := c.readings["haproxy"].Connections; len(connections) != 1 || connections[0].Connected {
+		t.Errorf("connections = %+v, want HAProxy disconnected", connections)
+	}
+}
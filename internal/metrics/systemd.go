@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hra42/iot-hub-statuspage/internal/systemd"
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+// collectSystemd reads the state and resource usage of the configured
+// units.
+func (c *Collector) collectSystemd(ctx context.Context) (*Result, error) {
+	// Connect lazily so the collector recovers once D-Bus becomes available
+	if c.systemd == nil {
+		client, err := systemd.NewClient(ctx)
+		if err != nil {
+			return &Result{Services: unknownUnits(c.systemdUnits, err)}, fmt.Errorf("failed to connect to systemd: %w", err)
+		}
+		c.systemd = client
+	}
+
+	units, err := c.systemd.GetUnits(ctx, c.systemdUnits)
+	if !c.systemd.Connected() {
+		// Reconnect on the next run
+		c.systemd.Close()
+		c.systemd = nil
+		err = fmt.Errorf("lost connection to systemd: %w", err)
+	}
+
+	result := &Result{}
+	for _, unit := range units {
+		result.Services = append(result.Services, unitServiceStatus(unit))
+		if unit.Error != "" {
+			continue
+		}
+		labels := map[string]string{"unit": unit.Name}
+		result.Metrics = append(result.Metrics, types.Metric{
+			Name:   "unit_restarts",
+			Value:  float64(unit.Restarts),
+			Labels: labels,
+		})
+		if unit.MemoryKnown {
+			result.Metrics = append(result.Metrics, types.Metric{
+				Name:   "unit_memory",
+				Value:  float64(unit.MemoryCurrent),
+				Unit:   types.UnitBytes,
+				Labels: labels,
+			})
+		}
+	}
+	return result, err
+}
+
+func unitServiceStatus(unit systemd.Unit) types.ServiceStatus {
+	status := types.ServiceStatus{
+		Name:        unit.Name,
+		Description: unit.Description,
+		Group:       "systemd",
+		Status:      fmt.Sprintf("%s (%s)", unit.ActiveState, unit.SubState),
+		Health:      unit.Health,
+		Unit: &types.UnitInfo{
+			ActiveState:   unit.ActiveState,
+			SubState:      unit.SubState,
+			Restarts:      unit.Restarts,
+			MemoryCurrent: unit.MemoryCurrent,
+		},
+	}
+
+	switch {
+	case unit.Error != "":
+		status.Status = "unknown"
+		status.Details = unit.Error
+	case unit.LoadState != "loaded":
+		status.Details = fmt.Sprintf("Unit %s", unit.LoadState)
+	case unit.Health == types.HealthOperational:
+		if !unit.ActiveSince.IsZero() {
+			status.Uptime = formatDuration(time.Since(unit.ActiveSince))
+		}
+	case unit.Restarts > 0:
+		status.Details = fmt.Sprintf("Restarted %d times", unit.Restarts)
+	}
+	return status
+}
+
+func unknownUnits(names []string, err error) []types.ServiceStatus {
+	statuses := make([]types.ServiceStatus, 0, len(names))
+	for _, name := range names {
+		statuses = append(statuses, types.ServiceStatus{
+			Name:    name,
+			Group:   "systemd",
+			Status:  "unknown",
+			Health:  types.HealthUnknown,
+			Details: err.Error(),
+		})
+	}
+	return statuses
+}
@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hra42/iot-hub-statuspage/internal/systemd"
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+// fakeSystemd is a D-Bus connection to systemd that fails on demand.
+type fakeSystemd struct {
+	units     map[string]map[string]interface{}
+	err       error
+	connected bool
+	closed    bool
+}
+
+func (f *fakeSystemd) GetUnitPropertiesContext(ctx context.Context, unit string) (map[string]interface{}, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.units[unit], nil
+}
+
+func (f *fakeSystemd) GetUnitTypePropertiesContext(ctx context.Context, unit string, unitType string) (map[string]interface{}, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return map[string]interface{}{"NRestarts": uint32(1), "MemoryCurrent": uint64(1 << 20)}, nil
+}
+
+func (f *fakeSystemd) Connected() bool { return f.connected }
+
+func (f *fakeSystemd) Close() { f.closed = true }
+
+// newSystemdCollector returns a collector of the given units, which reads
+// them over conn.
+func newSystemdCollector(conn systemd.Conn, units ...string) *Collector {
+	c := NewCollector(nil, nil, Config{SystemdUnits: units})
+	c.systemd = systemd.NewClientWithConn(conn)
+	return c
+}
+
+func serviceIDs(services []types.ServiceStatus) []string {
+	ids := make([]string, len(services))
+	for i, service := range services {
+		ids[i] = types.ServiceKey("systemd", service)
+	}
+	return ids
+}
+
+func TestCollectSystemd(t *testing.T) {
+	conn := &fakeSystemd{
+		connected: true,
+		units: map[string]map[string]interface{}{
+			"mosquitto.service": {"LoadState": "loaded", "ActiveState": "active", "SubState": "running"},
+		},
+	}
+	c := newSystemdCollector(conn, "mosquitto")
+
+	result, err := c.collectSystemd(context.Background())
+	if err != nil {
+		t.Fatalf("collectSystemd: %v", err)
+	}
+	if ids := serviceIDs(result.Services); len(ids) != 1 || ids[0] != "systemd_mosquitto.service" {
+		t.Errorf("service IDs = %q, want [systemd_mosquitto.service]", ids)
+	}
+	if result.Services[0].Health != types.HealthOperational {
+		t.Errorf("Health = %q, want operational", result.Services[0].Health)
+	}
+	if len(result.Metrics) != 2 || result.Metrics[0].Labels["unit"] != "mosquitto.service" {
+		t.Errorf("metrics = %+v, want restarts and memory of mosquitto.service", result.Metrics)
+	}
+}
+
+// Units keep their ID whether they were read, failed to be read or D-Bus
+// was unreachable, so their history is not split.
+func TestCollectSystemdKeepsServiceIDs(t *testing.T) {
+	conn := &fakeSystemd{connected: true, err: errors.New("access denied")}
+	c := newSystemdCollector(conn, "mosquitto", "backup.timer")
+
+	result, err := c.collectSystemd(context.Background())
+	if err == nil {
+		t.Fatal("collectSystemd returned no error when all units failed")
+	}
+	want := []string{"systemd_mosquitto.service", "systemd_backup.timer"}
+	for i, id := range serviceIDs(result.Services) {
+		if id != want[i] {
+			t.Errorf("service ID = %q, want %q", id, want[i])
+		}
+	}
+	for _, service := range result.Services {
+		if service.Health != types.HealthUnknown {
+			t.Errorf("Health of %s = %q, want unknown", service.Name, service.Health)
+		}
+	}
+
+	for i, id := range serviceIDs(unknownUnits(c.systemdUnits, errors.New("no bus"))) {
+		if id != want[i] {
+			t.Errorf("service ID without D-Bus = %q, want %q", id, want[i])
+		}
+	}
+}
+
+func TestCollectSystemdReconnects(t *testing.T) {
+	conn := &fakeSystemd{connected: false, err: errors.New("dbus: connection closed by user")}
+	c := newSystemdCollector(conn, "mosquitto")
+
+	if _, err := c.collectSystemd(context.Background()); err == nil {
+		t.Fatal("collectSystemd returned no error for a lost connection")
+	}
+	if !conn.closed {
+		t.Error("lost connection was not closed")
+	}
+	if c.systemd != nil {
+		t.Error("client was kept after the connection was lost")
+	}
+}
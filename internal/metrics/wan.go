@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+// WANConfig configures the internet connectivity check.
+type WANConfig struct {
+	// Target is pinged to measure latency, jitter and packet loss
+	Target string
+	// DNSName is resolved to measure the DNS response time
+	DNSName  string
+	Interval time.Duration
+}
+
+// wanPingCount is the number of echo requests sent per check.
+const wanPingCount = 5
+
+var pingTime = regexp.MustCompile(`time[=<]([0-9.]+) ?ms`)
+
+// collectWAN checks the WAN quality. Lost replies and failed lookups are
+// part of the measurement; only a ping that cannot be run is an error.
+func (c *Collector) collectWAN(ctx context.Context) (*Result, error) {
+	quality := types.WANQuality{
+		Target:    c.wan.Target,
+		DNSName:   c.wan.DNSName,
+		CheckedAt: time.Now(),
+	}
+
+	rtts, err := ping(ctx, c.wan.Target, wanPingCount)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{}
+	quality.LossPercent = float64(wanPingCount-len(rtts)) / wanPingCount * 100
+	quality.LatencyMs, quality.JitterMs = latencyAndJitter(rtts)
+	result.Metrics = append(result.Metrics, types.Metric{Name: "wan_loss", Value: quality.LossPercent, Unit: types.UnitPercent})
+	if len(rtts) > 0 {
+		result.Metrics = append(result.Metrics,
+			types.Metric{Name: "wan_latency", Value: quality.LatencyMs, Unit: types.UnitMilliseconds},
+			types.Metric{Name: "wan_jitter", Value: quality.JitterMs, Unit: types.UnitMilliseconds},
+		)
+	}
+
+	if c.wan.DNSName != "" {
+		dnsCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		start := time.Now()
+		_, err := net.DefaultResolver.LookupHost(dnsCtx, c.wan.DNSName)
+		cancel()
+		if err != nil {
+			quality.DNSError = err.Error()
+		} else {
+			quality.DNSMs = float64(time.Since(start).Microseconds()) / 1000
+			result.Metrics = append(result.Metrics, types.Metric{Name: "dns_resolve_time", Value: quality.DNSMs, Unit: types.UnitMilliseconds})
+		}
+	}
+
+	result.apply = func(current *types.SystemMetrics) {
+		current.WAN = &quality
+	}
+	return result, nil
+}
+
+// ping sends count echo requests and returns the round trip times in
+// milliseconds of the replies.
+func ping(ctx context.Context, host string, count int) ([]float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(count+5)*time.Second)
+	defer cancel()
+
+	// A non-zero exit status only means that replies were lost
+	output, err := exec.CommandContext(ctx, "ping", "-c", strconv.Itoa(count), "-W", "2", host).Output()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("failed to run ping: %w", err)
+	}
+
+	var rtts []float64
+	for _, match := range pingTime.FindAllStringSubmatch(string(output), -1) {
+		if rtt, err := strconv.ParseFloat(match[1], 64); err == nil {
+			rtts = append(rtts, rtt)
+		}
+	}
+	return rtts, nil
+}
+
+// latencyAndJitter returns the mean round trip time and the mean
+// difference between consecutive round trip times.
+func latencyAndJitter(rtts []float64) (float64, float64) {
+	if len(rtts) == 0 {
+		return 0, 0
+	}
+	var sum, diffs float64
+	for i, rtt := range rtts {
+		sum += rtt
+		if i > 0 {
+			diffs += math.Abs(rtt - rtts[i-1])
+		}
+	}
+	jitter := 0.0
+	if len(rtts) > 1 {
+		jitter = diffs / float64(len(rtts)-1)
+	}
+	return sum / float64(len(rtts)), jitter
+}
@@ -0,0 +1,299 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+type Config struct {
+	// Broker is the broker URL, e.g. tcp://192.168.2.136:1883
+	Broker   string
+	Username string
+	Password string
+	ClientID string
+	// Devices lists the topics devices publish heartbeats on
+	Devices []DeviceTopic
+	// StatusTopic, when set, is kept at a retained "online" while connected
+	// and set to "offline" by the broker through the will when we disappear
+	StatusTopic string
+}
+
+// DeviceTopic is a topic filter whose single level wildcards identify the
+// device, e.g. tele/+/LWT. A device is offline when it published an
+// "offline" payload, or when Timeout is set and nothing arrived within it.
+type DeviceTopic struct {
+	Filter  string
+	Timeout time.Duration
+}
+
+type BrokerStats struct {
+	Connected                 bool
+	Version                   string
+	ClientsConnected          int
+	MessagesReceivedPerSecond float64
+	MessagesSentPerSecond     float64
+	RetainedMessages          int
+}
+
+// Monitor subscribes to the broker's $SYS statistics and to the configured
+// device topics.
+type Monitor struct {
+	cfg      Config
+	client   paho.Client
+	mu       sync.RWMutex
+	stats    BrokerStats
+	devices  map[string]map[string]*heartbeat
+	onChange func()
+	// onConnect is called after every (re)connect, e.g. to resubscribe
+	onConnect []func(paho.Client)
+}
+
+// heartbeat is the liveness of a device on one of its topics.
+type heartbeat struct {
+	lastSeen time.Time
+	offline  bool
+	timeout  time.Duration
+}
+
+// ParseDeviceTopics parses a comma separated list of topic filters with
+// optional timeouts, e.g. "tele/+/LWT,tele/+/STATE=10m".
+func ParseDeviceTopics(spec string) ([]DeviceTopic, error) {
+	var topics []DeviceTopic
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		filter, timeout, found := strings.Cut(part, "=")
+		topic := DeviceTopic{Filter: filter}
+		if found {
+			d, err := time.ParseDuration(timeout)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timeout of topic %q: %w", filter, err)
+			}
+			topic.Timeout = d
+		}
+		if strings.Contains(filter, "#") {
+			return nil, fmt.Errorf("topic %q: only + wildcards can identify devices", filter)
+		}
+		topics = append(topics, topic)
+	}
+	return topics, nil
+}
+
+// NewMonitor creates a monitor. onChange is called when a device reports
+// itself online or offline.
+func NewMonitor(cfg Config, onChange func()) *Monitor {
+	m := &Monitor{
+		cfg:      cfg,
+		devices:  make(map[string]map[string]*heartbeat),
+		onChange: onChange,
+	}
+
+	clientID := cfg.ClientID
+	if clientID == "" {
+		hostname, _ := os.Hostname()
+		clientID = "statuspage-" + hostname
+	}
+
+	opts := paho.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(clientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(10 * time.Second).
+		SetOnConnectHandler(m.handleConnect).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Printf("MQTT connection lost: %v", err)
+			m.setConnected(false)
+		})
+	m.client = paho.NewClient(opts)
+	return m
+}
+
+// Start connects to the broker. Connecting is retried in the background.
+func (m *Monitor) Start() {
+	m.client.Connect()
+}
+
+func (m *Monitor) Stop() {
+	m.client.Disconnect(250)
+}
+
+// Client returns the underlying connection, e.g. to publish on it.
+func (m *Monitor) Client() paho.Client {
+	return m.client
+}
+
+// handleConnect (re)subscribes after every connect, as the session is not
+// persisted.
+func (m *Monitor) handleConnect(client paho.Client) {
+	log.Printf("Connected to MQTT broker %s", m.cfg.Broker)
+	m.setConnected(true)
+
+	if m.cfg.StatusTopic != "" {
+		client.Publish(m.cfg.StatusTopic, 1, true, "online")
+	}
+
+	client.Subscribe("$SYS/#", 0, m.handleSys)
+	for _, topic := range m.cfg.Devices {
+		client.Subscribe(topic.Filter, 0, func(_ paho.Client, msg paho.Message) {
+			m.handleHeartbeat(topic, msg)
+		})
+	}
+
+	for _, fn := range m.onConnect {
+		fn(client)
+	}
+}
+
+// handleSys reads the statistics published by Mosquitto and compatible
+// brokers.
+func (m *Monitor) handleSys(_ paho.Client, msg paho.Message) {
+	payload := strings.TrimSpace(string(msg.Payload()))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch msg.Topic() {
+	case "$SYS/broker/version":
+		m.stats.Version = payload
+	case "$SYS/broker/clients/connected":
+		m.stats.ClientsConnected, _ = strconv.Atoi(payload)
+	case "$SYS/broker/retained messages/count":
+		m.stats.RetainedMessages, _ = strconv.Atoi(payload)
+	case "$SYS/broker/load/messages/received/1min":
+		// Published as messages per minute, averaged over one minute
+		perMinute, _ := strconv.ParseFloat(payload, 64)
+		m.stats.MessagesReceivedPerSecond = perMinute / 60
+	case "$SYS/broker/load/messages/sent/1min":
+		perMinute, _ := strconv.ParseFloat(payload, 64)
+		m.stats.MessagesSentPerSecond = perMinute / 60
+	}
+}
+
+func (m *Monitor) handleHeartbeat(topic DeviceTopic, msg paho.Message) {
+	name, ok := deviceName(topic.Filter, msg.Topic())
+	if !ok {
+		return
+	}
+	offline := isOfflinePayload(msg.Payload())
+
+	m.mu.Lock()
+	beats, ok := m.devices[name]
+	if !ok {
+		beats = make(map[string]*heartbeat)
+		m.devices[name] = beats
+	}
+	beat, ok := beats[topic.Filter]
+	if !ok {
+		beat = &heartbeat{timeout: topic.Timeout}
+		beats[topic.Filter] = beat
+	}
+	changed := beat.offline != offline || beat.lastSeen.IsZero()
+	beat.offline = offline
+	beat.lastSeen = time.Now()
+	m.mu.Unlock()
+
+	if changed && m.onChange != nil {
+		m.onChange()
+	}
+}
+
+func (m *Monitor) setConnected(connected bool) {
+	m.mu.Lock()
+	m.stats.Connected = connected
+	m.mu.Unlock()
+}
+
+func (m *Monitor) Stats() BrokerStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.stats
+}
+
+// Devices returns the liveness of all devices seen so far, sorted by name.
+func (m *Monitor) Devices() []types.DeviceStatus {
+	now := time.Now()
+
+	m.mu.RLock()
+	devices := make([]types.DeviceStatus, 0, len(m.devices))
+	for name, beats := range m.devices {
+		device := types.DeviceStatus{Name: name, Online: true}
+		for filter, beat := range beats {
+			if beat.lastSeen.After(device.LastSeen) {
+				device.LastSeen = beat.lastSeen
+			}
+			switch {
+			case beat.offline:
+				device.Online = false
+				device.Details = fmt.Sprintf("Reported offline on %s", filter)
+			case beat.timeout > 0 && now.Sub(beat.lastSeen) > beat.timeout:
+				device.Online = false
+				device.Details = fmt.Sprintf("No message on %s for %s", filter, now.Sub(beat.lastSeen).Round(time.Second))
+			}
+		}
+		devices = append(devices, device)
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].Name < devices[j].Name
+	})
+	return devices
+}
+
+// deviceName extracts the levels matched by + wildcards in filter.
+func deviceName(filter, topic string) (string, bool) {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	if len(filterLevels) != len(topicLevels) {
+		return "", false
+	}
+
+	var captured []string
+	for i, level := range filterLevels {
+		switch level {
+		case "+":
+			captured = append(captured, topicLevels[i])
+		case topicLevels[i]:
+		default:
+			return "", false
+		}
+	}
+	if len(captured) == 0 {
+		return topic, true
+	}
+	return strings.Join(captured, "/"), true
+}
+
+// isOfflinePayload recognises Tasmota LWT ("Offline") and zigbee2mqtt
+// availability ("offline" or {"state":"offline"}) payloads.
+func isOfflinePayload(payload []byte) bool {
+	text := strings.TrimSpace(string(payload))
+	if strings.EqualFold(text, "offline") {
+		return true
+	}
+
+	var availability struct {
+		State string `json:"state"`
+	}
+	if err := json.Unmarshal(payload, &availability); err == nil {
+		return strings.EqualFold(availability.State, "offline")
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package mqtt
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+// message is a received message for calling the handlers directly.
+type message struct {
+	topic   string
+	payload string
+}
+
+func (m message) Duplicate() bool   { return false }
+func (m message) Qos() byte         { return 0 }
+func (m message) Retained() bool    { return false }
+func (m message) Topic() string     { return m.topic }
+func (m message) MessageID() uint16 { return 0 }
+func (m message) Payload() []byte   { return []byte(m.payload) }
+func (m message) Ack()              {}
+
+// waitFor polls done until it holds and fails the test after 5 seconds.
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMonitorReadsBrokerStats(t *testing.T) {
+	_, client := startBroker(t)
+	m := NewMonitor(Config{Broker: "tcp://127.0.0.1:1"}, nil)
+	m.handleConnect(client)
+
+	// The embedded broker publishes its version and client count itself
+	waitFor(t, "$SYS statistics", func() bool {
+		stats := m.Stats()
+		return stats.Connected && stats.Version != "" && stats.ClientsConnected >= 1
+	})
+
+	// Message rates are published per minute, like Mosquitto does
+	m.handleSys(nil, message{"$SYS/broker/retained messages/count", "42"})
+	m.handleSys(nil, message{"$SYS/broker/load/messages/received/1min", " 120.0\n"})
+	m.handleSys(nil, message{"$SYS/broker/load/messages/sent/1min", "30"})
+
+	stats := m.Stats()
+	if stats.RetainedMessages != 42 {
+		t.Errorf("retained messages = %d, want 42", stats.RetainedMessages)
+	}
+	if stats.MessagesReceivedPerSecond != 2 || stats.MessagesSentPerSecond != 0.5 {
+		t.Errorf("rates = %v received, %v sent per second, want 2 and 0.5",
+			stats.MessagesReceivedPerSecond, stats.MessagesSentPerSecond)
+	}
+}
+
+func TestMonitorDeviceLiveness(t *testing.T) {
+	_, client := startBroker(t)
+	changes := make(chan struct{}, 10)
+	m := NewMonitor(Config{
+		Broker: "tcp://127.0.0.1:1",
+		Devices: []DeviceTopic{
+			{Filter: "tele/+/LWT"},
+			{Filter: "tele/+/STATE", Timeout: 500 * time.Millisecond},
+		},
+	}, func() { changes <- struct{}{} })
+	m.handleConnect(client)
+
+	device := func() types.DeviceStatus {
+		devices := m.Devices()
+		if len(devices) != 1 {
+			return types.DeviceStatus{}
+		}
+		return devices[0]
+	}
+	publish := func(topic, payload string) {
+		t.Helper()
+		if token := client.Publish(topic, 0, false, payload); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+			t.Fatalf("failed to publish on %s: %v", topic, token.Error())
+		}
+	}
+
+	// Online as soon as it reports on both topics
+	publish("tele/plug/LWT", "Online")
+	publish("tele/plug/STATE", `{"POWER":"ON"}`)
+	waitFor(t, "the device to be seen on both topics", func() bool {
+		return len(changes) == 2
+	})
+	if d := device(); d.Name != "plug" || !d.Online {
+		t.Fatalf("device = %+v, want plug online", d)
+	}
+
+	// Stale once the heartbeat topic is quiet for longer than its timeout
+	waitFor(t, "the heartbeat to time out", func() bool {
+		return !device().Online
+	})
+	if d := device(); !strings.HasPrefix(d.Details, "No message on tele/+/STATE") {
+		t.Errorf("details = %q", d.Details)
+	}
+
+	// A heartbeat brings it back, the last will takes it offline again
+	publish("tele/plug/STATE", `{"POWER":"ON"}`)
+	waitFor(t, "the device to be back online", func() bool {
+		return device().Online
+	})
+	publish("tele/plug/LWT", "Offline")
+	waitFor(t, "the last will", func() bool {
+		return !device().Online
+	})
+	if d := device(); d.Details != "Reported offline on tele/+/LWT" {
+		t.Errorf("details = %q", d.Details)
+	}
+	if len(changes) != 3 {
+		t.Errorf("got %d changes, want 3: heartbeats on a known topic are no change", len(changes))
+	}
+}
+
+func TestDeviceName(t *testing.T) {
+	tests := []struct {
+		filter, topic string
+		want          string
+		ok            bool
+	}{
+		{"tele/+/LWT", "tele/plug/LWT", "plug", true},
+		{"zigbee2mqtt/+/availability", "zigbee2mqtt/kitchen light/availability", "kitchen light", true},
+		{"sites/+/+/status", "sites/home/door/status", "home/door", true},
+		{"shellies/announce", "shellies/announce", "shellies/announce", true},
+		{"tele/+/LWT", "tele/plug/STATE", "", false},
+		{"tele/+/LWT", "tele/plug/extra/LWT", "", false},
+		{"tele/+/LWT", "stat/plug/LWT", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := deviceName(tt.filter, tt.topic)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("deviceName(%q, %q) = %q, %v, want %q, %v", tt.filter, tt.topic, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestIsOfflinePayload(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    bool
+	}{
+		{"Offline", true},
+		{" offline\n", true},
+		{`{"state":"offline"}`, true},
+		{`{"state": "OFFLINE"}`, true},
+		{"Online", false},
+		{`{"state":"online"}`, false},
+		{`{"POWER":"OFF"}`, false},
+		{"", false},
+		{"0", false},
+	}
+	for _, tt := range tests {
+		if got := isOfflinePayload([]byte(tt.payload)); got != tt.want {
+			t.Errorf("isOfflinePayload(%q) = %v, want %v", tt.payload, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+// entityRemoveAfter is how long an entity may go unreported before its
+// retained topics are cleared, so a source that fails for a few runs does
+// not make its entities vanish and come back in Home Assistant.
+const entityRemoveAfter = 10 * time.Minute
+
+type PublisherConfig struct {
+	// TopicPrefix is the root of the state topics, e.g. statuspage
+	TopicPrefix string
+	// DiscoveryPrefix is the Home Assistant discovery prefix. Discovery is
+	// disabled when empty.
+	DiscoveryPrefix string
+}
+
+// StatusTopic is the availability topic of all published entities.
+func (c PublisherConfig) StatusTopic() string {
+	return c.TopicPrefix + "/status"
+}
+
+// Publisher publishes the collected state as retained MQTT topics, together
+// with Home Assistant discovery configs for them. It usually shares the
+// connection of a Monitor.
+type Publisher struct {
+	cfg    PublisherConfig
+	client paho.Client
+	mu     sync.Mutex
+	// last holds the last payload per topic, so only changes are sent. It
+	// is emptied on reconnect to send everything again.
+	last map[string]string
+	// retained holds the topics with a retained message of ours and when
+	// they were last published. Topics not published for entityRemoveAfter
+	// belong to entities that are gone and are cleared.
+	retained map[string]time.Time
+	// published is the time of the current Publish
+	published time.Time
+}
+
+// entity is a Home Assistant entity backed by a state topic.
+type entity struct {
+	component   string // binary_sensor or sensor
+	objectID    string
+	name        string
+	deviceClass string
+	unit        string
+	icon        string
+	// valueTemplate maps the raw state onto ON/OFF for binary sensors
+	valueTemplate string
+}
+
+type sensorState struct {
+	entity
+	value string
+}
+
+type hostState struct {
+	id        string
+	name      string
+	connected bool
+}
+
+// NewPublisher creates a publisher on the connection of monitor. The
+// monitor's status topic should be cfg.StatusTopic() so the entities become
+// unavailable when the status page goes away.
+func NewPublisher(monitor *Monitor, cfg PublisherConfig) *Publisher {
+	p := newPublisher(monitor.Client(), cfg)
+	monitor.onConnect = append(monitor.onConnect, p.handleConnect)
+	return p
+}
+
+// newPublisher creates a publisher on client. handleConnect must be called
+// on every connect of the client.
+func newPublisher(client paho.Client, cfg PublisherConfig) *Publisher {
+	return &Publisher{
+		cfg:      cfg,
+		client:   client,
+		last:     make(map[string]string),
+		retained: make(map[string]time.Time),
+	}
+}
+
+// handleConnect republishes everything after a reconnect, as a broker
+// without persistence has lost the retained messages. Home Assistant
+// announces restarts on <discovery prefix>/status, upon which the discovery
+// configs are sent again.
+func (p *Publisher) handleConnect(client paho.Client) {
+	p.reset()
+	if p.cfg.DiscoveryPrefix == "" {
+		return
+	}
+	client.Subscribe(p.cfg.DiscoveryPrefix+"/status", 0, func(_ paho.Client, msg paho.Message) {
+		if string(msg.Payload()) == "online" {
+			p.reset()
+		}
+	})
+}
+
+func (p *Publisher) reset() {
+	p.mu.Lock()
+	p.last = make(map[string]string)
+	p.mu.Unlock()
+}
+
+// Publish sends the services, connections and titled metrics reported by
+// the sources, plus the Pi telemetry of the snapshot. Entities that were
+// not reported for entityRemoveAfter are removed. Nothing is sent while
+// disconnected; the next collection after reconnecting publishes the full
+// state. Publish must not be called concurrently.
+func (p *Publisher) Publish(metrics types.SystemMetrics, readings []types.SourceReading) {
+	p.publishAt(metrics, readings, time.Now())
+}
+
+func (p *Publisher) publishAt(metrics types.SystemMetrics, readings []types.SourceReading, now time.Time) {
+	if !p.client.IsConnectionOpen() {
+		return
+	}
+
+	p.mu.Lock()
+	p.published = now
+	p.mu.Unlock()
+	defer p.clearUnseen(now)
+
+	var sensors []sensorState
+	for _, reading := range readings {
+		for _, service := range reading.Services {
+			id := objectID(types.ServiceKey(reading.Source, service))
+			name := service.Title()
+			if service.Host != "" {
+				name = fmt.Sprintf("%s (%s)", name, service.Host)
+			}
+			e := entity{
+				component:     "binary_sensor",
+				objectID:      "service_" + id,
+				name:          name,
+				deviceClass:   "problem",
+				valueTemplate: fmt.Sprintf("{{ 'OFF' if value == '%s' else 'ON' }}", types.HealthOperational),
+			}
+			p.publishEntity(e, "service/"+id, string(service.Health))
+
+			attributes, _ := json.Marshal(map[string]string{"health": string(service.Health), "details": service.Details})
+			p.publish(p.topic("service/"+id+"/attributes"), string(attributes))
+		}
+
+		for _, host := range reading.Connections {
+			id := objectID(strings.ToLower(host.Name))
+			state := "OFF"
+			if host.Connected {
+				state = "ON"
+			}
+			e := entity{
+				component:   "binary_sensor",
+				objectID:    "host_" + id,
+				name:        host.Name,
+				deviceClass: "connectivity",
+			}
+			p.publishEntity(e, "host/"+id, state)
+		}
+
+		for _, metric := range reading.Metrics {
+			if metric.Title == "" {
+				continue
+			}
+			unit, deviceClass, format := unitOf(metric.Unit)
+			sensors = append(sensors, sensorState{
+				entity{objectID: objectID(metric.Key()), name: metric.Title, unit: unit, deviceClass: deviceClass},
+				fmt.Sprintf(format, metric.Value),
+			})
+		}
+	}
+
+	if pi := metrics.Pi; pi != nil {
+		sensors = append(sensors,
+			sensorState{entity{objectID: "soc_temperature", name: "SoC Temperature", unit: "°C", deviceClass: "temperature"}, fmt.Sprintf("%.1f", pi.MaxTemperature())},
+			sensorState{entity{objectID: "cpu_frequency", name: "CPU Frequency", unit: "MHz", deviceClass: "frequency"}, fmt.Sprintf("%.0f", pi.CPUFrequencyMHz)},
+		)
+		if throttled := pi.Throttled; throttled != nil {
+			state := "OFF"
+			if throttled.UnderVoltage || throttled.Throttled || throttled.FrequencyCapped {
+				state = "ON"
+			}
+			e := entity{component: "binary_sensor", objectID: "throttled", name: "Throttled", deviceClass: "problem"}
+			p.publishEntity(e, "system/throttled", state)
+		}
+	}
+	for _, sensor := range sensors {
+		sensor.component = "sensor"
+		p.publishEntity(sensor.entity, "system/"+sensor.objectID, sensor.value)
+	}
+}
+
+// publishEntity publishes the state of e below path and, when discovery is
+// enabled, its discovery config.
+func (p *Publisher) publishEntity(e entity, path, state string) {
+	stateTopic := p.topic(path + "/state")
+	if p.cfg.DiscoveryPrefix != "" {
+		config := map[string]interface{}{
+			"name":               e.name,
+			"unique_id":          "statuspage_" + e.objectID,
+			"object_id":          "statuspage_" + e.objectID,
+			"state_topic":        stateTopic,
+			"availability_topic": p.cfg.StatusTopic(),
+			"device": map[string]interface{}{
+				"identifiers":  []string{"statuspage"},
+				"name":         "Smart Home Status",
+				"manufacturer": "hra42",
+				"model":        "iot-hub-statuspage",
+			},
+		}
+		if e.deviceClass != "" {
+			config["device_class"] = e.deviceClass
+		}
+		if e.unit != "" {
+			config["unit_of_measurement"] = e.unit
+			config["state_class"] = "measurement"
+		}
+		if e.icon != "" {
+			config["icon"] = e.icon
+		}
+		if e.valueTemplate != "" {
+			config["value_template"] = e.valueTemplate
+		}
+		if strings.HasPrefix(path, "service/") {
+			config["json_attributes_topic"] = p.topic(path + "/attributes")
+		}
+
+		payload, err := json.Marshal(config)
+		if err != nil {
+			log.Printf("Failed to encode discovery config of %s: %v", e.objectID, err)
+		} else {
+			p.publish(fmt.Sprintf("%s/%s/statuspage/%s/config", p.cfg.DiscoveryPrefix, e.component, e.objectID), string(payload))
+		}
+	}
+	p.publish(stateTopic, state)
+}
+
+// publish sends a retained message unless the topic already holds payload.
+func (p *Publisher) publish(topic, payload string) {
+	p.mu.Lock()
+	p.retained[topic] = p.published
+	if last, ok := p.last[topic]; ok && last == payload {
+		p.mu.Unlock()
+		return
+	}
+	p.last[topic] = payload
+	p.mu.Unlock()
+
+	p.client.Publish(topic, 1, true, payload)
+}
+
+// clearUnseen clears the retained topics that were not published for
+// entityRemoveAfter. An empty retained message deletes the retained one, and
+// an empty discovery config removes the entity from Home Assistant.
+func (p *Publisher) clearUnseen(now time.Time) {
+	p.mu.Lock()
+	var gone []string
+	for topic, published := range p.retained {
+		if now.Sub(published) >= entityRemoveAfter {
+			gone = append(gone, topic)
+			delete(p.retained, topic)
+			delete(p.last, topic)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, topic := range gone {
+		p.client.Publish(topic, 1, true, "")
+	}
+}
+
+func (p *Publisher) topic(path string) string {
+	return p.cfg.TopicPrefix + "/" + path
+}
+
+// unitOf maps a metric unit onto the Home Assistant unit and device class,
+// and returns the format of the state.
+func unitOf(unit types.Unit) (string, string, string) {
+	switch unit {
+	case types.UnitPercent:
+		return "%", "", "%.1f"
+	case types.UnitBytes:
+		return "B", "data_size", "%.0f"
+	case types.UnitBytesPerSecond:
+		return "B/s", "data_rate", "%.0f"
+	case types.UnitPerSecond:
+		return "/s", "", "%.2f"
+	case types.UnitSeconds:
+		return "s", "duration", "%.0f"
+	case types.UnitMilliseconds:
+		return "ms", "duration", "%.1f"
+	}
+	return "", "", "%g"
+}
+
+// objectID makes name usable as a topic level and Home Assistant object ID.
+func objectID(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,168 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	server "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+// startBroker runs an embedded broker for the test and returns it with a
+// client connected to it.
+func startBroker(t *testing.T) (*server.Server, paho.Client) {
+	t.Helper()
+
+	broker := server.New(&server.Options{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	if err := broker.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	tcp := listeners.NewTCP(listeners.Config{ID: "tcp", Address: "127.0.0.1:0"})
+	if err := broker.AddListener(tcp); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { broker.Close() })
+
+	client := paho.NewClient(paho.NewClientOptions().AddBroker("tcp://" + tcp.Address()).SetClientID("statuspage-test"))
+	if token := client.Connect(); !token.WaitTimeout(5*time.Second) || token.Error() != nil {
+		t.Fatalf("failed to connect to the broker: %v", token.Error())
+	}
+	t.Cleanup(func() { client.Disconnect(0) })
+	return broker, client
+}
+
+// retained waits until the retained messages of the broker, without its own
+// $SYS topics, satisfy done and returns them by topic.
+func retained(t *testing.T, broker *server.Server, done func(map[string]string) bool) map[string]string {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		messages := make(map[string]string)
+		for topic, pk := range broker.Topics.Retained.GetAll() {
+			if !strings.HasPrefix(topic, "$SYS/") {
+				messages[topic] = string(pk.Payload)
+			}
+		}
+		if done(messages) {
+			return messages
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("retained messages did not settle: %v", messages)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testReadings(services ...string) []types.SourceReading {
+	reading := types.SourceReading{
+		Source:      "docker",
+		Connections: []types.HostConnection{{Name: "Docker", Connected: true}},
+		Metrics:     []types.Metric{{Name: "cpu", Value: 12.5, Unit: types.UnitPercent, Title: "CPU Usage"}},
+	}
+	for _, name := range services {
+		reading.Services = append(reading.Services, types.ServiceStatus{Name: name, Health: types.HealthOperational})
+	}
+	return []types.SourceReading{reading}
+}
+
+func TestPublisherPublishesStateAndDiscovery(t *testing.T) {
+	broker, client := startBroker(t)
+	p := newPublisher(client, PublisherConfig{TopicPrefix: "statuspage", DiscoveryPrefix: "homeassistant"})
+
+	p.Publish(types.SystemMetrics{}, testReadings("mosquitto"))
+
+	configTopic := "homeassistant/binary_sensor/statuspage/service_docker_mosquitto/config"
+	messages := retained(t, broker, func(m map[string]string) bool {
+		_, ok := m[configTopic]
+		return ok && len(m) == 7
+	})
+
+	want := map[string]string{
+		"statuspage/service/docker_mosquitto/state": "operational",
+		"statuspage/host/docker/state":              "ON",
+		"statuspage/system/cpu/state":               "12.5",
+	}
+	for topic, payload := range want {
+		if messages[topic] != payload {
+			t.Errorf("%s = %q, want %q", topic, messages[topic], payload)
+		}
+	}
+
+	var config map[string]interface{}
+	if err := json.Unmarshal([]byte(messages[configTopic]), &config); err != nil {
+		t.Fatalf("invalid discovery config: %v", err)
+	}
+	if config["name"] != "mosquitto" ||
+		config["unique_id"] != "statuspage_service_docker_mosquitto" ||
+		config["state_topic"] != "statuspage/service/docker_mosquitto/state" ||
+		config["availability_topic"] != "statuspage/status" ||
+		config["json_attributes_topic"] != "statuspage/service/docker_mosquitto/attributes" {
+		t.Errorf("unexpected discovery config %v", config)
+	}
+}
+
+func TestPublisherClearsRemovedEntities(t *testing.T) {
+	broker, client := startBroker(t)
+	p := newPublisher(client, PublisherConfig{TopicPrefix: "statuspage", DiscoveryPrefix: "homeassistant"})
+
+	start := time.Now()
+	p.publishAt(types.SystemMetrics{}, testReadings("mosquitto", "zigbee2mqtt"), start)
+	retained(t, broker, func(m map[string]string) bool {
+		_, ok := m["statuspage/service/docker_zigbee2mqtt/state"]
+		return ok
+	})
+
+	// A service missing from a few runs, e.g. while its source fails, is kept
+	readings := testReadings("mosquitto")
+	readings[0].Metrics[0].Value = 20
+	p.publishAt(types.SystemMetrics{}, readings, start.Add(time.Minute))
+	messages := retained(t, broker, func(m map[string]string) bool {
+		return m["statuspage/system/cpu/state"] == "20.0"
+	})
+	if messages["statuspage/service/docker_zigbee2mqtt/state"] != "operational" {
+		t.Errorf("service missing for a minute was cleared: %v", messages)
+	}
+
+	p.publishAt(types.SystemMetrics{}, readings, start.Add(entityRemoveAfter))
+	gone := []string{
+		"statuspage/service/docker_zigbee2mqtt/state",
+		"statuspage/service/docker_zigbee2mqtt/attributes",
+		"homeassistant/binary_sensor/statuspage/service_docker_zigbee2mqtt/config",
+	}
+	messages = retained(t, broker, func(m map[string]string) bool {
+		for _, topic := range gone {
+			if _, ok := m[topic]; ok {
+				return false
+			}
+		}
+		return true
+	})
+	if messages["statuspage/service/docker_mosquitto/state"] != "operational" {
+		t.Errorf("remaining service was cleared too: %v", messages)
+	}
+}
+
+func TestPublisherWithoutDiscovery(t *testing.T) {
+	broker, client := startBroker(t)
+	p := newPublisher(client, PublisherConfig{TopicPrefix: "statuspage"})
+
+	p.Publish(types.SystemMetrics{}, testReadings("mosquitto"))
+	messages := retained(t, broker, func(m map[string]string) bool { return len(m) == 4 })
+	for topic := range messages {
+		if !strings.HasPrefix(topic, "statuspage/") {
+			t.Errorf("unexpected topic %s without discovery", topic)
+		}
+	}
+}
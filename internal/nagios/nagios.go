@@ -0,0 +1,291 @@
+package nagios
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// State is the result of a check, given by the exit code of the plugin.
+type State int
+
+const (
+	OK       State = 0
+	Warning  State = 1
+	Critical State = 2
+	Unknown  State = 3
+)
+
+func (s State) String() string {
+	switch s {
+	case OK:
+		return "ok"
+	case Warning:
+		return "warning"
+	case Critical:
+		return "critical"
+	}
+	return "unknown"
+}
+
+// maxOutput caps the plugin output that is kept, like Nagios does.
+const maxOutput = 8 * 1024
+
+// Check is a plugin command run on an interval.
+type Check struct {
+	Name     string
+	Command  []string
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// Result is the outcome of one run of a plugin.
+type Result struct {
+	State State
+	// Output is the first line of text, shown as status
+	Output string
+	// LongOutput holds the following lines of text
+	LongOutput string
+	Perfdata   []Perfdata
+	Duration   time.Duration
+}
+
+// Perfdata is a single performance value, 'label'=value[UOM];[warn];[crit];[min];[max].
+// Thresholds are only set when they are a plain number rather than a range.
+type Perfdata struct {
+	Label    string
+	Value    float64
+	UOM      string
+	Warning  *float64
+	Critical *float64
+	Min      *float64
+	Max      *float64
+}
+
+// Run runs a plugin and maps its exit code onto the state. Plugins that
+// cannot be started, exit with an unexpected code or exceed the deadline of
+// ctx are unknown.
+func Run(ctx context.Context, command []string) Result {
+	if len(command) == 0 {
+		return Result{State: Unknown, Output: "no command configured"}
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Children that keep the pipes open must not block past the deadline
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err := cmd.Run()
+	duration := time.Since(start)
+
+	output := stdout.String()
+	if strings.TrimSpace(output) == "" {
+		output = stderr.String()
+	}
+	if len(output) > maxOutput {
+		output = output[:maxOutput]
+	}
+	result := Result{State: OK, Duration: duration}
+	result.Output, result.LongOutput, result.Perfdata = ParseOutput(output)
+
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() != nil:
+		result.State = Unknown
+		result.Output = fmt.Sprintf("timed out after %s", duration.Round(time.Millisecond))
+	case errors.As(err, &exitErr):
+		result.State = State(exitErr.ExitCode())
+		if result.State < OK || result.State > Unknown {
+			result.State = Unknown
+		}
+	case err != nil:
+		result.State = Unknown
+		result.Output = err.Error()
+	}
+	if result.Output == "" {
+		result.Output = fmt.Sprintf("no output, exit status %d", int(result.State))
+	}
+	return result
+}
+
+// ParseOutput splits plugin output into the status line, the long output
+// and the performance data, which may follow a | on the first line and on
+// any line of the long output.
+func ParseOutput(output string) (string, string, []Perfdata) {
+	lines := strings.Split(strings.TrimRight(output, "\n"), "\n")
+
+	text, perf, _ := strings.Cut(lines[0], "|")
+	perfdata := ParsePerfdata(perf)
+
+	var long []string
+	inPerfdata := false
+	for _, line := range lines[1:] {
+		if !inPerfdata {
+			before, after, found := strings.Cut(line, "|")
+			if found {
+				inPerfdata = true
+				line = strings.TrimRight(before, " ")
+				perfdata = append(perfdata, ParsePerfdata(after)...)
+			}
+			if strings.TrimSpace(line) != "" {
+				long = append(long, line)
+			}
+			continue
+		}
+		perfdata = append(perfdata, ParsePerfdata(line)...)
+	}
+	return strings.TrimSpace(text), strings.Join(long, "\n"), perfdata
+}
+
+// ParsePerfdata parses space separated performance values. Labels may be
+// quoted with single quotes to contain spaces. Malformed values and values
+// of U (unknown) are skipped.
+func ParsePerfdata(s string) []Perfdata {
+	var perfdata []Perfdata
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		var label string
+		if s[0] == '\'' {
+			// '' is an escaped quote inside a quoted label
+			end := 1
+			for end < len(s) {
+				if s[end] == '\'' {
+					if end+1 < len(s) && s[end+1] == '\'' {
+						end += 2
+						continue
+					}
+					break
+				}
+				end++
+			}
+			label = strings.ReplaceAll(s[1:min(end, len(s))], "''", "'")
+			s = s[min(end+1, len(s)):]
+		} else {
+			i := strings.IndexAny(s, "= ")
+			if i < 0 {
+				break
+			}
+			label, s = s[:i], s[i:]
+		}
+
+		if !strings.HasPrefix(s, "=") {
+			// Skip the malformed token
+			if i := strings.IndexByte(s, ' '); i >= 0 {
+				s = s[i:]
+				continue
+			}
+			break
+		}
+		value := s[1:]
+		if i := strings.IndexByte(value, ' '); i >= 0 {
+			value, s = value[:i], value[i:]
+		} else {
+			s = ""
+		}
+
+		if p, ok := parseValue(label, value); ok {
+			perfdata = append(perfdata, p)
+		}
+	}
+	return perfdata
+}
+
+func parseValue(label, value string) (Perfdata, bool) {
+	fields := strings.Split(value, ";")
+	number := strings.TrimRightFunc(fields[0], func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	v, err := strconv.ParseFloat(number, 64)
+	if label == "" || err != nil {
+		return Perfdata{}, false
+	}
+
+	p := Perfdata{Label: label, Value: v, UOM: fields[0][len(number):]}
+	for i, target := range []**float64{&p.Warning, &p.Critical, &p.Min, &p.Max} {
+		if i+1 < len(fields) {
+			if f, err := strconv.ParseFloat(fields[i+1], 64); err == nil {
+				*target = &f
+			}
+		}
+	}
+	return p, true
+}
+
+// checkFile is a check as configured in JSON.
+type checkFile struct {
+	Name     string   `json:"name"`
+	Command  []string `json:"command"`
+	Interval string   `json:"interval"`
+	Timeout  string   `json:"timeout"`
+}
+
+// LoadChecks reads checks from a JSON file:
+//
+//	[{"name": "disk", "command": ["check_disk", "-w", "20%"], "interval": "5m", "timeout": "30s"}]
+//
+// Interval and timeout are optional and default to the given values.
+func LoadChecks(path string, interval, timeout time.Duration) ([]Check, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []checkFile
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	checks := make([]Check, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Name == "" || len(entry.Command) == 0 {
+			return nil, fmt.Errorf("check %q needs a name and a command", entry.Name)
+		}
+		check := Check{Name: entry.Name, Command: entry.Command, Interval: interval, Timeout: timeout}
+		if entry.Interval != "" {
+			if check.Interval, err = time.ParseDuration(entry.Interval); err != nil || check.Interval <= 0 {
+				return nil, fmt.Errorf("invalid interval of check %s: %q", entry.Name, entry.Interval)
+			}
+		}
+		if entry.Timeout != "" {
+			if check.Timeout, err = time.ParseDuration(entry.Timeout); err != nil || check.Timeout <= 0 {
+				return nil, fmt.Errorf("invalid timeout of check %s: %q", entry.Name, entry.Timeout)
+			}
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+// DirChecks returns a check without arguments for every executable file in
+// dir, named after the file without extension.
+func DirChecks(dir string, interval, timeout time.Duration) ([]Check, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var checks []Check
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0o111 == 0 {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		checks = append(checks, Check{
+			Name:     name,
+			Command:  []string{filepath.Join(dir, entry.Name())},
+			Interval: interval,
+			Timeout:  timeout,
+		})
+	}
+	return checks, nil
+}
@@ -0,0 +1,186 @@
+package nagios
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeScript writes an executable shell script to dir and returns its path.
+func writeScript(t *testing.T, dir, name, body string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name   string
+		script string
+		state  State
+		output string
+	}{
+		{"ok", "echo 'PING OK - rta 1ms|rta=1ms'\n", OK, "PING OK - rta 1ms"},
+		{"warning", "echo 'DISK WARNING'\nexit 1\n", Warning, "DISK WARNING"},
+		{"critical", "echo 'DISK CRITICAL'\nexit 2\n", Critical, "DISK CRITICAL"},
+		{"unknown", "echo 'UNKNOWN - bad arguments'\nexit 3\n", Unknown, "UNKNOWN - bad arguments"},
+		{"unexpected exit code", "echo 'broken'\nexit 7\n", Unknown, "broken"},
+		{"killed by a signal", "kill -9 $$\n", Unknown, "no output, exit status 3"},
+		{"stderr only", "echo 'error on stderr' >&2\nexit 2\n", Critical, "error on stderr"},
+		{"no output", "exit 2\n", Critical, "no output, exit status 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := writeScript(t, dir, strings.ReplaceAll(tt.name, " ", "_"), tt.script)
+			result := Run(context.Background(), []string{script})
+			if result.State != tt.state || result.Output != tt.output {
+				t.Errorf("Run = %s %q, want %s %q", result.State, result.Output, tt.state, tt.output)
+			}
+		})
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	script := writeScript(t, t.TempDir(), "slow", "sleep 10\n")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	result := Run(ctx, []string{script})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run took %s past the deadline", elapsed)
+	}
+	if result.State != Unknown || !strings.HasPrefix(result.Output, "timed out after") {
+		t.Errorf("Run = %s %q, want unknown and timed out", result.State, result.Output)
+	}
+}
+
+func TestRunFailsToStart(t *testing.T) {
+	for _, command := range [][]string{nil, {filepath.Join(t.TempDir(), "missing")}} {
+		if result := Run(context.Background(), command); result.State != Unknown || result.Output == "" {
+			t.Errorf("Run(%q) = %s %q, want unknown with a reason", command, result.State, result.Output)
+		}
+	}
+}
+
+func TestParseOutput(t *testing.T) {
+	// The multi-line example of the Nagios plugin guidelines
+	output := "DISK OK - free space: / 3326 MB (56%); | /=2643MB;5948;5958;0;5968\n" +
+		"/ 15272 MB (77%);\n" +
+		"/boot 68 MB (69%);\n" +
+		"/var/log 819 MB (84%); | /boot=68MB;88;93;0;98\n" +
+		"/var/log=818MB;970;975;0;980\n"
+
+	text, long, perfdata := ParseOutput(output)
+	if text != "DISK OK - free space: / 3326 MB (56%);" {
+		t.Errorf("text = %q", text)
+	}
+	if want := "/ 15272 MB (77%);\n/boot 68 MB (69%);\n/var/log 819 MB (84%);"; long != want {
+		t.Errorf("long output = %q, want %q", long, want)
+	}
+	var labels []string
+	for _, p := range perfdata {
+		labels = append(labels, p.Label)
+	}
+	if want := []string{"/", "/boot", "/var/log"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("perfdata labels = %q, want %q", labels, want)
+	}
+
+	// Output without perfdata or long output
+	text, long, perfdata = ParseOutput("OK\n")
+	if text != "OK" || long != "" || perfdata != nil {
+		t.Errorf("ParseOutput(OK) = %q, %q, %v", text, long, perfdata)
+	}
+}
+
+func TestParsePerfdata(t *testing.T) {
+	f := func(v float64) *float64 { return &v }
+	tests := []struct {
+		input string
+		want  []Perfdata
+	}{
+		{"", nil},
+		{"time=0.5s;1;2;0;10", []Perfdata{{Label: "time", Value: 0.5, UOM: "s", Warning: f(1), Critical: f(2), Min: f(0), Max: f(10)}}},
+		{"users=3", []Perfdata{{Label: "users", Value: 3}}},
+		{"used=50%;80;90", []Perfdata{{Label: "used", Value: 50, UOM: "%", Warning: f(80), Critical: f(90)}}},
+		{"'disk usage'=1.5GB", []Perfdata{{Label: "disk usage", Value: 1.5, UOM: "GB"}}},
+		{"'it''s'=1c", []Perfdata{{Label: "it's", Value: 1, UOM: "c"}}},
+		{"temp=-5", []Perfdata{{Label: "temp", Value: -5}}},
+		// Ranges are not plain thresholds
+		{"rta=1.2ms;@10:20;200", []Perfdata{{Label: "rta", Value: 1.2, UOM: "ms", Critical: f(200)}}},
+		// Unknown values and malformed tokens are skipped
+		{"load=U users=2", []Perfdata{{Label: "users", Value: 2}}},
+		{"garbage  pl=0%", []Perfdata{{Label: "pl", Value: 0, UOM: "%"}}},
+		{"=5 'unterminated=1", nil},
+	}
+	for _, tt := range tests {
+		if got := ParsePerfdata(tt.input); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePerfdata(%q) = %+v, want %+v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestLoadChecks(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "checks.json")
+	os.WriteFile(path, []byte(`[
+		{"name": "disk", "command": ["check_disk", "-w", "20%"]},
+		{"name": "ping", "command": ["check_ping"], "interval": "5m", "timeout": "30s"}
+	]`), 0o644)
+
+	checks, err := LoadChecks(path, time.Minute, 10*time.Second)
+	if err != nil {
+		t.Fatalf("LoadChecks: %v", err)
+	}
+	want := []Check{
+		{Name: "disk", Command: []string{"check_disk", "-w", "20%"}, Interval: time.Minute, Timeout: 10 * time.Second},
+		{Name: "ping", Command: []string{"check_ping"}, Interval: 5 * time.Minute, Timeout: 30 * time.Second},
+	}
+	if !reflect.DeepEqual(checks, want) {
+		t.Errorf("checks = %+v, want %+v", checks, want)
+	}
+
+	for name, content := range map[string]string{
+		"invalid JSON":     `{"name": "disk"}`,
+		"no name":          `[{"command": ["check_disk"]}]`,
+		"no command":       `[{"name": "disk"}]`,
+		"invalid interval": `[{"name": "disk", "command": ["check_disk"], "interval": "often"}]`,
+		"zero timeout":     `[{"name": "disk", "command": ["check_disk"], "timeout": "0s"}]`,
+	} {
+		os.WriteFile(path, []byte(content), 0o644)
+		if _, err := LoadChecks(path, time.Minute, 10*time.Second); err == nil {
+			t.Errorf("LoadChecks accepted a file with %s", name)
+		}
+	}
+	if _, err := LoadChecks(filepath.Join(dir, "missing.json"), time.Minute, time.Second); err == nil {
+		t.Error("LoadChecks returned no error for a missing file")
+	}
+}
+
+func TestDirChecks(t *testing.T) {
+	dir := t.TempDir()
+	disk := writeScript(t, dir, "check_disk.sh", "exit 0\n")
+	writeScript(t, dir, "check_load", "exit 0\n")
+	os.WriteFile(filepath.Join(dir, "README"), []byte("not a plugin"), 0o644)
+	os.Mkdir(filepath.Join(dir, "lib"), 0o755)
+
+	checks, err := DirChecks(dir, time.Minute, 10*time.Second)
+	if err != nil {
+		t.Fatalf("DirChecks: %v", err)
+	}
+	want := []Check{
+		{Name: "check_disk", Command: []string{disk}, Interval: time.Minute, Timeout: 10 * time.Second},
+		{Name: "check_load", Command: []string{filepath.Join(dir, "check_load")}, Interval: time.Minute, Timeout: 10 * time.Second},
+	}
+	if !reflect.DeepEqual(checks, want) {
+		t.Errorf("checks = %+v, want %+v", checks, want)
+	}
+}
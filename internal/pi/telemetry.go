@@ -0,0 +1,159 @@
+package pi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+// Reader reads Raspberry Pi telemetry from sysfs and vcgencmd. The paths
+// can point at a fixture tree.
+type Reader struct {
+	// SysRoot is the sysfs mount, usually /sys
+	SysRoot string
+	// Vcgencmd is the vcgencmd binary. Throttling is not reported when it
+	// is empty or not installed.
+	Vcgencmd string
+}
+
+func NewReader() *Reader {
+	return &Reader{SysRoot: "/sys", Vcgencmd: "vcgencmd"}
+}
+
+// Available reports whether the host exposes any thermal zone.
+func (r *Reader) Available() bool {
+	zones, _ := filepath.Glob(filepath.Join(r.SysRoot, "class/thermal/thermal_zone*/temp"))
+	return len(zones) > 0
+}
+
+// Read collects the current telemetry. Sources that cannot be read are
+// left empty; only a failing vcgencmd is returned as error, alongside the
+// telemetry read so far. A missing vcgencmd is no error, as it is usually
+// not installed in containers.
+func (r *Reader) Read(ctx context.Context) (types.PiTelemetry, error) {
+	var telemetry types.PiTelemetry
+
+	zones, _ := filepath.Glob(filepath.Join(r.SysRoot, "class/thermal/thermal_zone*"))
+	for _, zone := range zones {
+		milli, err := readInt(filepath.Join(zone, "temp"))
+		if err != nil {
+			continue
+		}
+		name, err := readString(filepath.Join(zone, "type"))
+		if err != nil {
+			name = filepath.Base(zone)
+		}
+		telemetry.Temperatures = append(telemetry.Temperatures, types.Temperature{
+			Zone:    name,
+			Celsius: float64(milli) / 1000,
+		})
+	}
+
+	// Average over all cores, reported in kHz
+	freqs, _ := filepath.Glob(filepath.Join(r.SysRoot, "devices/system/cpu/cpu[0-9]*/cpufreq/scaling_cur_freq"))
+	var total float64
+	var count int
+	for _, path := range freqs {
+		khz, err := readInt(path)
+		if err != nil {
+			continue
+		}
+		total += float64(khz)
+		count++
+	}
+	if count > 0 {
+		telemetry.CPUFrequencyMHz = total / float64(count) / 1000
+	}
+
+	// The Pi 5 fan shows up as a hwmon device with a tachometer
+	fans, _ := filepath.Glob(filepath.Join(r.SysRoot, "class/hwmon/hwmon*/fan1_input"))
+	for _, path := range fans {
+		rpm, err := readInt(path)
+		if err != nil {
+			continue
+		}
+		value := float64(rpm)
+		telemetry.FanRPM = &value
+		break
+	}
+
+	if r.Vcgencmd == "" {
+		return telemetry, nil
+	}
+	throttled, err := r.readThrottled(ctx)
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return telemetry, nil
+	}
+	if err != nil {
+		return telemetry, err
+	}
+	telemetry.Throttled = throttled
+	return telemetry, nil
+}
+
+func (r *Reader) readThrottled(ctx context.Context) (*types.ThrottledState, error) {
+	out, err := exec.CommandContext(ctx, r.Vcgencmd, "get_throttled").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run %s: %w", r.Vcgencmd, err)
+	}
+	raw, err := ParseThrottled(string(out))
+	if err != nil {
+		return nil, err
+	}
+	state := DecodeThrottled(raw)
+	return &state, nil
+}
+
+// ParseThrottled parses vcgencmd output such as "throttled=0x50005".
+func ParseThrottled(output string) (uint32, error) {
+	value, found := strings.CutPrefix(strings.TrimSpace(output), "throttled=")
+	if !found {
+		return 0, fmt.Errorf("unexpected get_throttled output %q", output)
+	}
+	raw, err := strconv.ParseUint(value, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid throttled value %q: %w", value, err)
+	}
+	return uint32(raw), nil
+}
+
+// DecodeThrottled decodes the get_throttled bit field as documented for
+// the Raspberry Pi firmware.
+func DecodeThrottled(raw uint32) types.ThrottledState {
+	bit := func(n uint) bool { return raw&(1<<n) != 0 }
+	return types.ThrottledState{
+		Raw:                     raw,
+		UnderVoltage:            bit(0),
+		FrequencyCapped:         bit(1),
+		Throttled:               bit(2),
+		SoftTempLimit:           bit(3),
+		UnderVoltageOccurred:    bit(16),
+		FrequencyCappedOccurred: bit(17),
+		ThrottledOccurred:       bit(18),
+		SoftTempLimitOccurred:   bit(19),
+	}
+}
+
+func readString(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func readInt(path string) (int64, error) {
+	value, err := readString(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
@@ -0,0 +1,140 @@
+package pi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFiles creates a fixture tree below root from paths and contents.
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// piSysfs is the sysfs of a Pi 5 with two cores and a fan.
+var piSysfs = map[string]string{
+	"class/thermal/thermal_zone0/temp":                 "52150\n",
+	"class/thermal/thermal_zone0/type":                 "cpu-thermal\n",
+	"class/thermal/thermal_zone1/temp":                 "48000\n",
+	"devices/system/cpu/cpu0/cpufreq/scaling_cur_freq": "2400000\n",
+	"devices/system/cpu/cpu1/cpufreq/scaling_cur_freq": "1600000\n",
+	"class/hwmon/hwmon2/fan1_input":                    "3100\n",
+}
+
+func TestRead(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, piSysfs)
+	writeFiles(t, root, map[string]string{"bin/vcgencmd": "#!/bin/sh\necho throttled=0x50005\n"})
+
+	reader := &Reader{SysRoot: root, Vcgencmd: filepath.Join(root, "bin/vcgencmd")}
+	if !reader.Available() {
+		t.Fatal("Available = false with thermal zones")
+	}
+	telemetry, err := reader.Read(context.Background())
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	if len(telemetry.Temperatures) != 2 {
+		t.Fatalf("got %d temperatures, want 2", len(telemetry.Temperatures))
+	}
+	if zone := telemetry.Temperatures[0]; zone.Zone != "cpu-thermal" || zone.Celsius != 52.15 {
+		t.Errorf("first zone = %+v, want cpu-thermal at 52.15", zone)
+	}
+	// Zones without a type are named after their directory
+	if zone := telemetry.Temperatures[1]; zone.Zone != "thermal_zone1" {
+		t.Errorf("second zone = %q, want thermal_zone1", zone.Zone)
+	}
+	if telemetry.CPUFrequencyMHz != 2000 {
+		t.Errorf("CPUFrequencyMHz = %v, want the average of 2000", telemetry.CPUFrequencyMHz)
+	}
+	if telemetry.FanRPM == nil || *telemetry.FanRPM != 3100 {
+		t.Errorf("FanRPM = %v, want 3100", telemetry.FanRPM)
+	}
+	throttled := telemetry.Throttled
+	if throttled == nil {
+		t.Fatal("Throttled = nil with vcgencmd")
+	}
+	if throttled.Raw != 0x50005 || !throttled.UnderVoltage || !throttled.Throttled || throttled.FrequencyCapped {
+		t.Errorf("Throttled = %+v, want current under-voltage and throttling", throttled)
+	}
+}
+
+func TestReadWithoutVcgencmd(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, piSysfs)
+
+	for _, vcgencmd := range []string{"vcgencmd-not-installed", filepath.Join(root, "bin/vcgencmd")} {
+		reader := &Reader{SysRoot: root, Vcgencmd: vcgencmd}
+		telemetry, err := reader.Read(context.Background())
+		if err != nil {
+			t.Errorf("Read with missing %s: %v", vcgencmd, err)
+		}
+		if telemetry.Throttled != nil {
+			t.Errorf("Throttled = %+v without vcgencmd", telemetry.Throttled)
+		}
+		if len(telemetry.Temperatures) != 2 {
+			t.Errorf("got %d temperatures without vcgencmd, want 2", len(telemetry.Temperatures))
+		}
+	}
+}
+
+func TestReadFailingVcgencmd(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, piSysfs)
+	writeFiles(t, root, map[string]string{"bin/vcgencmd": "#!/bin/sh\necho 'VCHI initialization failed' >&2\nexit 1\n"})
+
+	reader := &Reader{SysRoot: root, Vcgencmd: filepath.Join(root, "bin/vcgencmd")}
+	telemetry, err := reader.Read(context.Background())
+	if err == nil {
+		t.Error("Read returned no error for a failing vcgencmd")
+	}
+	if len(telemetry.Temperatures) != 2 || telemetry.CPUFrequencyMHz == 0 {
+		t.Errorf("sysfs telemetry was dropped: %+v", telemetry)
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	if (&Reader{SysRoot: t.TempDir()}).Available() {
+		t.Error("Available = true without thermal zones")
+	}
+}
+
+func TestParseThrottled(t *testing.T) {
+	tests := []struct {
+		output  string
+		want    uint32
+		wantErr bool
+	}{
+		{"throttled=0x0\n", 0, false},
+		{"throttled=0x50005", 0x50005, false},
+		{"error=1 error_msg=\"Command not registered\"", 0, true},
+		{"throttled=zero", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseThrottled(tt.output)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseThrottled(%q) = %#x, %v, want %#x", tt.output, got, err, tt.want)
+		}
+	}
+}
+
+func TestDecodeThrottled(t *testing.T) {
+	state := DecodeThrottled(0xA0002)
+	if !state.FrequencyCapped || state.UnderVoltage || state.Throttled {
+		t.Errorf("current flags of 0xA0002 = %+v", state)
+	}
+	if !state.FrequencyCappedOccurred || !state.SoftTempLimitOccurred || state.UnderVoltageOccurred || state.ThrottledOccurred {
+		t.Errorf("occurred flags of 0xA0002 = %+v", state)
+	}
+}
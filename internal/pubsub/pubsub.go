@@ -0,0 +1,83 @@
+// Package pubsub fans out published values to subscribers that only care
+// about the latest one, such as snapshots of the collected state.
+package pubsub
+
+import "sync"
+
+// Hub delivers published values to its subscribers. Every subscriber holds
+// at most one pending value: a newer value replaces one that was not
+// received yet, so a slow subscriber never blocks the publisher and always
+// ends up with the latest value.
+type Hub[T any] struct {
+	mu          sync.Mutex
+	latest      T
+	published   bool
+	subscribers map[*Subscription[T]]struct{}
+}
+
+// Subscription receives the values published on a Hub.
+type Subscription[T any] struct {
+	hub    *Hub[T]
+	values chan T
+}
+
+func New[T any]() *Hub[T] {
+	return &Hub[T]{subscribers: make(map[*Subscription[T]]struct{})}
+}
+
+// Publish hands v to all subscribers, replacing values they did not
+// receive yet.
+func (h *Hub[T]) Publish(v T) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.latest = v
+	h.published = true
+	for sub := range h.subscribers {
+		sub.offer(v)
+	}
+}
+
+// Latest returns the last published value. ok is false before the first
+// Publish.
+func (h *Hub[T]) Latest() (v T, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.latest, h.published
+}
+
+// Subscribe starts a subscription. It receives the last published value
+// right away, if there is one.
+func (h *Hub[T]) Subscribe() *Subscription[T] {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := &Subscription[T]{hub: h, values: make(chan T, 1)}
+	h.subscribers[sub] = struct{}{}
+	if h.published {
+		sub.offer(h.latest)
+	}
+	return sub
+}
+
+// C returns the channel values are received on.
+func (s *Subscription[T]) C() <-chan T {
+	return s.values
+}
+
+// Close ends the subscription. No values are received after it returns.
+func (s *Subscription[T]) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	delete(s.hub.subscribers, s)
+}
+
+// offer replaces the pending value with v. The hub lock must be held, which
+// makes it the only sender.
+func (s *Subscription[T]) offer(v T) {
+	select {
+	case <-s.values:
+	default:
+	}
+	s.values <- v
+}
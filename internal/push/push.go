@@ -0,0 +1,240 @@
+package push
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hra42/iot-hub-statuspage/internal/storage"
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+const group = "Push"
+
+// ErrUnknownToken is returned for pings with a token of no check.
+var ErrUnknownToken = errors.New("unknown push token")
+
+// Check is a job that reports in on its own, e.g. a cron backup. It is down
+// when no ping arrives within Period plus Grace of the previous one.
+type Check struct {
+	Name   string
+	Token  string
+	Period time.Duration
+	Grace  time.Duration
+}
+
+// ParseChecks parses a comma separated list of name=token:period:grace
+// entries, e.g. "backup=f3a9c1:24h:1h,esp32-kitchen=77b0e2:5m:2m".
+func ParseChecks(spec string) ([]Check, error) {
+	var checks []Check
+	names := make(map[string]bool)
+	tokens := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, value, found := strings.Cut(entry, "=")
+		fields := strings.Split(value, ":")
+		if !found || name == "" || len(fields) != 3 || fields[0] == "" {
+			return nil, fmt.Errorf("invalid push check %q, expected name=token:period:grace", entry)
+		}
+		check := Check{Name: strings.TrimSpace(name), Token: fields[0]}
+		var err error
+		if check.Period, err = time.ParseDuration(fields[1]); err != nil || check.Period <= 0 {
+			return nil, fmt.Errorf("invalid period of push check %s: %q", check.Name, fields[1])
+		}
+		if check.Grace, err = time.ParseDuration(fields[2]); err != nil || check.Grace < 0 {
+			return nil, fmt.Errorf("invalid grace time of push check %s: %q", check.Name, fields[2])
+		}
+		if names[check.Name] || tokens[check.Token] {
+			return nil, fmt.Errorf("push check %s reuses a name or token", check.Name)
+		}
+		names[check.Name] = true
+		tokens[check.Token] = true
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
+
+// Ping is what a check sends when it calls in. All fields are optional.
+type Ping struct {
+	// Status is ok (the default), warning, fail or an exit code
+	Status  string   `json:"status" form:"status"`
+	Message string   `json:"message" form:"message"`
+	Value   *float64 `json:"value" form:"value"`
+}
+
+// Store persists the last ping of every check. It is implemented by
+// *storage.DB.
+type Store interface {
+	GetPushStates() (map[string]storage.PushState, error)
+	SavePushState(state storage.PushState) error
+}
+
+// Monitor records pings in the database and reports every check as a
+// service.
+type Monitor struct {
+	db      Store
+	checks  []Check
+	byToken map[string]Check
+	// onPing is called after every accepted ping
+	onPing func()
+
+	mu     sync.Mutex
+	states map[string]storage.PushState
+	// pending holds the values of pings not reported yet
+	pending []types.Metric
+}
+
+// NewMonitor creates a monitor for checks, restoring the last pings from
+// the database. onPing is called after every accepted ping, e.g. to report
+// the new state right away.
+func NewMonitor(db Store, checks []Check, onPing func()) (*Monitor, error) {
+	states, err := db.GetPushStates()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load push states: %w", err)
+	}
+	m := &Monitor{
+		db:      db,
+		checks:  checks,
+		byToken: make(map[string]Check, len(checks)),
+		onPing:  onPing,
+		states:  states,
+	}
+	for _, check := range checks {
+		m.byToken[check.Token] = check
+	}
+	return m, nil
+}
+
+// Ping records a ping for the check with token and returns the check.
+func (m *Monitor) Ping(token string, ping Ping) (Check, error) {
+	check, ok := m.byToken[token]
+	if !ok {
+		return Check{}, ErrUnknownToken
+	}
+	status, err := normalizeStatus(ping.Status)
+	if err != nil {
+		return check, err
+	}
+
+	state := storage.PushState{
+		Check:    check.Name,
+		LastPing: time.Now(),
+		Status:   status,
+		Message:  ping.Message,
+		Value:    ping.Value,
+	}
+	if err := m.db.SavePushState(state); err != nil {
+		// The ping still counts until the next restart
+		log.Printf("Failed to store ping of %s: %v", check.Name, err)
+	}
+
+	m.mu.Lock()
+	m.states[check.Name] = state
+	if ping.Value != nil {
+		m.pending = append(m.pending, types.Metric{
+			Name:   "push_value",
+			Value:  *ping.Value,
+			Labels: map[string]string{"check": check.Name},
+		})
+	}
+	m.mu.Unlock()
+
+	if m.onPing != nil {
+		m.onPing()
+	}
+	return check, nil
+}
+
+// normalizeStatus maps the reported status onto ok, warning or fail. Exit
+// codes other than 0 are failures.
+func normalizeStatus(status string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(status)) {
+	case "", "ok", "up", "success":
+		return "ok", nil
+	case "warning", "warn":
+		return "warning", nil
+	case "fail", "failure", "down", "error":
+		return "fail", nil
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil || code < 0 || code > 255 {
+		return "", fmt.Errorf("invalid status %q, expected ok, warning, fail or an exit code", status)
+	}
+	if code == 0 {
+		return "ok", nil
+	}
+	return "fail", nil
+}
+
+// Report returns the state of every check as a service, and the age of
+// their last pings plus the values received since the previous call as
+// metrics.
+func (m *Monitor) Report() ([]types.ServiceStatus, []types.Metric) {
+	now := time.Now()
+	var services []types.ServiceStatus
+	var values []types.Metric
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, check := range m.checks {
+		state, ok := m.states[check.Name]
+		services = append(services, serviceStatus(check, state, ok, now))
+		if ok {
+			values = append(values, types.Metric{
+				Name:   "push_age",
+				Value:  now.Sub(state.LastPing).Seconds(),
+				Unit:   types.UnitSeconds,
+				Labels: map[string]string{"check": check.Name},
+			})
+		}
+	}
+	values = append(values, m.pending...)
+	m.pending = nil
+	return services, values
+}
+
+// serviceStatus derives the health of a check from its last ping. A check
+// is late once the period passed and down once the grace time passed too.
+func serviceStatus(check Check, state storage.PushState, pinged bool, now time.Time) types.ServiceStatus {
+	status := types.ServiceStatus{Name: check.Name, Group: group}
+	if !pinged {
+		status.Status = "new"
+		status.Health = types.HealthUnknown
+		status.Details = "Waiting for the first ping"
+		return status
+	}
+
+	age := now.Sub(state.LastPing).Round(time.Second)
+	switch {
+	case age > check.Period+check.Grace:
+		status.Status = "down"
+		status.Health = types.HealthMajorOutage
+		status.Details = fmt.Sprintf("No ping for %s, expected every %s", age, check.Period)
+		return status
+	case state.Status == "fail":
+		status.Status = "failed"
+		status.Health = types.HealthMajorOutage
+	case state.Status == "warning":
+		status.Status = "warning"
+		status.Health = types.HealthDegraded
+	case age > check.Period:
+		status.Status = "late"
+		status.Health = types.HealthDegraded
+	default:
+		status.Status = "up"
+		status.Health = types.HealthOperational
+	}
+
+	status.Details = state.Message
+	if status.Details == "" {
+		status.Details = fmt.Sprintf("Last ping %s ago", age)
+	}
+	return status
+}
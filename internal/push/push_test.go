@@ -0,0 +1,205 @@
+package push
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hra42/iot-hub-statuspage/internal/storage"
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+// fakeStore keeps the push states in memory. A set err fails every call.
+type fakeStore struct {
+	states map[string]storage.PushState
+	err    error
+}
+
+func (f *fakeStore) GetPushStates() (map[string]storage.PushState, error) {
+	states := make(map[string]storage.PushState)
+	for name, state := range f.states {
+		states[name] = state
+	}
+	return states, f.err
+}
+
+func (f *fakeStore) SavePushState(state storage.PushState) error {
+	if f.err != nil {
+		return f.err
+	}
+	if f.states == nil {
+		f.states = make(map[string]storage.PushState)
+	}
+	f.states[state.Check] = state
+	return nil
+}
+
+func TestParseChecks(t *testing.T) {
+	checks, err := ParseChecks(" backup=f3a9c1:24h:1h, esp32-kitchen=77b0e2:5m:0s,")
+	if err != nil {
+		t.Fatalf("ParseChecks: %v", err)
+	}
+	want := []Check{
+		{Name: "backup", Token: "f3a9c1", Period: 24 * time.Hour, Grace: time.Hour},
+		{Name: "esp32-kitchen", Token: "77b0e2", Period: 5 * time.Minute},
+	}
+	if !reflect.DeepEqual(checks, want) {
+		t.Errorf("checks = %+v, want %+v", checks, want)
+	}
+
+	for _, spec := range []string{
+		"backup",
+		"=f3a9c1:24h:1h",
+		"backup=:24h:1h",
+		"backup=f3a9c1:24h",
+		"backup=f3a9c1:daily:1h",
+		"backup=f3a9c1:0s:1h",
+		"backup=f3a9c1:24h:-1h",
+		"backup=a:24h:1h,backup=b:24h:1h",
+		"backup=a:24h:1h,restore=a:24h:1h",
+	} {
+		if _, err := ParseChecks(spec); err == nil {
+			t.Errorf("ParseChecks(%q) returned no error", spec)
+		}
+	}
+}
+
+func TestNormalizeStatus(t *testing.T) {
+	tests := map[string]string{
+		"":        "ok",
+		"OK":      "ok",
+		"success": "ok",
+		"0":       "ok",
+		" warn ":  "warning",
+		"Warning": "warning",
+		"fail":    "fail",
+		"down":    "fail",
+		"1":       "fail",
+		"255":     "fail",
+	}
+	for input, want := range tests {
+		if got, err := normalizeStatus(input); err != nil || got != want {
+			t.Errorf("normalizeStatus(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"maybe", "-1", "256"} {
+		if _, err := normalizeStatus(input); err == nil {
+			t.Errorf("normalizeStatus(%q) returned no error", input)
+		}
+	}
+}
+
+func TestServiceStatus(t *testing.T) {
+	check := Check{Name: "backup", Period: time.Hour, Grace: 10 * time.Minute}
+	now := time.Now()
+	ping := func(age time.Duration, status, message string) storage.PushState {
+		return storage.PushState{Check: "backup", LastPing: now.Add(-age), Status: status, Message: message}
+	}
+
+	tests := []struct {
+		name    string
+		state   storage.PushState
+		pinged  bool
+		status  string
+		health  types.Health
+		details string
+	}{
+		{"never pinged", storage.PushState{}, false, "new", types.HealthUnknown, "Waiting for the first ping"},
+		{"within the period", ping(30*time.Minute, "ok", ""), true, "up", types.HealthOperational, "Last ping 30m0s ago"},
+		{"with a message", ping(time.Minute, "ok", "42 files"), true, "up", types.HealthOperational, "42 files"},
+		{"past the period", ping(65*time.Minute, "ok", ""), true, "late", types.HealthDegraded, "Last ping 1h5m0s ago"},
+		{"past the grace time", ping(71*time.Minute, "ok", ""), true, "down", types.HealthMajorOutage, "No ping for 1h11m0s, expected every 1h0m0s"},
+		{"reported a failure", ping(time.Minute, "fail", "disk full"), true, "failed", types.HealthMajorOutage, "disk full"},
+		{"reported a warning", ping(time.Minute, "warning", "slow"), true, "warning", types.HealthDegraded, "slow"},
+		// A failure that is not repeated still expires
+		{"failure past the grace time", ping(2*time.Hour, "fail", "disk full"), true, "down", types.HealthMajorOutage, "No ping for 2h0m0s, expected every 1h0m0s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := serviceStatus(check, tt.state, tt.pinged, now)
+			if status.Status != tt.status || status.Health != tt.health || status.Details != tt.details {
+				t.Errorf("status = %s %s %q, want %s %s %q", status.Status, status.Health, status.Details, tt.status, tt.health, tt.details)
+			}
+			if status.Name != "backup" || status.Group != group {
+				t.Errorf("service = %s in %s, want backup in %s", status.Name, status.Group, group)
+			}
+		})
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	checks := []Check{
+		{Name: "backup", Token: "f3a9c1", Period: time.Hour, Grace: time.Minute},
+		{Name: "sensor", Token: "77b0e2", Period: time.Minute, Grace: time.Minute},
+	}
+	// The backup pinged before a restart, too long ago
+	db := &fakeStore{states: map[string]storage.PushState{
+		"backup": {Check: "backup", LastPing: time.Now().Add(-2 * time.Hour), Status: "ok"},
+	}}
+	pings := 0
+	m, err := NewMonitor(db, checks, func() { pings++ })
+	if err != nil {
+		t.Fatalf("NewMonitor: %v", err)
+	}
+
+	services, _ := m.Report()
+	if services[0].Status != "down" || services[1].Status != "new" {
+		t.Errorf("restored services = %s, %s, want down and new", services[0].Status, services[1].Status)
+	}
+
+	value := 21.5
+	check, err := m.Ping("77b0e2", Ping{Status: "0", Message: "alive", Value: &value})
+	if err != nil || check.Name != "sensor" {
+		t.Fatalf("Ping = %s, %v, want the sensor", check.Name, err)
+	}
+	if pings != 1 {
+		t.Errorf("onPing was called %d times, want once", pings)
+	}
+	if saved := db.states["sensor"]; saved.Status != "ok" || saved.Message != "alive" || *saved.Value != value {
+		t.Errorf("stored state = %+v", saved)
+	}
+
+	services, metrics := m.Report()
+	if services[1].Status != "up" || services[1].Details != "alive" {
+		t.Errorf("sensor = %s %q, want up with the message", services[1].Status, services[1].Details)
+	}
+	var values []float64
+	for _, metric := range metrics {
+		if metric.Name == "push_value" {
+			values = append(values, metric.Value)
+		}
+	}
+	if !reflect.DeepEqual(values, []float64{value}) {
+		t.Errorf("push_value = %v, want %v", values, value)
+	}
+	// Values are reported once
+	if _, metrics := m.Report(); len(metrics) != 2 {
+		t.Errorf("second report has %d metrics, want the 2 ages only", len(metrics))
+	}
+
+	if _, err := m.Ping("unknown", Ping{}); !errors.Is(err, ErrUnknownToken) {
+		t.Errorf("Ping with an unknown token = %v, want ErrUnknownToken", err)
+	}
+	if _, err := m.Ping("f3a9c1", Ping{Status: "maybe"}); err == nil {
+		t.Error("Ping accepted an invalid status")
+	}
+	if pings != 1 {
+		t.Errorf("rejected pings called onPing")
+	}
+
+	// A ping that cannot be stored still counts
+	db.err = errors.New("database down")
+	if _, err := m.Ping("f3a9c1", Ping{}); err != nil {
+		t.Errorf("Ping failed with the database down: %v", err)
+	}
+	if services, _ := m.Report(); services[0].Status != "up" {
+		t.Errorf("backup = %s, want up", services[0].Status)
+	}
+}
+
+func TestNewMonitorLoadError(t *testing.T) {
+	if _, err := NewMonitor(&fakeStore{err: errors.New("database down")}, nil, nil); err == nil {
+		t.Error("NewMonitor returned no error when the states fail to load")
+	}
+}
@@ -1,11 +1,14 @@
 package storage
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type DB struct {
@@ -25,6 +28,20 @@ type SystemMetric struct {
 	MetricType string
 	Value      float64
 	Timestamp  time.Time
+	// Labels identify the series for metrics reported per instance,
+	// e.g. {"container": "mosquitto"}. Host-wide metrics have none.
+	Labels map[string]string `json:",omitempty"`
+}
+
+// PushState is the last ping received by a push check.
+type PushState struct {
+	Check    string
+	LastPing time.Time
+	// Status is the status reported with the ping, e.g. ok or fail
+	Status  string
+	Message string
+	// Value is the optional value sent with the ping
+	Value *float64
 }
 
 func NewDB(connStr string) (*DB, error) {
@@ -34,12 +51,12 @@ func NewDB(connStr string) (*DB, error) {
 	}
 
 	db := &DB{conn: conn}
-	
+
 	// Set PostgreSQL connection pool settings
 	conn.SetMaxOpenConns(25)
 	conn.SetMaxIdleConns(5)
 	conn.SetConnMaxLifetime(5 * time.Minute)
-	
+
 	if err := db.createTables(); err != nil {
 		conn.Close()
 		return nil, fmt.Errorf("failed to create tables: %w", err)
@@ -70,6 +87,18 @@ func (db *DB) createTables() error {
 		`CREATE INDEX IF NOT EXISTS idx_service_status_service ON service_status(service, timestamp)`,
 		`CREATE INDEX IF NOT EXISTS idx_system_metrics_timestamp ON system_metrics(timestamp)`,
 		`CREATE INDEX IF NOT EXISTS idx_system_metrics_type ON system_metrics(metric_type, timestamp)`,
+		`ALTER TABLE system_metrics ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}'::jsonb`,
+		`CREATE TABLE IF NOT EXISTS push_checks (
+			check_name VARCHAR(255) PRIMARY KEY,
+			last_ping TIMESTAMP NOT NULL,
+			status VARCHAR(50) NOT NULL,
+			message TEXT NOT NULL DEFAULT '',
+			value DOUBLE PRECISION
+		)`,
+		`CREATE TABLE IF NOT EXISTS schema_migrations (
+			name VARCHAR(255) PRIMARY KEY,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
 	}
 
 	for _, query := range queries {
@@ -78,9 +107,61 @@ func (db *DB) createTables() error {
 		}
 	}
 
+	for _, m := range migrations {
+		if err := db.migrate(m.name, m.queries); err != nil {
+			return fmt.Errorf("failed to apply migration %s: %w", m.name, err)
+		}
+	}
+
 	return nil
 }
 
+// migrations rewrite existing rows. Unlike the statements of createTables
+// they are not idempotent or too slow to run on every start, so each runs
+// once and is recorded in schema_migrations.
+var migrations = []struct {
+	name    string
+	queries []string
+}{
+	{
+		// Rows written before the health model stored a binary UP/DOWN
+		name: "health_statuses",
+		queries: []string{
+			`UPDATE service_status SET status = 'operational' WHERE status = 'UP'`,
+			`UPDATE service_status SET status = 'major_outage' WHERE status = 'DOWN'`,
+		},
+	},
+}
+
+// migrate runs the queries of a migration in a transaction, unless it was
+// applied before.
+func (db *DB) migrate(name string, queries []string) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`INSERT INTO schema_migrations (name) VALUES ($1) ON CONFLICT (name) DO NOTHING`, name)
+	if err != nil {
+		return err
+	}
+	applied, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if applied == 0 {
+		// Recorded by an earlier start
+		return nil
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func (db *DB) InsertServiceStatus(service, status, details string) error {
 	query := `INSERT INTO service_status (service, status, details) VALUES ($1, $2, $3)`
 	_, err := db.conn.Exec(query, service, status, details)
@@ -102,7 +183,7 @@ func (db *DB) BulkInsert(metrics []SystemMetric, statuses []ServiceStatus) error
 	defer tx.Rollback()
 
 	// Prepare statements for bulk inserts
-	metricStmt, err := tx.Prepare(`INSERT INTO system_metrics (metric_type, value) VALUES ($1, $2)`)
+	metricStmt, err := tx.Prepare(`INSERT INTO system_metrics (metric_type, value, labels) VALUES ($1, $2, $3)`)
 	if err != nil {
 		return fmt.Errorf("failed to prepare metric statement: %w", err)
 	}
@@ -116,7 +197,11 @@ func (db *DB) BulkInsert(metrics []SystemMetric, statuses []ServiceStatus) error
 
 	// Insert all metrics
 	for _, metric := range metrics {
-		if _, err := metricStmt.Exec(metric.MetricType, metric.Value); err != nil {
+		labels, err := encodeLabels(metric.Labels)
+		if err != nil {
+			return fmt.Errorf("failed to encode labels: %w", err)
+		}
+		if _, err := metricStmt.Exec(metric.MetricType, metric.Value, labels); err != nil {
 			return fmt.Errorf("failed to insert metric: %w", err)
 		}
 	}
@@ -144,7 +229,7 @@ func (db *DB) GetServiceStatusHistory(service string, duration time.Duration) ([
 		WHERE service = $1 AND timestamp >= $2 
 		ORDER BY timestamp DESC
 	`
-	
+
 	rows, err := db.conn.Query(query, service, since)
 	if err != nil {
 		return nil, err
@@ -171,7 +256,7 @@ func (db *DB) GetSystemMetricsHistory(metricType string, duration time.Duration)
 		WHERE metric_type = $1 AND timestamp >= $2 
 		ORDER BY timestamp ASC
 	`
-	
+
 	rows, err := db.conn.Query(query, metricType, since)
 	if err != nil {
 		return nil, err
@@ -190,6 +275,40 @@ func (db *DB) GetSystemMetricsHistory(metricType string, duration time.Duration)
 	return metrics, rows.Err()
 }
 
+// GetLabeledMetricsHistory returns the history of a labeled metric grouped
+// by the value of the given label, e.g. one series per container.
+func (db *DB) GetLabeledMetricsHistory(metricType, label string, duration time.Duration) (map[string][]SystemMetric, error) {
+	since := time.Now().Add(-duration)
+	query := `
+		SELECT id, metric_type, value, timestamp, labels
+		FROM system_metrics
+		WHERE metric_type = $1 AND timestamp >= $2 AND labels ? $3
+		ORDER BY timestamp ASC
+	`
+
+	rows, err := db.conn.Query(query, metricType, since, label)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	series := make(map[string][]SystemMetric)
+	for rows.Next() {
+		var m SystemMetric
+		var labels []byte
+		if err := rows.Scan(&m.ID, &m.MetricType, &m.Value, &m.Timestamp, &labels); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(labels, &m.Labels); err != nil {
+			return nil, fmt.Errorf("failed to decode labels: %w", err)
+		}
+		key := m.Labels[label]
+		series[key] = append(series[key], m)
+	}
+
+	return series, rows.Err()
+}
+
 func (db *DB) GetLatestServiceStatuses() (map[string]ServiceStatus, error) {
 	query := `
 		WITH latest AS (
@@ -201,7 +320,7 @@ func (db *DB) GetLatestServiceStatuses() (map[string]ServiceStatus, error) {
 		FROM service_status s
 		INNER JOIN latest l ON s.service = l.service AND s.timestamp = l.max_timestamp
 	`
-	
+
 	rows, err := db.conn.Query(query)
 	if err != nil {
 		return nil, err
@@ -220,6 +339,393 @@ func (db *DB) GetLatestServiceStatuses() (map[string]ServiceStatus, error) {
 	return statuses, rows.Err()
 }
 
+// MetricQuery selects stored metric rows. Zero fields are not filtered on.
+type MetricQuery struct {
+	MetricType string
+	From, To   time.Time
+	// Labels must all be present on a row with the given values
+	Labels map[string]string
+	// Before returns rows with a lower ID only, for paging
+	Before int64
+	Limit  int
+}
+
+// QueryMetrics returns the rows of a metric matching q, newest first.
+func (db *DB) QueryMetrics(q MetricQuery) ([]SystemMetric, error) {
+	labels, err := encodeLabels(q.Labels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode labels: %w", err)
+	}
+	query := `
+		SELECT id, metric_type, value, timestamp, labels
+		FROM system_metrics
+		WHERE metric_type = $1 AND timestamp >= $2 AND timestamp < $3
+			AND labels @> $4::jsonb AND ($5 = 0 OR id < $5)
+		ORDER BY id DESC
+		LIMIT $6
+	`
+
+	rows, err := db.conn.Query(query, q.MetricType, q.From, q.To, labels, q.Before, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []SystemMetric
+	for rows.Next() {
+		var m SystemMetric
+		var labels []byte
+		if err := rows.Scan(&m.ID, &m.MetricType, &m.Value, &m.Timestamp, &labels); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(labels, &m.Labels); err != nil {
+			return nil, fmt.Errorf("failed to decode labels: %w", err)
+		}
+		metrics = append(metrics, m)
+	}
+
+	return metrics, rows.Err()
+}
+
+// aggregations maps the supported aggregations onto their SQL.
+var aggregations = map[string]string{
+	"avg": "AVG(value)",
+	"min": "MIN(value)",
+	"max": "MAX(value)",
+	"p95": "percentile_cont(0.95) WITHIN GROUP (ORDER BY value)",
+}
+
+// ValidAggregation reports whether agg is supported by AggregateMetrics.
+func ValidAggregation(agg string) bool {
+	_, ok := aggregations[agg]
+	return ok
+}
+
+// AggregateQuery selects metric rows to aggregate into buckets of Step.
+type AggregateQuery struct {
+	MetricTypes []string
+	From, To    time.Time
+	// Labels must all be present on a row with the given values
+	Labels      map[string]string
+	Step        time.Duration
+	Aggregation string
+}
+
+// MetricSeries is the bucketed values of a metric with one set of labels.
+type MetricSeries struct {
+	MetricType string
+	Labels     map[string]string
+	Points     []MetricPoint
+}
+
+// MetricPoint is the aggregated value of a bucket, which starts at
+// Timestamp.
+type MetricPoint struct {
+	Timestamp time.Time
+	Value     float64
+}
+
+// AggregateMetrics aggregates the rows matching q into buckets aligned to
+// multiples of the step since the Unix epoch. Every metric and label set is
+// a series, ordered by metric type.
+func (db *DB) AggregateMetrics(q AggregateQuery) ([]MetricSeries, error) {
+	aggregate, ok := aggregations[q.Aggregation]
+	if !ok {
+		return nil, fmt.Errorf("unknown aggregation %q", q.Aggregation)
+	}
+	labels, err := encodeLabels(q.Labels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode labels: %w", err)
+	}
+	query := `
+		SELECT metric_type, labels,
+			to_timestamp(floor(extract(epoch FROM timestamp) / $5::double precision) * $5::double precision) AS bucket,
+			` + aggregate + `
+		FROM system_metrics
+		WHERE metric_type = ANY($1) AND timestamp >= $2 AND timestamp < $3 AND labels @> $4::jsonb
+		GROUP BY metric_type, labels, bucket
+		ORDER BY metric_type, labels, bucket
+	`
+
+	rows, err := db.conn.Query(query, pq.Array(q.MetricTypes), q.From, q.To, labels, q.Step.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var series []MetricSeries
+	var lastLabels []byte
+	for rows.Next() {
+		var metricType string
+		var labels []byte
+		var point MetricPoint
+		if err := rows.Scan(&metricType, &labels, &point.Timestamp, &point.Value); err != nil {
+			return nil, err
+		}
+		// Rows of a series are adjacent
+		if n := len(series); n == 0 || series[n-1].MetricType != metricType || !bytes.Equal(labels, lastLabels) {
+			s := MetricSeries{MetricType: metricType}
+			if err := json.Unmarshal(labels, &s.Labels); err != nil {
+				return nil, fmt.Errorf("failed to decode labels: %w", err)
+			}
+			series = append(series, s)
+			lastLabels = labels
+		}
+		series[len(series)-1].Points = append(series[len(series)-1].Points, point)
+	}
+
+	return series, rows.Err()
+}
+
+// MetricType describes a stored metric type.
+type MetricType struct {
+	Name string
+	// LabelNames are the label names its rows carry. They are only set by
+	// GetMetricType.
+	LabelNames []string
+	LastSeen   time.Time
+}
+
+// ListMetricTypes returns the stored metric types ordered by name, without
+// their label names. Both the types and their last rows are read from the
+// index on metric_type and timestamp.
+func (db *DB) ListMetricTypes() ([]MetricType, error) {
+	query := `
+		SELECT metric_type,
+			(SELECT MAX(timestamp) FROM system_metrics m WHERE m.metric_type = t.metric_type)
+		FROM (SELECT DISTINCT metric_type FROM system_metrics) t
+		ORDER BY metric_type
+	`
+
+	rows, err := db.conn.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var types []MetricType
+	for rows.Next() {
+		var t MetricType
+		if err := rows.Scan(&t.Name, &t.LastSeen); err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+	}
+
+	return types, rows.Err()
+}
+
+// GetMetricType returns a stored metric type with the label names of its
+// rows. It returns nil when there are no rows of the type.
+func (db *DB) GetMetricType(name string) (*MetricType, error) {
+	query := `
+		SELECT MAX(timestamp),
+			COALESCE((
+				SELECT array_agg(DISTINCT label ORDER BY label)
+				FROM system_metrics, jsonb_object_keys(labels) AS label
+				WHERE metric_type = $1
+			), '{}')
+		FROM system_metrics
+		WHERE metric_type = $1
+	`
+
+	t := MetricType{Name: name}
+	var lastSeen sql.NullTime
+	if err := db.conn.QueryRow(query, name).Scan(&lastSeen, pq.Array(&t.LabelNames)); err != nil {
+		return nil, err
+	}
+	if !lastSeen.Valid {
+		return nil, nil
+	}
+	t.LastSeen = lastSeen.Time
+	return &t, nil
+}
+
+// StatusBucket is the worst status a service had within a bucket starting
+// at Start.
+type StatusBucket struct {
+	Start  time.Time
+	Status string
+}
+
+// ServiceTimelines returns the worst status of every service per bucket of
+// step, aligned like AggregateMetrics. Buckets without rows are omitted. A
+// non-empty service limits the result to that service.
+func (db *DB) ServiceTimelines(service string, from, to time.Time, step time.Duration) (map[string][]StatusBucket, error) {
+	// Statuses ranked from best to worst, the rank indexes the array
+	query := `
+		SELECT service,
+			to_timestamp(floor(extract(epoch FROM timestamp) / $3::double precision) * $3::double precision) AS bucket,
+			(ARRAY['operational', 'maintenance', 'unknown', 'degraded', 'partial_outage', 'major_outage'])[MAX(
+				CASE status
+					WHEN 'maintenance' THEN 2
+					WHEN 'unknown' THEN 3
+					WHEN 'degraded' THEN 4
+					WHEN 'partial_outage' THEN 5
+					WHEN 'major_outage' THEN 6
+					ELSE 1
+				END)]
+		FROM service_status
+		WHERE timestamp >= $1 AND timestamp < $2 AND ($4 = '' OR service = $4)
+		GROUP BY service, bucket
+		ORDER BY service, bucket
+	`
+
+	rows, err := db.conn.Query(query, from, to, step.Seconds(), service)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	timelines := make(map[string][]StatusBucket)
+	for rows.Next() {
+		var service string
+		var b StatusBucket
+		if err := rows.Scan(&service, &b.Start, &b.Status); err != nil {
+			return nil, err
+		}
+		timelines[service] = append(timelines[service], b)
+	}
+
+	return timelines, rows.Err()
+}
+
+// ServiceUptime returns the share of the time between from and to in which
+// a service was operational or in maintenance. Every stored status lasts
+// until the next one, so the status in effect at from counts as well and
+// repeated rows of one status do not weigh more. ok is false when there are
+// no rows.
+func (db *DB) ServiceUptime(service string, from, to time.Time) (ratio float64, ok bool, err error) {
+	query := `
+		SELECT SUM(CASE WHEN status IN ('operational', 'maintenance', 'UP') THEN seconds ELSE 0 END)
+			/ NULLIF(SUM(seconds), 0)
+		FROM (
+			SELECT status, EXTRACT(EPOCH FROM
+				GREATEST(LEAD(timestamp, 1, $3) OVER (ORDER BY timestamp, id), $2) - GREATEST(timestamp, $2)) AS seconds
+			FROM service_status
+			WHERE service = $1 AND timestamp < $3 AND timestamp >= COALESCE(
+				(SELECT MAX(timestamp) FROM service_status WHERE service = $1 AND timestamp < $2), $2)
+		) spans
+	`
+
+	var avg sql.NullFloat64
+	if err := db.conn.QueryRow(query, service, from, to).Scan(&avg); err != nil {
+		return 0, false, err
+	}
+	return avg.Float64, avg.Valid, nil
+}
+
+// ServiceTransitions returns the rows at which the status of a service
+// changed, newest first. The oldest stored row counts as a transition.
+func (db *DB) ServiceTransitions(service string, limit int) ([]ServiceStatus, error) {
+	query := `
+		SELECT id, service, status, timestamp, details
+		FROM (
+			SELECT id, service, status, timestamp, COALESCE(details, '') AS details,
+				LAG(status) OVER (ORDER BY timestamp, id) AS previous
+			FROM service_status
+			WHERE service = $1
+		) changes
+		WHERE previous IS DISTINCT FROM status
+		ORDER BY timestamp DESC, id DESC
+		LIMIT $2
+	`
+
+	rows, err := db.conn.Query(query, service, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var statuses []ServiceStatus
+	for rows.Next() {
+		var s ServiceStatus
+		if err := rows.Scan(&s.ID, &s.Service, &s.Status, &s.Timestamp, &s.Details); err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, s)
+	}
+
+	return statuses, rows.Err()
+}
+
+// StatusQuery selects stored service statuses. Zero fields are not
+// filtered on.
+type StatusQuery struct {
+	Service  string
+	From, To time.Time
+	// Before returns rows with a lower ID only, for paging
+	Before int64
+	Limit  int
+}
+
+// QueryServiceStatuses returns the statuses matching q, newest first.
+func (db *DB) QueryServiceStatuses(q StatusQuery) ([]ServiceStatus, error) {
+	query := `
+		SELECT id, service, status, timestamp, COALESCE(details, '')
+		FROM service_status
+		WHERE ($1 = '' OR service = $1) AND timestamp >= $2 AND timestamp < $3
+			AND ($4 = 0 OR id < $4)
+		ORDER BY id DESC
+		LIMIT $5
+	`
+
+	rows, err := db.conn.Query(query, q.Service, q.From, q.To, q.Before, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var statuses []ServiceStatus
+	for rows.Next() {
+		var s ServiceStatus
+		if err := rows.Scan(&s.ID, &s.Service, &s.Status, &s.Timestamp, &s.Details); err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, s)
+	}
+
+	return statuses, rows.Err()
+}
+
+// SavePushState stores the last ping of a push check, replacing the
+// previous one.
+func (db *DB) SavePushState(state PushState) error {
+	query := `
+		INSERT INTO push_checks (check_name, last_ping, status, message, value)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (check_name) DO UPDATE
+		SET last_ping = EXCLUDED.last_ping, status = EXCLUDED.status, message = EXCLUDED.message, value = EXCLUDED.value
+	`
+	// TIMESTAMP has no zone, so times are stored and read back as UTC
+	_, err := db.conn.Exec(query, state.Check, state.LastPing.UTC(), state.Status, state.Message, state.Value)
+	return err
+}
+
+// GetPushStates returns the last ping of every push check by name.
+func (db *DB) GetPushStates() (map[string]PushState, error) {
+	rows, err := db.conn.Query(`SELECT check_name, last_ping, status, message, value FROM push_checks`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := make(map[string]PushState)
+	for rows.Next() {
+		var state PushState
+		var value sql.NullFloat64
+		if err := rows.Scan(&state.Check, &state.LastPing, &state.Status, &state.Message, &value); err != nil {
+			return nil, err
+		}
+		if value.Valid {
+			state.Value = &value.Float64
+		}
+		states[state.Check] = state
+	}
+
+	return states, rows.Err()
+}
+
 func (db *DB) cleanupOldData() {
 	ticker := time.NewTicker(24 * time.Hour)
 	defer ticker.Stop()
@@ -246,19 +752,35 @@ func (db *DB) Ping() error {
 	return db.conn.Ping()
 }
 
-func (db *DB) GetDatabaseSize() (int64, error) {
+// PingContext checks the connection, giving up when ctx is done.
+func (db *DB) PingContext(ctx context.Context) error {
+	return db.conn.PingContext(ctx)
+}
+
+func (db *DB) GetDatabaseSize(ctx context.Context) (int64, error) {
 	var sizeBytes int64
-	
+
 	query := `SELECT pg_database_size(current_database())`
-	
-	err := db.conn.QueryRow(query).Scan(&sizeBytes)
+
+	err := db.conn.QueryRowContext(ctx, query).Scan(&sizeBytes)
 	if err != nil {
 		return 0, fmt.Errorf("failed to get database size: %w", err)
 	}
-	
+
 	return sizeBytes, nil
 }
 
+func encodeLabels(labels map[string]string) (string, error) {
+	if len(labels) == 0 {
+		return "{}", nil
+	}
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
 func (db *DB) Close() error {
 	return db.conn.Close()
-}
\ No newline at end of file
+}
@@ -0,0 +1,164 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	sddbus "github.com/coreos/go-systemd/v22/dbus"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+// Conn is the part of the systemd D-Bus API the client uses. It is
+// implemented by *dbus.Conn from go-systemd and can be faked in tests.
+type Conn interface {
+	GetUnitPropertiesContext(ctx context.Context, unit string) (map[string]interface{}, error)
+	GetUnitTypePropertiesContext(ctx context.Context, unit string, unitType string) (map[string]interface{}, error)
+	// Connected reports whether the bus connection is still usable
+	Connected() bool
+	Close()
+}
+
+type Client struct {
+	conn Conn
+}
+
+type Unit struct {
+	Name          string
+	Description   string
+	LoadState     string
+	ActiveState   string
+	SubState      string
+	Health        types.Health
+	ActiveSince   time.Time
+	Restarts      uint32
+	MemoryCurrent uint64
+	// MemoryKnown is false when memory accounting is disabled for the unit
+	MemoryKnown bool
+	// Error is set when the unit could not be read
+	Error string
+}
+
+// NewClient connects to systemd over the system bus.
+func NewClient(ctx context.Context) (*Client, error) {
+	conn, err := sddbus.NewSystemConnectionContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to systemd: %w", err)
+	}
+	return NewClientWithConn(conn), nil
+}
+
+// NewClientWithConn creates a client on top of an existing connection.
+func NewClientWithConn(conn Conn) *Client {
+	return &Client{conn: conn}
+}
+
+// UnitName returns the full name of a unit. Names without a suffix are
+// treated as services.
+func UnitName(name string) string {
+	if !strings.Contains(name, ".") {
+		return name + ".service"
+	}
+	return name
+}
+
+// GetUnit reads the state of a single unit, see UnitName.
+func (c *Client) GetUnit(ctx context.Context, name string) (*Unit, error) {
+	name = UnitName(name)
+
+	props, err := c.conn.GetUnitPropertiesContext(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get properties of %s: %w", name, err)
+	}
+
+	unit := &Unit{
+		Name:        name,
+		Description: stringProperty(props, "Description"),
+		LoadState:   stringProperty(props, "LoadState"),
+		ActiveState: stringProperty(props, "ActiveState"),
+		SubState:    stringProperty(props, "SubState"),
+	}
+	if usec, ok := props["ActiveEnterTimestamp"].(uint64); ok && usec > 0 {
+		unit.ActiveSince = time.UnixMicro(int64(usec))
+	}
+
+	// Restart counter and memory are only exposed on the service interface
+	if strings.HasSuffix(name, ".service") && unit.LoadState == "loaded" {
+		serviceProps, err := c.conn.GetUnitTypePropertiesContext(ctx, name, "Service")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get service properties of %s: %w", name, err)
+		}
+		if restarts, ok := serviceProps["NRestarts"].(uint32); ok {
+			unit.Restarts = restarts
+		}
+		// systemd reports the maximum value when accounting is off
+		if memory, ok := serviceProps["MemoryCurrent"].(uint64); ok && memory != math.MaxUint64 {
+			unit.MemoryCurrent = memory
+			unit.MemoryKnown = true
+		}
+	}
+
+	unit.Health = UnitHealth(unit.LoadState, unit.ActiveState, unit.SubState)
+	return unit, nil
+}
+
+// GetUnits reads the state of all given units. A unit that cannot be read
+// is returned with unknown health, and its error is joined into the
+// returned one.
+func (c *Client) GetUnits(ctx context.Context, names []string) ([]Unit, error) {
+	units := make([]Unit, 0, len(names))
+	var errs []error
+	for _, name := range names {
+		unit, err := c.GetUnit(ctx, name)
+		if err != nil {
+			units = append(units, Unit{
+				Name:   UnitName(name),
+				Health: types.HealthUnknown,
+				Error:  err.Error(),
+			})
+			errs = append(errs, err)
+			continue
+		}
+		units = append(units, *unit)
+	}
+	return units, errors.Join(errs...)
+}
+
+// Connected reports whether the connection to systemd is still usable.
+func (c *Client) Connected() bool {
+	return c.conn.Connected()
+}
+
+func (c *Client) Close() {
+	c.conn.Close()
+}
+
+// UnitHealth maps systemd unit states onto the shared health model.
+func UnitHealth(loadState, activeState, subState string) types.Health {
+	if loadState == "not-found" || loadState == "error" {
+		return types.HealthUnknown
+	}
+
+	switch activeState {
+	case "active":
+		return types.HealthOperational
+	case "reloading", "activating", "deactivating", "refreshing":
+		// activating/auto-restart is a unit waiting to be restarted
+		// after it crashed
+		return types.HealthDegraded
+	case "failed", "inactive":
+		return types.HealthMajorOutage
+	case "maintenance":
+		return types.HealthMaintenance
+	}
+	return types.HealthUnknown
+}
+
+func stringProperty(props map[string]interface{}, key string) string {
+	value, _ := props[key].(string)
+	return value
+}
@@ -0,0 +1,160 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+// fakeConn serves unit properties from maps instead of D-Bus.
+type fakeConn struct {
+	units     map[string]map[string]interface{}
+	services  map[string]map[string]interface{}
+	err       error
+	connected bool
+	closed    bool
+}
+
+func (f *fakeConn) GetUnitPropertiesContext(ctx context.Context, unit string) (map[string]interface{}, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	props, ok := f.units[unit]
+	if !ok {
+		return map[string]interface{}{"LoadState": "not-found", "ActiveState": "inactive", "SubState": "dead"}, nil
+	}
+	return props, nil
+}
+
+func (f *fakeConn) GetUnitTypePropertiesContext(ctx context.Context, unit string, unitType string) (map[string]interface{}, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.services[unit], nil
+}
+
+func (f *fakeConn) Connected() bool { return f.connected }
+
+func (f *fakeConn) Close() { f.closed = true }
+
+func TestUnitName(t *testing.T) {
+	tests := map[string]string{
+		"mosquitto":          "mosquitto.service",
+		"mosquitto.service":  "mosquitto.service",
+		"backup.timer":       "backup.timer",
+		"home-assistant.svc": "home-assistant.svc",
+	}
+	for name, want := range tests {
+		if got := UnitName(name); got != want {
+			t.Errorf("UnitName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestGetUnit(t *testing.T) {
+	conn := &fakeConn{
+		connected: true,
+		units: map[string]map[string]interface{}{
+			"mosquitto.service": {
+				"Description":          "Mosquitto MQTT Broker",
+				"LoadState":            "loaded",
+				"ActiveState":          "active",
+				"SubState":             "running",
+				"ActiveEnterTimestamp": uint64(1700000000000000),
+			},
+		},
+		services: map[string]map[string]interface{}{
+			"mosquitto.service": {"NRestarts": uint32(2), "MemoryCurrent": uint64(4096)},
+		},
+	}
+
+	unit, err := NewClientWithConn(conn).GetUnit(context.Background(), "mosquitto")
+	if err != nil {
+		t.Fatalf("GetUnit: %v", err)
+	}
+	if unit.Name != "mosquitto.service" {
+		t.Errorf("Name = %q, want mosquitto.service", unit.Name)
+	}
+	if unit.Health != types.HealthOperational {
+		t.Errorf("Health = %q, want %q", unit.Health, types.HealthOperational)
+	}
+	if unit.Restarts != 2 || !unit.MemoryKnown || unit.MemoryCurrent != 4096 {
+		t.Errorf("Restarts = %d, MemoryCurrent = %d (known %v), want 2 and 4096", unit.Restarts, unit.MemoryCurrent, unit.MemoryKnown)
+	}
+	if unit.ActiveSince.UnixMicro() != 1700000000000000 {
+		t.Errorf("ActiveSince = %v", unit.ActiveSince)
+	}
+}
+
+func TestGetUnitWithoutMemoryAccounting(t *testing.T) {
+	conn := &fakeConn{
+		connected: true,
+		units: map[string]map[string]interface{}{
+			"node-red.service": {"LoadState": "loaded", "ActiveState": "failed", "SubState": "failed"},
+		},
+		services: map[string]map[string]interface{}{
+			"node-red.service": {"NRestarts": uint32(0), "MemoryCurrent": uint64(math.MaxUint64)},
+		},
+	}
+
+	unit, err := NewClientWithConn(conn).GetUnit(context.Background(), "node-red.service")
+	if err != nil {
+		t.Fatalf("GetUnit: %v", err)
+	}
+	if unit.MemoryKnown {
+		t.Errorf("MemoryKnown = true for MemoryCurrent of MaxUint64")
+	}
+	if unit.Health != types.HealthMajorOutage {
+		t.Errorf("Health = %q, want %q", unit.Health, types.HealthMajorOutage)
+	}
+}
+
+func TestGetUnitsJoinsErrors(t *testing.T) {
+	conn := &fakeConn{err: errors.New("connection reset")}
+
+	units, err := NewClientWithConn(conn).GetUnits(context.Background(), []string{"mosquitto", "backup.timer"})
+	if err == nil {
+		t.Fatal("GetUnits returned no error for failing units")
+	}
+	for _, name := range []string{"mosquitto.service", "backup.timer"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error %q does not name %s", err, name)
+		}
+	}
+	if len(units) != 2 {
+		t.Fatalf("got %d units, want 2", len(units))
+	}
+	// Failed units keep the name of units that were read
+	if units[0].Name != "mosquitto.service" || units[1].Name != "backup.timer" {
+		t.Errorf("names = %q, %q", units[0].Name, units[1].Name)
+	}
+	for _, unit := range units {
+		if unit.Health != types.HealthUnknown || unit.Error == "" {
+			t.Errorf("unit %s: Health = %q, Error = %q, want unknown with error", unit.Name, unit.Health, unit.Error)
+		}
+	}
+}
+
+func TestUnitHealth(t *testing.T) {
+	tests := []struct {
+		load, active, sub string
+		want              types.Health
+	}{
+		{"loaded", "active", "running", types.HealthOperational},
+		{"loaded", "activating", "auto-restart", types.HealthDegraded},
+		{"loaded", "failed", "failed", types.HealthMajorOutage},
+		{"loaded", "inactive", "dead", types.HealthMajorOutage},
+		{"loaded", "maintenance", "", types.HealthMaintenance},
+		{"not-found", "inactive", "dead", types.HealthUnknown},
+		{"error", "active", "running", types.HealthUnknown},
+	}
+	for _, tt := range tests {
+		if got := UnitHealth(tt.load, tt.active, tt.sub); got != tt.want {
+			t.Errorf("UnitHealth(%q, %q, %q) = %q, want %q", tt.load, tt.active, tt.sub, got, tt.want)
+		}
+	}
+}
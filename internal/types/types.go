@@ -1,31 +1,448 @@
 package types
 
-import "time"
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Health is the normalised state of a monitored service, independent of the
+// source that reported it.
+type Health string
+
+const (
+	HealthOperational   Health = "operational"
+	HealthDegraded      Health = "degraded"
+	HealthPartialOutage Health = "partial_outage"
+	HealthMajorOutage   Health = "major_outage"
+	HealthMaintenance   Health = "maintenance"
+	HealthUnknown       Health = "unknown"
+)
+
+// ParseHealth converts a stored status string back into a Health value.
+// Rows written before the health model existed used UP/DOWN.
+func ParseHealth(s string) Health {
+	switch Health(s) {
+	case HealthOperational, HealthDegraded, HealthPartialOutage, HealthMajorOutage, HealthMaintenance, HealthUnknown:
+		return Health(s)
+	}
+	switch s {
+	case "UP":
+		return HealthOperational
+	case "DOWN":
+		return HealthMajorOutage
+	}
+	return HealthUnknown
+}
+
+// IsOutage reports whether the service is at least partially unavailable.
+func (h Health) IsOutage() bool {
+	return h == HealthPartialOutage || h == HealthMajorOutage
+}
+
+// Label returns the human readable name of the health state.
+func (h Health) Label() string {
+	switch h {
+	case HealthOperational:
+		return "Operational"
+	case HealthDegraded:
+		return "Degraded"
+	case HealthPartialOutage:
+		return "Partial Outage"
+	case HealthMajorOutage:
+		return "Major Outage"
+	case HealthMaintenance:
+		return "Maintenance"
+	}
+	return "Unknown"
+}
 
 type ServiceStatus struct {
-	Name        string    `json:"name"`
-	Status      string    `json:"status"`
-	Healthy     bool      `json:"healthy"`
-	LastChange  string    `json:"last_change"`
-	Uptime      string    `json:"uptime"`
-	Details     string    `json:"details,omitempty"`
+	// ID is the key the service is stored under, see ServiceKey. It is set
+	// by the collector.
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Group       string `json:"group,omitempty"`
+	// Host is set when services with the same name can run on several hosts
+	Host       string         `json:"host,omitempty"`
+	Status     string         `json:"status"`
+	Health     Health         `json:"health"`
+	LastChange string         `json:"last_change"`
+	Uptime     string         `json:"uptime"`
+	Details    string         `json:"details,omitempty"`
+	Container  *ContainerInfo `json:"container,omitempty"`
+	Unit       *UnitInfo      `json:"unit,omitempty"`
+}
+
+// MarshalJSON adds the deprecated healthy flag, which clients of
+// /api/status read before services had a health state. It is true only
+// while the service is operational.
+func (s ServiceStatus) MarshalJSON() ([]byte, error) {
+	type service ServiceStatus
+	return json.Marshal(struct {
+		service
+		Healthy bool `json:"healthy"`
+	}{service(s), s.Health == HealthOperational})
+}
+
+// Title returns the name to show for the service.
+func (s ServiceStatus) Title() string {
+	if s.DisplayName != "" {
+		return s.DisplayName
+	}
+	return s.Name
+}
+
+// QualifiedName returns the name of the service including its host.
+func (s ServiceStatus) QualifiedName() string {
+	return QualifiedName(s.Host, s.Name)
+}
+
+// QualifiedName namespaces name by host, if there is one.
+func QualifiedName(host, name string) string {
+	if host == "" {
+		return name
+	}
+	return host + "/" + name
+}
+
+// DeviceStatus is the liveness of a device tracked by its MQTT heartbeats.
+type DeviceStatus struct {
+	Name     string    `json:"name"`
+	Online   bool      `json:"online"`
+	LastSeen time.Time `json:"last_seen"`
+	Details  string    `json:"details,omitempty"`
+}
+
+// HostConnection is the connectivity of a host or backend a source talks
+// to, e.g. the database or a pinged host.
+type HostConnection struct {
+	Name      string `json:"name"`
+	Connected bool   `json:"connected"`
+	// Address is shown next to the name, e.g. the pinged IP
+	Address string `json:"address,omitempty"`
+	// Icon is the Font Awesome class of the dashboard card
+	Icon string `json:"icon,omitempty"`
+}
+
+// UnitInfo holds the systemd specific details of a service.
+type UnitInfo struct {
+	ActiveState   string `json:"active_state"`
+	SubState      string `json:"sub_state"`
+	Restarts      uint32 `json:"restarts"`
+	MemoryCurrent uint64 `json:"memory_current,omitempty"`
+}
+
+// ContainerInfo holds the Docker specific details of a service.
+type ContainerInfo struct {
+	Image        string  `json:"image"`
+	RestartCount int     `json:"restart_count"`
+	ExitCode     int     `json:"exit_code"`
+	OOMKilled    bool    `json:"oom_killed"`
+	CPUPercent   float64 `json:"cpu_percent"`
+	MemoryUsed   uint64  `json:"memory_used"`
+	MemoryLimit  uint64  `json:"memory_limit"`
+	NetworkIn    float64 `json:"network_in"`
+	NetworkOut   float64 `json:"network_out"`
+}
+
+// ContainerDetails is the inspected state of a container, shown on the
+// page of its service.
+type ContainerDetails struct {
+	ID            string    `json:"id"`
+	Image         string    `json:"image"`
+	ImageID       string    `json:"image_id"`
+	Command       string    `json:"command,omitempty"`
+	Created       time.Time `json:"created"`
+	StartedAt     time.Time `json:"started_at,omitempty"`
+	FinishedAt    time.Time `json:"finished_at,omitempty"`
+	RestartCount  int       `json:"restart_count"`
+	RestartPolicy string    `json:"restart_policy,omitempty"`
+	// HealthStatus is empty for containers without a health check
+	HealthStatus  string        `json:"health_status,omitempty"`
+	FailingStreak int           `json:"failing_streak,omitempty"`
+	HealthLog     []HealthCheck `json:"health_log,omitempty"`
+}
+
+// HealthCheck is the result of one run of a container health check.
+type HealthCheck struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	ExitCode int       `json:"exit_code"`
+	Output   string    `json:"output"`
+}
+
+// PiTelemetry holds the temperature, clock and power state of a Raspberry Pi.
+type PiTelemetry struct {
+	Temperatures    []Temperature `json:"temperatures"`
+	CPUFrequencyMHz float64       `json:"cpu_frequency_mhz"`
+	// FanRPM is only set when a fan tachometer is exposed
+	FanRPM *float64 `json:"fan_rpm,omitempty"`
+	// Throttled is nil when vcgencmd is not available
+	Throttled *ThrottledState `json:"throttled,omitempty"`
+}
+
+// Temperature is the reading of a thermal zone.
+type Temperature struct {
+	Zone    string  `json:"zone"`
+	Celsius float64 `json:"celsius"`
+}
+
+// ThrottledState is the decoded output of vcgencmd get_throttled. The
+// *Occurred flags are sticky since boot.
+type ThrottledState struct {
+	Raw                     uint32 `json:"raw"`
+	UnderVoltage            bool   `json:"under_voltage"`
+	FrequencyCapped         bool   `json:"frequency_capped"`
+	Throttled               bool   `json:"throttled"`
+	SoftTempLimit           bool   `json:"soft_temp_limit"`
+	UnderVoltageOccurred    bool   `json:"under_voltage_occurred"`
+	FrequencyCappedOccurred bool   `json:"frequency_capped_occurred"`
+	ThrottledOccurred       bool   `json:"throttled_occurred"`
+	SoftTempLimitOccurred   bool   `json:"soft_temp_limit_occurred"`
+}
+
+// MaxTemperature returns the hottest thermal zone, usually the SoC.
+func (t PiTelemetry) MaxTemperature() float64 {
+	max := 0.0
+	for _, temp := range t.Temperatures {
+		if temp.Celsius > max {
+			max = temp.Celsius
+		}
+	}
+	return max
 }
 
+// Summary describes the power and throttling state in a few words.
+func (s ThrottledState) Summary() string {
+	var active []string
+	if s.UnderVoltage {
+		active = append(active, "Under-voltage")
+	}
+	if s.Throttled {
+		active = append(active, "Throttled")
+	} else if s.FrequencyCapped {
+		active = append(active, "Frequency capped")
+	}
+	if s.SoftTempLimit {
+		active = append(active, "Soft temp limit")
+	}
+	if len(active) > 0 {
+		return strings.Join(active, ", ")
+	}
+	switch {
+	case s.UnderVoltageOccurred:
+		return "OK (under-voltage since boot)"
+	case s.ThrottledOccurred || s.FrequencyCappedOccurred:
+		return "OK (throttled since boot)"
+	}
+	return "OK"
+}
+
+// MountStatus is the usage and I/O of a mounted filesystem.
+type MountStatus struct {
+	Mountpoint    string  `json:"mountpoint"`
+	Device        string  `json:"device"`
+	Fstype        string  `json:"fstype"`
+	Total         uint64  `json:"total"`
+	Used          uint64  `json:"used"`
+	UsedPercent   float64 `json:"used_percent"`
+	InodesPercent float64 `json:"inodes_percent"`
+	// ReadOnly is set when the filesystem is mounted read-only, typically
+	// after the kernel remounted a failing SD card
+	ReadOnly bool `json:"read_only"`
+	// Error is set when the mount could not be read
+	Error string `json:"error,omitempty"`
+	// Throughput in bytes per second and average latency per operation in
+	// milliseconds since the previous collection
+	ReadRate       float64   `json:"read_rate"`
+	WriteRate      float64   `json:"write_rate"`
+	ReadLatencyMs  float64   `json:"read_latency_ms"`
+	WriteLatencyMs float64   `json:"write_latency_ms"`
+	Wear           *DiskWear `json:"wear,omitempty"`
+}
+
+// DiskWear holds the wear indicators of the disk behind a mount.
+type DiskWear struct {
+	// Source is emmc or smart
+	Source          string   `json:"source"`
+	LifeUsedPercent *float64 `json:"life_used_percent,omitempty"`
+	// PreEOL is the eMMC pre end of life state: normal, warning or urgent
+	PreEOL      string `json:"pre_eol,omitempty"`
+	SMARTPassed *bool  `json:"smart_passed,omitempty"`
+}
+
+// InterfaceStats holds the per second rates of a network interface.
+type InterfaceStats struct {
+	Name     string  `json:"name"`
+	RxRate   float64 `json:"rx_rate"`
+	TxRate   float64 `json:"tx_rate"`
+	RxErrors float64 `json:"rx_errors"`
+	TxErrors float64 `json:"tx_errors"`
+	RxDrops  float64 `json:"rx_drops"`
+	TxDrops  float64 `json:"tx_drops"`
+}
+
+// WANQuality is the result of the latest internet connectivity check.
+type WANQuality struct {
+	Target      string    `json:"target"`
+	LatencyMs   float64   `json:"latency_ms"`
+	JitterMs    float64   `json:"jitter_ms"`
+	LossPercent float64   `json:"loss_percent"`
+	DNSName     string    `json:"dns_name"`
+	DNSMs       float64   `json:"dns_ms"`
+	DNSError    string    `json:"dns_error,omitempty"`
+	CheckedAt   time.Time `json:"checked_at"`
+}
+
+// ProcessInfo is a process in the top processes snapshot.
+type ProcessInfo struct {
+	PID        int32   `json:"pid"`
+	Name       string  `json:"name"`
+	Username   string  `json:"username,omitempty"`
+	CPUPercent float64 `json:"cpu_percent"`
+	RSS        uint64  `json:"rss"`
+	Threads    int32   `json:"threads"`
+	Cmdline    string  `json:"cmdline,omitempty"`
+}
+
+// ProcessSnapshot lists the busiest processes at one point in time. CPU
+// usage is measured since the previous snapshot.
+type ProcessSnapshot struct {
+	Total      int           `json:"total"`
+	Running    int           `json:"running"`
+	Threads    int           `json:"threads"`
+	TopCPU     []ProcessInfo `json:"top_cpu"`
+	TopMemory  []ProcessInfo `json:"top_memory"`
+	CapturedAt time.Time     `json:"captured_at"`
+}
+
+// SourceStatus is the schedule and outcome of the latest run of a
+// collection source.
+type SourceStatus struct {
+	Name       string    `json:"name"`
+	IntervalMs float64   `json:"interval_ms"`
+	TimeoutMs  float64   `json:"timeout_ms"`
+	LastRun    time.Time `json:"last_run"`
+	DurationMs float64   `json:"duration_ms"`
+	// Failing is set when the latest run returned an error
+	Failing     bool      `json:"failing"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at,omitempty"`
+}
+
+// SystemMetrics holds the structured readings of the built-in sources that
+// have dedicated dashboard sections. Everything else is carried as typed
+// metrics in SourceReading.
 type SystemMetrics struct {
-	CPUPercent       float64
-	MemoryPercent    float64
-	MemoryUsed       uint64
-	MemoryTotal      uint64
-	DiskPercent      float64
-	DiskUsed         uint64
-	DiskTotal        uint64
-	NetworkIn        float64
-	NetworkOut       float64
-	Uptime           time.Duration
-	DatabaseSize     int64
-	DatabaseConnected bool
-	HAProxyConnected bool
-	DockerConnected  bool
-	Pi5Connected     bool
-	Pi52Connected    bool
-}
\ No newline at end of file
+	// Pi is nil on hosts without thermal zones
+	Pi         *PiTelemetry
+	Mounts     []MountStatus
+	Interfaces []InterfaceStats
+	WAN        *WANQuality
+	Processes  *ProcessSnapshot
+}
+
+// Unit is the unit of a metric value. It decides how the value is
+// formatted.
+type Unit string
+
+const (
+	UnitNone           Unit = ""
+	UnitPercent        Unit = "percent"
+	UnitBytes          Unit = "bytes"
+	UnitBytesPerSecond Unit = "bytes_per_second"
+	UnitPerSecond      Unit = "per_second"
+	UnitSeconds        Unit = "seconds"
+	UnitMilliseconds   Unit = "milliseconds"
+)
+
+// Metric is a typed reading of a source. Every metric is stored; metrics
+// with a title are also shown on the dashboard and published to MQTT.
+type Metric struct {
+	// Name is stored as metric type, e.g. cpu or container_memory_used
+	Name   string            `json:"name"`
+	Value  float64           `json:"value"`
+	Unit   Unit              `json:"unit,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Title  string            `json:"title,omitempty"`
+	// Detail is a secondary line of the dashboard card, e.g. used of total
+	Detail string `json:"detail,omitempty"`
+	// Icon is the Font Awesome class of the dashboard card
+	Icon string `json:"icon,omitempty"`
+}
+
+// Key identifies the series of the metric: its name and labels.
+func (m Metric) Key() string {
+	if len(m.Labels) == 0 {
+		return m.Name
+	}
+	names := make([]string, 0, len(m.Labels))
+	for name := range m.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	key := m.Name
+	for _, name := range names {
+		key += "_" + m.Labels[name]
+	}
+	return key
+}
+
+// SourceReading is the latest result of a collection source.
+type SourceReading struct {
+	Source      string           `json:"source"`
+	Metrics     []Metric         `json:"metrics,omitempty"`
+	Services    []ServiceStatus  `json:"services,omitempty"`
+	Connections []HostConnection `json:"connections,omitempty"`
+}
+
+// Snapshot is the collected state at one point in time.
+type Snapshot struct {
+	Time time.Time
+	// Readings are the latest results of all sources in registration order
+	Readings []SourceReading
+	// System holds the host details that are not reported as metrics
+	System SystemMetrics
+}
+
+// Services returns the services reported by all sources.
+func (s Snapshot) Services() []ServiceStatus {
+	var services []ServiceStatus
+	for _, reading := range s.Readings {
+		services = append(services, reading.Services...)
+	}
+	return services
+}
+
+// DisplayMetrics returns the metrics of all sources that are shown on the
+// dashboard.
+func (s Snapshot) DisplayMetrics() []Metric {
+	var metrics []Metric
+	for _, reading := range s.Readings {
+		for _, metric := range reading.Metrics {
+			if metric.Title != "" {
+				metrics = append(metrics, metric)
+			}
+		}
+	}
+	return metrics
+}
+
+// Connections returns the connections reported by all sources.
+func (s Snapshot) Connections() []HostConnection {
+	var connections []HostConnection
+	for _, reading := range s.Readings {
+		connections = append(connections, reading.Connections...)
+	}
+	return connections
+}
+
+// ServiceKey is the service_status key of a service reported by source,
+// e.g. haproxy_web or docker_nas/grafana.
+func ServiceKey(source string, service ServiceStatus) string {
+	return source + "_" + service.QualifiedName()
+}
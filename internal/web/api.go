@@ -0,0 +1,533 @@
+package web
+
+import (
+	_ "embed"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hra42/iot-hub-statuspage/internal/storage"
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+//go:embed openapi.json
+var openAPI []byte
+
+const (
+	// defaultPageSize and maxPageSize bound the rows of a paged response
+	defaultPageSize = 100
+	maxPageSize     = 1000
+	// defaultRange is the time range of history queries without from
+	defaultRange = 24 * time.Hour
+	// defaultPoints is the number of buckets a metric query returns per
+	// series when no step is given, maxPoints the most it may return.
+	defaultPoints = 300
+	maxPoints     = 10000
+	// minStep is the collection interval of the fastest sources
+	minStep = 5 * time.Second
+)
+
+// Error codes of the v1 API.
+const (
+	codeInvalidParameter = "invalid_parameter"
+	codeNotFound         = "not_found"
+	codeUnavailable      = "unavailable"
+	codeInternal         = "internal"
+)
+
+// APIError is the body of every failed v1 request.
+type APIError struct {
+	Error APIErrorDetail `json:"error"`
+}
+
+type APIErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Parameter names the query parameter that was rejected
+	Parameter string `json:"parameter,omitempty"`
+}
+
+// Page is a page of rows. NextCursor is passed as cursor to get the next
+// page and is empty on the last one.
+type Page[T any] struct {
+	Data       []T    `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// ServiceList holds the current status of the services.
+type ServiceList struct {
+	Services []types.ServiceStatus `json:"services"`
+}
+
+// StatusRecord is a stored status of a service.
+type StatusRecord struct {
+	Service   string       `json:"service"`
+	Health    types.Health `json:"health"`
+	Details   string       `json:"details,omitempty"`
+	Timestamp time.Time    `json:"timestamp"`
+}
+
+// MetricSample is a stored value of a metric.
+type MetricSample struct {
+	Metric    string            `json:"metric"`
+	Value     float64           `json:"value"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// MetricInfo describes a stored metric type.
+type MetricInfo struct {
+	Name string `json:"name"`
+	// Unit is known for metrics collected since the last start
+	Unit types.Unit `json:"unit,omitempty"`
+	// LabelNames are listed when a single metric type is requested
+	LabelNames []string  `json:"label_names,omitempty"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+// MetricList holds the stored metric types.
+type MetricList struct {
+	Metrics []MetricInfo `json:"metrics"`
+}
+
+// MetricQueryResult holds the bucketed series of a metric query.
+type MetricQueryResult struct {
+	From        time.Time      `json:"from"`
+	To          time.Time      `json:"to"`
+	StepSeconds float64        `json:"step_seconds"`
+	Aggregation string         `json:"aggregation"`
+	Series      []MetricSeries `json:"series"`
+}
+
+// MetricSeries is the bucketed values of a metric with one set of labels.
+type MetricSeries struct {
+	Metric string            `json:"metric"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Unit   types.Unit        `json:"unit,omitempty"`
+	Points []MetricPoint     `json:"points"`
+}
+
+// MetricPoint is the aggregated value of the bucket starting at Timestamp.
+type MetricPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// HealthResponse reports whether the status page and its dependencies work.
+type HealthResponse struct {
+	Status  string            `json:"status"`
+	Details map[string]string `json:"details"`
+}
+
+// paramError is a rejected query parameter.
+type paramError struct {
+	param   string
+	message string
+}
+
+func (e *paramError) Error() string {
+	return e.message
+}
+
+func (s *Server) setupAPIRoutes() {
+	v1 := s.router.Group("/api/v1")
+	v1.GET("/openapi.json", s.handleOpenAPI)
+	v1.GET("/status", s.handleV1Status)
+	v1.GET("/services", s.handleV1Services)
+	v1.GET("/services/history", s.handleV1ServiceHistory)
+	v1.GET("/metrics", s.handleV1Metrics)
+	v1.GET("/metrics/query", s.handleV1MetricQuery)
+	v1.GET("/metrics/samples", s.handleV1MetricSamples)
+	v1.GET("/sources", s.handleV1Sources)
+	v1.GET("/processes", s.handleV1Processes)
+	v1.GET("/health", s.handleV1Health)
+
+	s.router.NoRoute(func(c *gin.Context) {
+		if strings.HasPrefix(c.Request.URL.Path, "/api/v1/") {
+			apiError(c, http.StatusNotFound, codeNotFound, "no such endpoint")
+		}
+	})
+}
+
+// apiError aborts the request with the error envelope.
+func apiError(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, APIError{Error: APIErrorDetail{Code: code, Message: message}})
+}
+
+// apiParamError aborts the request for a rejected query parameter.
+func apiParamError(c *gin.Context, err *paramError) {
+	c.AbortWithStatusJSON(http.StatusBadRequest, APIError{Error: APIErrorDetail{
+		Code:      codeInvalidParameter,
+		Message:   err.Error(),
+		Parameter: err.param,
+	}})
+}
+
+func (s *Server) handleOpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", openAPI)
+}
+
+func (s *Server) handleV1Status(c *gin.Context) {
+	status, err := s.getCurrentStatus()
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, codeInternal, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// handleV1Services returns the current services, optionally filtered by
+// group and health.
+func (s *Server) handleV1Services(c *gin.Context) {
+	group, health := c.Query("group"), c.Query("health")
+	if health != "" && types.ParseHealth(health) != types.Health(health) {
+		apiParamError(c, &paramError{"health", fmt.Sprintf("unknown health %q", health)})
+		return
+	}
+
+	services := make([]types.ServiceStatus, 0)
+	for _, service := range s.collector.GetServices() {
+		if group != "" && service.Group != group {
+			continue
+		}
+		if health != "" && string(service.Health) != health {
+			continue
+		}
+		services = append(services, service)
+	}
+	c.JSON(http.StatusOK, ServiceList{Services: services})
+}
+
+// handleV1ServiceHistory pages through the stored statuses, optionally of
+// one service.
+func (s *Server) handleV1ServiceHistory(c *gin.Context) {
+	from, to, perr := timeRange(c)
+	if perr != nil {
+		apiParamError(c, perr)
+		return
+	}
+	before, limit, perr := pageParams(c)
+	if perr != nil {
+		apiParamError(c, perr)
+		return
+	}
+
+	rows, err := s.db.QueryServiceStatuses(storage.StatusQuery{
+		Service: c.Query("service"),
+		From:    from,
+		To:      to,
+		Before:  before,
+		Limit:   limit,
+	})
+	if err != nil {
+		log.Printf("Failed to query service statuses: %v", err)
+		apiError(c, http.StatusInternalServerError, codeInternal, "failed to query service statuses")
+		return
+	}
+
+	page := Page[StatusRecord]{Data: make([]StatusRecord, 0, len(rows))}
+	for _, row := range rows {
+		page.Data = append(page.Data, StatusRecord{
+			Service:   row.Service,
+			Health:    types.ParseHealth(row.Status),
+			Details:   row.Details,
+			Timestamp: row.Timestamp,
+		})
+	}
+	if len(rows) == limit {
+		page.NextCursor = strconv.FormatInt(rows[len(rows)-1].ID, 10)
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// handleV1MetricSamples pages through the stored values of a metric,
+// optionally filtered by label=name:value.
+func (s *Server) handleV1MetricSamples(c *gin.Context) {
+	metric := c.Query("metric")
+	if metric == "" {
+		apiParamError(c, &paramError{"metric", "metric is required"})
+		return
+	}
+	from, to, perr := timeRange(c)
+	if perr != nil {
+		apiParamError(c, perr)
+		return
+	}
+	before, limit, perr := pageParams(c)
+	if perr != nil {
+		apiParamError(c, perr)
+		return
+	}
+	labels, perr := labelParams(c)
+	if perr != nil {
+		apiParamError(c, perr)
+		return
+	}
+
+	rows, err := s.db.QueryMetrics(storage.MetricQuery{
+		MetricType: metric,
+		From:       from,
+		To:         to,
+		Labels:     labels,
+		Before:     before,
+		Limit:      limit,
+	})
+	if err != nil {
+		log.Printf("Failed to query %s: %v", metric, err)
+		apiError(c, http.StatusInternalServerError, codeInternal, "failed to query metrics")
+		return
+	}
+
+	page := Page[MetricSample]{Data: make([]MetricSample, 0, len(rows))}
+	for _, row := range rows {
+		page.Data = append(page.Data, MetricSample{
+			Metric:    row.MetricType,
+			Value:     row.Value,
+			Labels:    row.Labels,
+			Timestamp: row.Timestamp,
+		})
+	}
+	if len(rows) == limit {
+		page.NextCursor = strconv.FormatInt(rows[len(rows)-1].ID, 10)
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// handleV1Metrics lists the stored metric types, or a single one with its
+// label names.
+func (s *Server) handleV1Metrics(c *gin.Context) {
+	var stored []storage.MetricType
+	if name := c.Query("metric"); name != "" {
+		metric, err := s.db.GetMetricType(name)
+		if err != nil {
+			log.Printf("Failed to read metric type %s: %v", name, err)
+			apiError(c, http.StatusInternalServerError, codeInternal, "failed to list metrics")
+			return
+		}
+		if metric == nil {
+			apiError(c, http.StatusNotFound, codeNotFound, fmt.Sprintf("no stored metric %q", name))
+			return
+		}
+		stored = append(stored, *metric)
+	} else {
+		var err error
+		if stored, err = s.db.ListMetricTypes(); err != nil {
+			log.Printf("Failed to list metric types: %v", err)
+			apiError(c, http.StatusInternalServerError, codeInternal, "failed to list metrics")
+			return
+		}
+	}
+
+	units := s.metricUnits()
+	list := MetricList{Metrics: make([]MetricInfo, 0, len(stored))}
+	for _, metric := range stored {
+		list.Metrics = append(list.Metrics, MetricInfo{
+			Name:       metric.Name,
+			Unit:       units[metric.Name],
+			LabelNames: metric.LabelNames,
+			LastSeen:   metric.LastSeen,
+		})
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// handleV1MetricQuery aggregates one or more metrics into buckets of step.
+// Without a step the range is split into about 300 buckets.
+func (s *Server) handleV1MetricQuery(c *gin.Context) {
+	var metricTypes []string
+	for _, value := range c.QueryArray("metric") {
+		for _, metric := range strings.Split(value, ",") {
+			if metric = strings.TrimSpace(metric); metric != "" {
+				metricTypes = append(metricTypes, metric)
+			}
+		}
+	}
+	if len(metricTypes) == 0 {
+		apiParamError(c, &paramError{"metric", "metric is required"})
+		return
+	}
+	from, to, perr := timeRange(c)
+	if perr != nil {
+		apiParamError(c, perr)
+		return
+	}
+	labels, perr := labelParams(c)
+	if perr != nil {
+		apiParamError(c, perr)
+		return
+	}
+	agg := c.DefaultQuery("agg", "avg")
+	if !storage.ValidAggregation(agg) {
+		apiParamError(c, &paramError{"agg", fmt.Sprintf("unknown aggregation %q, expected avg, min, max or p95", agg)})
+		return
+	}
+
+	span := to.Sub(from)
+	step := max((span / defaultPoints).Round(time.Second), minStep)
+	if value := c.Query("step"); value != "" {
+		var err error
+		if step, err = time.ParseDuration(value); err != nil || step < time.Second {
+			apiParamError(c, &paramError{"step", "step must be a duration of at least 1s"})
+			return
+		}
+	}
+	if span/step > maxPoints {
+		apiParamError(c, &paramError{"step", fmt.Sprintf("step is too small, the range would have more than %d buckets", maxPoints)})
+		return
+	}
+
+	series, err := s.queryMetricSeries(storage.AggregateQuery{
+		MetricTypes: metricTypes,
+		From:        from,
+		To:          to,
+		Labels:      labels,
+		Step:        step,
+		Aggregation: agg,
+	})
+	if err != nil {
+		apiError(c, http.StatusInternalServerError, codeInternal, "failed to query metrics")
+		return
+	}
+
+	c.JSON(http.StatusOK, MetricQueryResult{
+		From:        from,
+		To:          to,
+		StepSeconds: step.Seconds(),
+		Aggregation: agg,
+		Series:      series,
+	})
+}
+
+// queryMetricSeries runs an aggregate query and adds the units of the
+// metrics. It backs the metric query API and the dashboard charts.
+func (s *Server) queryMetricSeries(q storage.AggregateQuery) ([]MetricSeries, error) {
+	stored, err := s.db.AggregateMetrics(q)
+	if err != nil {
+		log.Printf("Failed to query %s: %v", strings.Join(q.MetricTypes, ", "), err)
+		return nil, err
+	}
+
+	units := s.metricUnits()
+	series := make([]MetricSeries, 0, len(stored))
+	for _, row := range stored {
+		points := make([]MetricPoint, 0, len(row.Points))
+		for _, point := range row.Points {
+			points = append(points, MetricPoint{Timestamp: point.Timestamp, Value: point.Value})
+		}
+		series = append(series, MetricSeries{
+			Metric: row.MetricType,
+			Labels: row.Labels,
+			Unit:   units[row.MetricType],
+			Points: points,
+		})
+	}
+	return series, nil
+}
+
+// metricUnits returns the units of the metrics of the latest readings.
+func (s *Server) metricUnits() map[string]types.Unit {
+	units := make(map[string]types.Unit)
+	for _, reading := range s.collector.GetReadings() {
+		for _, metric := range reading.Metrics {
+			if metric.Unit != types.UnitNone {
+				units[metric.Name] = metric.Unit
+			}
+		}
+	}
+	return units
+}
+
+func (s *Server) handleV1Sources(c *gin.Context) {
+	c.JSON(http.StatusOK, s.collector.GetSourceStatus())
+}
+
+func (s *Server) handleV1Processes(c *gin.Context) {
+	processes := s.collector.GetProcesses()
+	if processes == nil {
+		apiError(c, http.StatusServiceUnavailable, codeUnavailable, "no process snapshot yet")
+		return
+	}
+	c.JSON(http.StatusOK, processes)
+}
+
+func (s *Server) handleV1Health(c *gin.Context) {
+	health := s.checkHealth(c.Request.Context())
+	status := http.StatusOK
+	if health.Status != "healthy" {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, health)
+}
+
+// timeRange reads the from and to parameters. Both are RFC 3339 times or
+// durations before now, e.g. from=24h. to defaults to now and from to 24
+// hours before to.
+func timeRange(c *gin.Context) (time.Time, time.Time, *paramError) {
+	now := time.Now()
+	to := now
+	if value := c.Query("to"); value != "" {
+		t, err := parseTime(value, now)
+		if err != nil {
+			return time.Time{}, time.Time{}, &paramError{"to", "invalid to: " + err.Error()}
+		}
+		to = t
+	}
+	from := to.Add(-defaultRange)
+	if value := c.Query("from"); value != "" {
+		t, err := parseTime(value, now)
+		if err != nil {
+			return time.Time{}, time.Time{}, &paramError{"from", "invalid from: " + err.Error()}
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, &paramError{"from", "from must be before to"}
+	}
+	return from, to, nil
+}
+
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 time nor a duration", value)
+}
+
+// labelParams reads the label filters, given as label=name:value.
+func labelParams(c *gin.Context) (map[string]string, *paramError) {
+	labels := make(map[string]string)
+	for _, label := range c.QueryArray("label") {
+		name, value, found := strings.Cut(label, ":")
+		if !found || name == "" {
+			return nil, &paramError{"label", fmt.Sprintf("label %q is not name:value", label)}
+		}
+		labels[name] = value
+	}
+	return labels, nil
+}
+
+// pageParams reads the cursor and limit parameters.
+func pageParams(c *gin.Context) (int64, int, *paramError) {
+	var before int64
+	if cursor := c.Query("cursor"); cursor != "" {
+		var err error
+		if before, err = strconv.ParseInt(cursor, 10, 64); err != nil || before <= 0 {
+			return 0, 0, &paramError{"cursor", "malformed cursor"}
+		}
+	}
+	limit := defaultPageSize
+	if value := c.Query("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > maxPageSize {
+			return 0, 0, &paramError{"limit", fmt.Sprintf("limit must be between 1 and %d", maxPageSize)}
+		}
+	}
+	return before, limit, nil
+}
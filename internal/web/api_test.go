@@ -0,0 +1,325 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/hra42/iot-hub-statuspage/internal/storage"
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+// loadSpec parses and validates the embedded OpenAPI document.
+func loadSpec(t *testing.T) *openapi3.T {
+	t.Helper()
+	loader := openapi3.NewLoader()
+	spec, err := loader.LoadFromData(openAPI)
+	if err != nil {
+		t.Fatalf("Failed to load openapi.json: %v", err)
+	}
+	if err := spec.Validate(loader.Context); err != nil {
+		t.Fatalf("openapi.json is invalid: %v", err)
+	}
+	return spec
+}
+
+// checkContract checks that the response of a v1 request is documented
+// with its status code and that the body matches the documented schema.
+func checkContract(t *testing.T, spec *openapi3.T, target string, recorder *httptest.ResponseRecorder) {
+	t.Helper()
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := strings.TrimPrefix(u.Path, "/api/v1")
+
+	var schema *openapi3.Schema
+	if item := spec.Paths.Find(path); item != nil && item.Get != nil {
+		response := item.Get.Responses.Status(recorder.Code)
+		if response == nil {
+			t.Fatalf("GET %s responded %d, which is not documented", target, recorder.Code)
+		}
+		schema = response.Value.Content.Get("application/json").Schema.Value
+	} else {
+		// Unknown endpoints respond with the error envelope
+		schema = spec.Components.Schemas["Error"].Value
+	}
+
+	if contentType := recorder.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+		t.Errorf("GET %s has content type %q, want application/json", target, contentType)
+	}
+	var body any
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("GET %s returned invalid JSON: %v", target, err)
+	}
+	if err := schema.VisitJSON(body, openapi3.MultiErrors()); err != nil {
+		t.Errorf("GET %s does not match the schema of %d: %v\nbody: %s", target, recorder.Code, err, recorder.Body)
+	}
+}
+
+// call sends a v1 request, checks the response against the spec and
+// decodes it into v.
+func call(t *testing.T, spec *openapi3.T, s *Server, target string, wantStatus int, v any) {
+	t.Helper()
+	recorder := get(s, target)
+	if recorder.Code != wantStatus {
+		t.Fatalf("GET %s = %d, want %d: %s", target, recorder.Code, wantStatus, recorder.Body)
+	}
+	checkContract(t, spec, target, recorder)
+	if v != nil {
+		if err := json.Unmarshal(recorder.Body.Bytes(), v); err != nil {
+			t.Fatalf("Failed to decode %s: %v", target, err)
+		}
+	}
+}
+
+// testStore returns a store with five statuses of one service and five
+// samples of a metric of two containers, one per minute.
+func testStore() *fakeStore {
+	db := &fakeStore{}
+	start := time.Now().Add(-time.Hour)
+	for i := range 5 {
+		timestamp := start.Add(time.Duration(i) * time.Minute)
+		health := types.HealthOperational
+		if i == 2 {
+			health = types.HealthMajorOutage
+		}
+		db.statuses = append(db.statuses, storage.ServiceStatus{
+			ID:        int64(i + 1),
+			Service:   "docker_mosquitto",
+			Status:    string(health),
+			Timestamp: timestamp,
+		})
+		container := []string{"mosquitto", "grafana"}[i%2]
+		db.metrics = append(db.metrics, storage.SystemMetric{
+			ID:         int64(i + 1),
+			MetricType: "container_cpu",
+			Value:      float64(i),
+			Timestamp:  timestamp,
+			Labels:     map[string]string{"container": container},
+		})
+	}
+	return db
+}
+
+func TestAPIRoutesDocumented(t *testing.T) {
+	spec := loadSpec(t)
+	s := newTestServer(t, &fakeStore{}, newFakeCollector())
+
+	var routes []string
+	for _, route := range s.Router().Routes() {
+		if path, ok := strings.CutPrefix(route.Path, "/api/v1"); ok {
+			routes = append(routes, route.Method+" "+path)
+		}
+	}
+	var documented []string
+	for path, item := range spec.Paths.Map() {
+		for method := range item.Operations() {
+			documented = append(documented, method+" "+path)
+		}
+	}
+	slices.Sort(routes)
+	slices.Sort(documented)
+	if !slices.Equal(routes, documented) {
+		t.Errorf("routes %q do not match the documented operations %q", routes, documented)
+	}
+}
+
+func TestAPIContract(t *testing.T) {
+	spec := loadSpec(t)
+	collector := newFakeCollector()
+	collector.publish(testSnapshot("docker",
+		types.ServiceStatus{Name: "mosquitto", Group: "Docker", Status: "running", Health: types.HealthOperational, LastChange: "1h", Uptime: "1h"},
+		types.ServiceStatus{Name: "grafana", Group: "Docker", Status: "exited", Health: types.HealthMajorOutage, LastChange: "5m", Uptime: "-"},
+	))
+	s := newTestServer(t, testStore(), collector)
+
+	tests := []struct {
+		target string
+		status int
+	}{
+		{"/api/v1/status", http.StatusOK},
+		{"/api/v1/services", http.StatusOK},
+		{"/api/v1/services?group=Docker&health=major_outage", http.StatusOK},
+		{"/api/v1/services/history", http.StatusOK},
+		{"/api/v1/services/history?service=docker_mosquitto&from=2h", http.StatusOK},
+		{"/api/v1/metrics", http.StatusOK},
+		{"/api/v1/metrics?metric=container_cpu", http.StatusOK},
+		{"/api/v1/metrics/query?metric=container_cpu&agg=p95&step=1m", http.StatusOK},
+		{"/api/v1/metrics/samples?metric=container_cpu&label=container:grafana", http.StatusOK},
+		{"/api/v1/sources", http.StatusOK},
+		// No process snapshot was collected yet
+		{"/api/v1/processes", http.StatusServiceUnavailable},
+		// HAProxy is not reachable
+		{"/api/v1/health", http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			call(t, spec, s, tt.target, tt.status, nil)
+		})
+	}
+
+	var services ServiceList
+	call(t, spec, s, "/api/v1/services?health=major_outage", http.StatusOK, &services)
+	if len(services.Services) != 1 || services.Services[0].ID != "docker_grafana" {
+		t.Errorf("services with a major outage = %+v, want docker_grafana", services.Services)
+	}
+
+	// Label names are only read for a single type
+	var list MetricList
+	call(t, spec, s, "/api/v1/metrics", http.StatusOK, &list)
+	if len(list.Metrics) != 1 || list.Metrics[0].Name != "container_cpu" || list.Metrics[0].LabelNames != nil {
+		t.Errorf("metrics = %+v, want container_cpu without label names", list.Metrics)
+	}
+	call(t, spec, s, "/api/v1/metrics?metric=container_cpu", http.StatusOK, &list)
+	if len(list.Metrics) != 1 || !slices.Equal(list.Metrics[0].LabelNames, []string{"container"}) {
+		t.Errorf("metrics = %+v, want container_cpu with its container label", list.Metrics)
+	}
+
+	var samples Page[MetricSample]
+	call(t, spec, s, "/api/v1/metrics/samples?metric=container_cpu&label=container:grafana", http.StatusOK, &samples)
+	for _, sample := range samples.Data {
+		if sample.Labels["container"] != "grafana" {
+			t.Errorf("sample %+v does not match the label filter", sample)
+		}
+	}
+	if len(samples.Data) != 2 {
+		t.Errorf("got %d samples of grafana, want 2", len(samples.Data))
+	}
+
+	// The spec is served as embedded
+	if recorder := get(s, "/api/v1/openapi.json"); recorder.Code != http.StatusOK || !bytes.Equal(recorder.Body.Bytes(), openAPI) {
+		t.Errorf("GET /api/v1/openapi.json = %d, want the embedded document", recorder.Code)
+	}
+}
+
+func TestAPIEmptyCollector(t *testing.T) {
+	spec := loadSpec(t)
+	s := newTestServer(t, &fakeStore{}, newFakeCollector())
+
+	// Lists are empty, not null, before the first collection
+	for _, target := range []string{"/api/v1/status", "/api/v1/services", "/api/v1/services/history", "/api/v1/metrics", "/api/v1/sources"} {
+		call(t, spec, s, target, http.StatusOK, nil)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	spec := loadSpec(t)
+	s := newTestServer(t, testStore(), newFakeCollector())
+
+	tests := []struct {
+		target    string
+		status    int
+		code      string
+		parameter string
+	}{
+		{"/api/v1/services?health=sick", http.StatusBadRequest, codeInvalidParameter, "health"},
+		{"/api/v1/services/history?limit=0", http.StatusBadRequest, codeInvalidParameter, "limit"},
+		{"/api/v1/services/history?limit=1001", http.StatusBadRequest, codeInvalidParameter, "limit"},
+		{"/api/v1/services/history?limit=ten", http.StatusBadRequest, codeInvalidParameter, "limit"},
+		{"/api/v1/services/history?cursor=abc", http.StatusBadRequest, codeInvalidParameter, "cursor"},
+		{"/api/v1/services/history?cursor=-5", http.StatusBadRequest, codeInvalidParameter, "cursor"},
+		{"/api/v1/services/history?from=yesterday", http.StatusBadRequest, codeInvalidParameter, "from"},
+		{"/api/v1/services/history?to=tomorrow", http.StatusBadRequest, codeInvalidParameter, "to"},
+		{"/api/v1/services/history?from=1h&to=2h", http.StatusBadRequest, codeInvalidParameter, "from"},
+		{"/api/v1/metrics/samples", http.StatusBadRequest, codeInvalidParameter, "metric"},
+		{"/api/v1/metrics/samples?metric=cpu&label=container", http.StatusBadRequest, codeInvalidParameter, "label"},
+		{"/api/v1/metrics/samples?metric=cpu&limit=-1", http.StatusBadRequest, codeInvalidParameter, "limit"},
+		{"/api/v1/metrics/query", http.StatusBadRequest, codeInvalidParameter, "metric"},
+		{"/api/v1/metrics/query?metric=cpu&agg=median", http.StatusBadRequest, codeInvalidParameter, "agg"},
+		{"/api/v1/metrics/query?metric=cpu&step=1ms", http.StatusBadRequest, codeInvalidParameter, "step"},
+		{"/api/v1/metrics/query?metric=cpu&from=720h&step=1s", http.StatusBadRequest, codeInvalidParameter, "step"},
+		{"/api/v1/metrics?metric=unknown", http.StatusNotFound, codeNotFound, ""},
+		{"/api/v1/unknown", http.StatusNotFound, codeNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			var body APIError
+			call(t, spec, s, tt.target, tt.status, &body)
+			if body.Error.Code != tt.code || body.Error.Parameter != tt.parameter || body.Error.Message == "" {
+				t.Errorf("error = %+v, want code %s for parameter %q", body.Error, tt.code, tt.parameter)
+			}
+		})
+	}
+}
+
+func TestAPIStoreErrors(t *testing.T) {
+	spec := loadSpec(t)
+	s := newTestServer(t, &fakeStore{err: errors.New("connection refused")}, newFakeCollector())
+
+	for _, target := range []string{
+		"/api/v1/services/history",
+		"/api/v1/metrics",
+		"/api/v1/metrics?metric=cpu",
+		"/api/v1/metrics/query?metric=cpu",
+		"/api/v1/metrics/samples?metric=cpu",
+	} {
+		t.Run(target, func(t *testing.T) {
+			var body APIError
+			call(t, spec, s, target, http.StatusInternalServerError, &body)
+			// Database errors are logged, not returned
+			if body.Error.Code != codeInternal || strings.Contains(body.Error.Message, "connection refused") {
+				t.Errorf("error = %+v, want an internal error without details", body.Error)
+			}
+		})
+	}
+}
+
+func TestAPIPaging(t *testing.T) {
+	spec := loadSpec(t)
+	s := newTestServer(t, testStore(), newFakeCollector())
+
+	tests := []struct {
+		target string
+		rows   int
+	}{
+		{"/api/v1/services/history?service=docker_mosquitto&limit=2", 5},
+		{"/api/v1/metrics/samples?metric=container_cpu&label=container:mosquitto&limit=2", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			var timestamps []time.Time
+			target := tt.target
+			for pages := 0; ; pages++ {
+				if pages > tt.rows {
+					t.Fatal("paging does not end")
+				}
+				var page struct {
+					Data []struct {
+						Timestamp time.Time `json:"timestamp"`
+					} `json:"data"`
+					NextCursor string `json:"next_cursor"`
+				}
+				call(t, spec, s, target, http.StatusOK, &page)
+				if len(page.Data) > 2 {
+					t.Fatalf("page has %d rows, more than the limit of 2", len(page.Data))
+				}
+				for _, row := range page.Data {
+					timestamps = append(timestamps, row.Timestamp)
+				}
+				if page.NextCursor == "" {
+					break
+				}
+				target = tt.target + "&cursor=" + page.NextCursor
+			}
+
+			if len(timestamps) != tt.rows {
+				t.Fatalf("got %d rows over all pages, want %d", len(timestamps), tt.rows)
+			}
+			// Newest first, without a row twice
+			for i := 1; i < len(timestamps); i++ {
+				if !timestamps[i].Before(timestamps[i-1]) {
+					t.Errorf("row %d at %s is not older than the row before", i, timestamps[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hra42/iot-hub-statuspage/internal/storage"
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+	"github.com/hra42/iot-hub-statuspage/internal/web/templates"
+)
+
+// historyPoints is the number of buckets per chart and timeline.
+const historyPoints = 120
+
+// historyCacheTTL is how long loaded history and sparklines are reused. It
+// is the bucket size of the shortest range, so page loads within it would
+// mostly get the same buckets.
+const historyCacheTTL = 30 * time.Second
+
+// historyCache holds the history last loaded per range and the sparklines,
+// so page loads and service grid renders share their queries.
+type historyCache struct {
+	mu         sync.Mutex
+	ranges     map[string]cachedHistory
+	sparklines cachedSparklines
+}
+
+type cachedHistory struct {
+	history  templates.History
+	loadedAt time.Time
+}
+
+type cachedSparklines struct {
+	containers map[string]templates.ContainerHistory
+	wan        templates.WANHistory
+	loadedAt   time.Time
+}
+
+// historyChart is a dashboard chart and the metrics it draws.
+type historyChart struct {
+	title   string
+	icon    string
+	metrics []string
+	// labels and colors belong to the metrics by position
+	labels []string
+	colors []string
+}
+
+var historyCharts = []historyChart{
+	{title: "CPU", icon: "fas fa-microchip text-blue-400", metrics: []string{"cpu"}, colors: []string{"#60a5fa"}},
+	{title: "Memory", icon: "fas fa-memory text-purple-400", metrics: []string{"memory"}, colors: []string{"#c084fc"}},
+	{title: "Disk", icon: "fas fa-hard-drive text-orange-400", metrics: []string{"disk"}, colors: []string{"#fb923c"}},
+	{
+		title:   "Network",
+		icon:    "fas fa-network-wired text-cyan-400",
+		metrics: []string{"network_in_rate", "network_out_rate"},
+		labels:  []string{"In", "Out"},
+		colors:  []string{"#22d3ee", "#f472b6"},
+	},
+	{title: "Database Size", icon: "fas fa-database text-green-400", metrics: []string{"database_size"}, colors: []string{"#4ade80"}},
+}
+
+// historyRange returns the named range, or the default one for unknown
+// names.
+func historyRange(name string) templates.HistoryRange {
+	for _, r := range templates.HistoryRanges {
+		if r.Name == name {
+			return r
+		}
+	}
+	return templates.HistoryRanges[0]
+}
+
+// getHistory returns the charts and service timelines of a range, loaded
+// within the last historyCacheTTL.
+func (s *Server) getHistory(rangeName string) templates.History {
+	r := historyRange(rangeName)
+	s.cache.mu.Lock()
+	cached, ok := s.cache.ranges[r.Name]
+	s.cache.mu.Unlock()
+	if ok && time.Since(cached.loadedAt) < historyCacheTTL {
+		return cached.history
+	}
+
+	history := s.loadHistory(r)
+	s.cache.mu.Lock()
+	if s.cache.ranges == nil {
+		s.cache.ranges = make(map[string]cachedHistory)
+	}
+	s.cache.ranges[r.Name] = cachedHistory{history: history, loadedAt: time.Now()}
+	s.cache.mu.Unlock()
+	return history
+}
+
+// getSparklines returns the container and WAN history of the last hour,
+// loaded within the last historyCacheTTL.
+func (s *Server) getSparklines() (map[string]templates.ContainerHistory, templates.WANHistory) {
+	s.cache.mu.Lock()
+	cached := s.cache.sparklines
+	s.cache.mu.Unlock()
+	if !cached.loadedAt.IsZero() && time.Since(cached.loadedAt) < historyCacheTTL {
+		return cached.containers, cached.wan
+	}
+
+	cached = cachedSparklines{
+		containers: s.getContainerHistory(time.Hour),
+		wan:        s.getWANHistory(time.Hour),
+		loadedAt:   time.Now(),
+	}
+	s.cache.mu.Lock()
+	s.cache.sparklines = cached
+	s.cache.mu.Unlock()
+	return cached.containers, cached.wan
+}
+
+// loadHistory loads the charts and service timelines of a range. Failed
+// queries leave their chart empty.
+func (s *Server) loadHistory(r templates.HistoryRange) templates.History {
+	to := time.Now()
+	history := templates.History{
+		Range: r.Name,
+		From:  to.Add(-r.Duration),
+		To:    to,
+		Step:  r.Duration / historyPoints,
+	}
+
+	var metricTypes []string
+	for _, chart := range historyCharts {
+		metricTypes = append(metricTypes, chart.metrics...)
+	}
+	series, err := s.queryMetricSeries(storage.AggregateQuery{
+		MetricTypes: metricTypes,
+		From:        history.From,
+		To:          history.To,
+		Step:        history.Step,
+		Aggregation: "avg",
+	})
+	if err != nil {
+		series = nil
+	}
+	byMetric := make(map[string]MetricSeries)
+	for _, stored := range series {
+		// Host wide metrics carry no labels, skip anything else
+		if len(stored.Labels) == 0 {
+			byMetric[stored.Metric] = stored
+		}
+	}
+
+	for _, chart := range historyCharts {
+		query := url.Values{
+			"metric": {strings.Join(chart.metrics, ",")},
+			"from":   {fmt.Sprintf("%.0fh", r.Duration.Hours())},
+			"step":   {history.Step.String()},
+		}
+		c := templates.Chart{
+			Title: chart.title,
+			Icon:  chart.icon,
+			Query: "/api/v1/metrics/query?" + query.Encode(),
+		}
+		for i, metric := range chart.metrics {
+			stored := byMetric[metric]
+			if stored.Unit != types.UnitNone {
+				c.Unit = stored.Unit
+			}
+			cs := templates.ChartSeries{Label: metric, Color: chart.colors[i]}
+			if i < len(chart.labels) {
+				cs.Label = chart.labels[i]
+			}
+			for _, point := range stored.Points {
+				cs.Points = append(cs.Points, templates.ChartPoint{Time: point.Timestamp, Value: point.Value})
+			}
+			c.Series = append(c.Series, cs)
+		}
+		history.Charts = append(history.Charts, c)
+	}
+
+	history.Timelines = s.getServiceTimelines(history.From, history.To, history.Step)
+	return history
+}
+
+// getServiceTimelines returns the timelines of the current services in
+// dashboard order.
+func (s *Server) getServiceTimelines(from, to time.Time, step time.Duration) []templates.ServiceTimeline {
+	stored, err := s.db.ServiceTimelines("", from, to, step)
+	if err != nil {
+		log.Printf("Failed to load service timelines: %v", err)
+		return nil
+	}
+
+	var timelines []templates.ServiceTimeline
+	for _, reading := range s.collector.GetReadings() {
+		for _, service := range reading.Services {
+			timeline := templates.ServiceTimeline{Name: service.Title()}
+			for _, bucket := range stored[types.ServiceKey(reading.Source, service)] {
+				timeline.Buckets = append(timeline.Buckets, templates.TimelineBucket{
+					Start:  bucket.Start,
+					Health: types.ParseHealth(bucket.Status),
+				})
+			}
+			timelines = append(timelines, timeline)
+		}
+	}
+	return timelines
+}
+
+// handleHistory sends the history section of a range as a Datastar element
+// patch, used by the range buttons.
+func (s *Server) handleHistory(c *gin.Context) {
+	var buf bytes.Buffer
+	if err := templates.HistorySection(s.getHistory(c.Query("range"))).Render(c.Request.Context(), &buf); err != nil {
+		log.Printf("Template render error: %v", err)
+		c.String(http.StatusInternalServerError, "Template render error")
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	writePatchElements(c.Writer, "", buf.String())
+}
@@ -1,69 +1,95 @@
 package web
 
 import (
-	"encoding/json"
-	"fmt"
-	"io"
+	"context"
+	"errors"
 	"log"
 	"net/http"
-	"strings"
-	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/hra42/iot-hub-statuspage/internal/haproxy"
 	"github.com/hra42/iot-hub-statuspage/internal/metrics"
+	"github.com/hra42/iot-hub-statuspage/internal/pubsub"
+	"github.com/hra42/iot-hub-statuspage/internal/push"
 	"github.com/hra42/iot-hub-statuspage/internal/storage"
 	"github.com/hra42/iot-hub-statuspage/internal/types"
 	"github.com/hra42/iot-hub-statuspage/internal/web/templates"
 )
 
 type Server struct {
-	db         *storage.DB
-	haproxy    *haproxy.Client
-	collector  *metrics.Collector
-	router     *gin.Engine
-	sseClients map[chan Event]bool
-	sseMutex   sync.RWMutex
+	db        store
+	haproxy   *haproxy.Client
+	collector collectorState
+	push      *push.Monitor
+	router    *gin.Engine
+	events    *eventHub
+	cache     historyCache
 }
 
-type Event struct {
-	Type string      `json:"type"`
-	Data interface{} `json:"data"`
+// store is the part of the database the server reads. It is implemented by
+// *storage.DB.
+type store interface {
+	Ping() error
+	GetSystemMetricsHistory(metricType string, duration time.Duration) ([]storage.SystemMetric, error)
+	GetLabeledMetricsHistory(metricType, label string, duration time.Duration) (map[string][]storage.SystemMetric, error)
+	GetLatestServiceStatuses() (map[string]storage.ServiceStatus, error)
+	GetServiceStatusHistory(service string, duration time.Duration) ([]storage.ServiceStatus, error)
+	QueryServiceStatuses(q storage.StatusQuery) ([]storage.ServiceStatus, error)
+	QueryMetrics(q storage.MetricQuery) ([]storage.SystemMetric, error)
+	AggregateMetrics(q storage.AggregateQuery) ([]storage.MetricSeries, error)
+	ListMetricTypes() ([]storage.MetricType, error)
+	GetMetricType(name string) (*storage.MetricType, error)
+	ServiceTimelines(service string, from, to time.Time, step time.Duration) (map[string][]storage.StatusBucket, error)
+	ServiceUptime(service string, from, to time.Time) (ratio float64, ok bool, err error)
+	ServiceTransitions(service string, limit int) ([]storage.ServiceStatus, error)
+}
+
+// collectorState is the part of the collector the server reads. It is
+// implemented by *metrics.Collector.
+type collectorState interface {
+	Snapshot() types.Snapshot
+	Subscribe() *pubsub.Subscription[types.Snapshot]
+	GetReadings() []types.SourceReading
+	GetServices() []types.ServiceStatus
+	GetDevices() []types.DeviceStatus
+	GetProcesses() *types.ProcessSnapshot
+	GetSourceStatus() []types.SourceStatus
+	InspectContainer(ctx context.Context, host, name string) (*types.ContainerDetails, error)
 }
 
 type StatusResponse struct {
 	Services    []types.ServiceStatus `json:"services"`
+	Devices     []types.DeviceStatus  `json:"devices,omitempty"`
 	System      SystemStatus          `json:"system"`
-	LastUpdated time.Time            `json:"last_updated"`
+	LastUpdated time.Time             `json:"last_updated"`
 }
 
 type SystemStatus struct {
-	CPUPercent       float64 `json:"cpu_percent"`
-	MemoryPercent    float64 `json:"memory_percent"`
-	MemoryUsed       uint64  `json:"memory_used"`
-	MemoryTotal      uint64  `json:"memory_total"`
-	DiskPercent      float64 `json:"disk_percent"`
-	DiskUsed         uint64  `json:"disk_used"`
-	DiskTotal        uint64  `json:"disk_total"`
-	NetworkIn        float64 `json:"network_in"`
-	NetworkOut       float64 `json:"network_out"`
-	Uptime           string  `json:"uptime"`
-	DatabaseSize     int64   `json:"database_size"`
-	DatabaseConnected bool    `json:"database_connected"`
-	HAProxyConnected bool    `json:"haproxy_connected"`
-	DockerConnected  bool    `json:"docker_connected"`
-	Pi5Connected     bool    `json:"pi5_connected"`
-	Pi52Connected    bool    `json:"pi52_connected"`
+	// Metrics are the titled metrics of all sources
+	Metrics     []types.Metric         `json:"metrics"`
+	Connections []types.HostConnection `json:"connections"`
+	Pi          *types.PiTelemetry     `json:"pi,omitempty"`
+	Mounts      []types.MountStatus    `json:"mounts,omitempty"`
+	Interfaces  []types.InterfaceStats `json:"interfaces,omitempty"`
+	WAN         *types.WANQuality      `json:"wan,omitempty"`
+	Processes   *types.ProcessSnapshot `json:"processes,omitempty"`
+}
+
+// NewServer creates the web server. push may be nil when no push checks
+// are configured.
+func NewServer(db *storage.DB, haproxy *haproxy.Client, collector *metrics.Collector, push *push.Monitor) *Server {
+	return newServer(db, haproxy, collector, push)
 }
 
-func NewServer(db *storage.DB, haproxy *haproxy.Client, collector *metrics.Collector) *Server {
+func newServer(db store, haproxy *haproxy.Client, collector collectorState, push *push.Monitor) *Server {
 	s := &Server{
-		db:         db,
-		haproxy:    haproxy,
-		collector:  collector,
-		router:     gin.New(),
-		sseClients: make(map[chan Event]bool),
+		db:        db,
+		haproxy:   haproxy,
+		collector: collector,
+		push:      push,
+		router:    gin.New(),
+		events:    newEventHub(),
 	}
 
 	s.setupRoutes()
@@ -82,11 +108,23 @@ func (s *Server) setupRoutes() {
 	s.router.GET("/", s.handleDashboard)
 	s.router.GET("/api/status", s.handleAPIStatus)
 	s.router.GET("/api/metrics", s.handleAPIMetrics)
+	s.router.GET("/api/processes", s.handleAPIProcesses)
+	s.router.GET("/api/sources", s.handleAPISources)
+	s.router.POST("/api/push/:token", s.handlePush)
 	s.router.GET("/events", s.handleSSE)
+	s.router.GET("/history", s.handleHistory)
+	s.router.GET("/services/*id", s.handleService)
 	s.router.GET("/health", s.handleHealth)
+	s.setupAPIRoutes()
 
 	// Start SSE broadcaster
-	go s.broadcastUpdates()
+	go s.runEventHub()
+}
+
+// EventSource returns the source reporting the SSE connections of the
+// dashboard. It is registered with the collector like any other source.
+func (s *Server) EventSource() metrics.Source {
+	return s.events
 }
 
 func (s *Server) Router() *gin.Engine {
@@ -100,31 +138,26 @@ func (s *Server) handleDashboard(c *gin.Context) {
 		return
 	}
 
+	containerHistory, wanHistory := s.getSparklines()
 	dashboardData := templates.DashboardData{
 		Services: status.Services,
 		System: templates.SystemStatus{
-			CPUPercent:       status.System.CPUPercent,
-			MemoryPercent:    status.System.MemoryPercent,
-			MemoryUsed:       status.System.MemoryUsed,
-			MemoryTotal:      status.System.MemoryTotal,
-			DiskPercent:      status.System.DiskPercent,
-			DiskUsed:         status.System.DiskUsed,
-			DiskTotal:        status.System.DiskTotal,
-			NetworkIn:        status.System.NetworkIn,
-			NetworkOut:       status.System.NetworkOut,
-			Uptime:           status.System.Uptime,
-			DatabaseSize:     status.System.DatabaseSize,
-			DatabaseConnected: status.System.DatabaseConnected,
-			HAProxyConnected: status.System.HAProxyConnected,
-			DockerConnected:  status.System.DockerConnected,
-			Pi5Connected:     status.System.Pi5Connected,
-			Pi52Connected:    status.System.Pi52Connected,
+			Metrics:     status.System.Metrics,
+			Connections: status.System.Connections,
+			Pi:          status.System.Pi,
+			Mounts:      status.System.Mounts,
+			Interfaces:  status.System.Interfaces,
+			WAN:         status.System.WAN,
+			Processes:   status.System.Processes,
 		},
-		LastUpdated: status.LastUpdated,
+		LastUpdated:      status.LastUpdated,
+		ContainerHistory: containerHistory,
+		WANHistory:       wanHistory,
+		History:          s.getHistory(c.Query("range")),
 	}
 
 	c.Header("Content-Type", "text/html; charset=utf-8")
-	
+
 	// Use templ to render the dashboard
 	component := templates.Dashboard(dashboardData)
 	if err := component.Render(c.Request.Context(), c.Writer); err != nil {
@@ -134,6 +167,35 @@ func (s *Server) handleDashboard(c *gin.Context) {
 	}
 }
 
+// getContainerHistory loads recent CPU and memory samples of all containers
+// for the dashboard sparklines.
+func (s *Server) getContainerHistory(duration time.Duration) map[string]templates.ContainerHistory {
+	history := make(map[string]templates.ContainerHistory)
+
+	cpuSeries, err := s.db.GetLabeledMetricsHistory("container_cpu", "container", duration)
+	if err != nil {
+		log.Printf("Failed to load container CPU history: %v", err)
+		return history
+	}
+	memorySeries, err := s.db.GetLabeledMetricsHistory("container_memory_used", "container", duration)
+	if err != nil {
+		log.Printf("Failed to load container memory history: %v", err)
+		return history
+	}
+
+	for name, series := range cpuSeries {
+		h := history[name]
+		h.CPU = metricValues(series)
+		history[name] = h
+	}
+	for name, series := range memorySeries {
+		h := history[name]
+		h.Memory = metricValues(series)
+		history[name] = h
+	}
+	return history
+}
+
 func (s *Server) handleAPIStatus(c *gin.Context) {
 	status, err := s.getCurrentStatus()
 	if err != nil {
@@ -153,20 +215,33 @@ func (s *Server) handleAPIMetrics(c *gin.Context) {
 	}
 
 	metrics := make(map[string]interface{})
-	
-	// Get CPU metrics
-	cpuMetrics, _ := s.db.GetSystemMetricsHistory("cpu", duration)
-	metrics["cpu"] = cpuMetrics
 
-	// Get memory metrics
-	memMetrics, _ := s.db.GetSystemMetricsHistory("memory", duration)
-	metrics["memory"] = memMetrics
+	// Get CPU and memory metrics
+	for _, metricType := range []string{"cpu", "memory"} {
+		history, err := s.db.GetSystemMetricsHistory(metricType, duration)
+		if err != nil {
+			log.Printf("Failed to load %s history: %v", metricType, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load metrics"})
+			return
+		}
+		metrics[metricType] = history
+	}
 
 	// Get service status history
 	serviceHistory := make(map[string]interface{})
-	services, _ := s.db.GetLatestServiceStatuses()
+	services, err := s.db.GetLatestServiceStatuses()
+	if err != nil {
+		log.Printf("Failed to load services: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load services"})
+		return
+	}
 	for service := range services {
-		history, _ := s.db.GetServiceStatusHistory(service, duration)
+		history, err := s.db.GetServiceStatusHistory(service, duration)
+		if err != nil {
+			log.Printf("Failed to load history of %s: %v", service, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load services"})
+			return
+		}
 		serviceHistory[service] = history
 	}
 	metrics["services"] = serviceHistory
@@ -174,114 +249,68 @@ func (s *Server) handleAPIMetrics(c *gin.Context) {
 	c.JSON(http.StatusOK, metrics)
 }
 
-func (s *Server) handleSSE(c *gin.Context) {
-	c.Header("Content-Type", "text/event-stream")
-	c.Header("Cache-Control", "no-cache")
-	c.Header("Connection", "keep-alive")
-	c.Header("Access-Control-Allow-Origin", "*")
-	c.Header("X-Accel-Buffering", "no")
-
-	clientChan := make(chan Event)
-	
-	// Add client with mutex lock
-	s.sseMutex.Lock()
-	s.sseClients[clientChan] = true
-	s.sseMutex.Unlock()
-	
-	log.Printf("SSE client connected, total clients: %d", len(s.sseClients))
-	
-	// Send initial update immediately
-	go func() {
-		status, err := s.getCurrentStatus()
-		if err == nil {
-			signals := map[string]interface{}{
-				"cpuPercent":       fmt.Sprintf("%.1f", status.System.CPUPercent),
-				"memoryPercent":    fmt.Sprintf("%.1f", status.System.MemoryPercent),
-				"memoryUsed":       formatBytes(float64(status.System.MemoryUsed)),
-				"memoryTotal":      formatBytes(float64(status.System.MemoryTotal)),
-				"diskPercent":      fmt.Sprintf("%.1f", status.System.DiskPercent),
-				"diskUsed":         formatBytes(float64(status.System.DiskUsed)),
-				"diskTotal":        formatBytes(float64(status.System.DiskTotal)),
-				"networkIn":        formatBytes(status.System.NetworkIn),
-				"networkOut":       formatBytes(status.System.NetworkOut),
-				"uptime":           status.System.Uptime,
-				"databaseSize":     formatBytes(float64(status.System.DatabaseSize)),
-				"databaseConnected": status.System.DatabaseConnected,
-				"haproxyConnected": status.System.HAProxyConnected,
-				"dockerConnected":  status.System.DockerConnected,
-				"pi5Connected":     status.System.Pi5Connected,
-				"pi52Connected":    status.System.Pi52Connected,
-				"lastUpdated":      time.Now().Format("2006-01-02 15:04:05"),
-			}
-			
-			// Add service signals
-			for i, service := range status.Services {
-				signals[fmt.Sprintf("service%d_status", i)] = service.Status
-				signals[fmt.Sprintf("service%d_healthy", i)] = service.Healthy
-				signals[fmt.Sprintf("service%d_details", i)] = service.Details
-				signals[fmt.Sprintf("service%d_uptime", i)] = service.Uptime
-			}
-			
-			event := Event{
-				Type: "signals",
-				Data: signals,
-			}
-			
-			select {
-			case clientChan <- event:
-				log.Printf("Sent initial update to new client")
-			default:
-				log.Printf("Failed to send initial update to new client")
-			}
-		}
-	}()
-
-	defer func() {
-		// Remove client with mutex lock
-		s.sseMutex.Lock()
-		delete(s.sseClients, clientChan)
-		s.sseMutex.Unlock()
-		close(clientChan)
-	}()
-
-	c.Stream(func(w io.Writer) bool {
-		select {
-		case event := <-clientChan:
-			// Send Datastar-compatible SSE event
-			if signals, ok := event.Data.(map[string]interface{}); ok {
-				// Send as datastar patch-signals event
-				// Datastar expects the signals directly, not wrapped
-				data, err := json.Marshal(signals)
-				if err != nil {
-					log.Printf("Error marshaling signals: %v", err)
-					return true
-				}
-				
-				// Send in exact format Datastar expects
-				fmt.Fprintf(w, "event: datastar-patch-signals\n")
-				// Split data across multiple lines with "data: signals " prefix
-				lines := strings.Split(string(data), "\n")
-				for i, line := range lines {
-					if i == 0 {
-						fmt.Fprintf(w, "data: signals %s\n", line)
-					} else if line != "" {
-						fmt.Fprintf(w, "data: signals %s\n", line)
-					}
-				}
-				fmt.Fprintf(w, "\n")
-				
-				if flusher, ok := w.(http.Flusher); ok {
-					flusher.Flush()
-				}
-			}
-			return true
-		case <-c.Request.Context().Done():
-			return false
+// handleAPIProcesses returns the process counts and the top processes of
+// the last collection.
+func (s *Server) handleAPIProcesses(c *gin.Context) {
+	processes := s.collector.GetProcesses()
+	if processes == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No process snapshot yet"})
+		return
+	}
+
+	c.JSON(http.StatusOK, processes)
+}
+
+// handleAPISources returns the schedule, last duration and last error of
+// every collection source.
+func (s *Server) handleAPISources(c *gin.Context) {
+	c.JSON(http.StatusOK, s.collector.GetSourceStatus())
+}
+
+// maxPushBody caps the request body of a ping.
+const maxPushBody = 10 << 10
+
+// handlePush records a ping of a push check. The optional status, message
+// and value are read from a JSON body, a form body or the query string.
+func (s *Server) handlePush(c *gin.Context) {
+	if s.push == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": push.ErrUnknownToken.Error()})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxPushBody)
+	var ping push.Ping
+	if c.Request.ContentLength != 0 || c.Request.URL.RawQuery != "" {
+		if err := c.ShouldBind(&ping); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
-	})
+	}
+
+	check, err := s.push.Ping(c.Param("token"), ping)
+	if errors.Is(err, push.ErrUnknownToken) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"check": check.Name, "status": "received"})
 }
 
 func (s *Server) handleHealth(c *gin.Context) {
+	health := s.checkHealth(c.Request.Context())
+	if health.Status == "healthy" {
+		c.JSON(http.StatusOK, health)
+	} else {
+		c.JSON(http.StatusServiceUnavailable, health)
+	}
+}
+
+// checkHealth checks the database and the HAProxy socket.
+func (s *Server) checkHealth(ctx context.Context) HealthResponse {
 	healthy := true
 	details := make(map[string]string)
 
@@ -294,7 +323,7 @@ func (s *Server) handleHealth(c *gin.Context) {
 	}
 
 	// Check HAProxy connection
-	if s.haproxy.IsHealthy() {
+	if s.haproxy.IsHealthy(ctx) {
 		details["haproxy"] = "healthy"
 	} else {
 		healthy = false
@@ -302,170 +331,69 @@ func (s *Server) handleHealth(c *gin.Context) {
 	}
 
 	if healthy {
-		c.JSON(http.StatusOK, gin.H{"status": "healthy", "details": details})
-	} else {
-		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unhealthy", "details": details})
+		return HealthResponse{Status: "healthy", Details: details}
 	}
+	return HealthResponse{Status: "unhealthy", Details: details}
 }
 
 func (s *Server) getCurrentStatus() (*StatusResponse, error) {
-	// Get HAProxy stats
-	haproxyStats, err := s.haproxy.GetStats()
-	if err != nil {
-		return nil, err
-	}
-
-	// Get system metrics
-	systemMetrics := s.collector.GetCurrentMetrics()
-
-	// Build service status list
-	var services []types.ServiceStatus
-	for _, backend := range haproxyStats.Backends {
-		status := types.ServiceStatus{
-			Name:    backend.Name,
-			Status:  backend.Status,
-			Healthy: backend.Active,
-		}
-		
-		// Calculate uptime/downtime
-		if backend.LastChange > 0 {
-			duration := time.Duration(backend.LastChange) * time.Second
-			status.LastChange = formatDuration(duration)
-		}
-		
-		if backend.Active {
-			status.Uptime = status.LastChange
-		} else {
-			status.Details = fmt.Sprintf("Down for %s", status.LastChange)
-		}
-
-		services = append(services, status)
-	}
-
-	// Get Docker container status
-	dockerServices := s.collector.GetDockerStatus()
-	services = append(services, dockerServices...)
+	status := newStatusResponse(s.collector.Snapshot())
+	status.Devices = s.collector.GetDevices()
+	return status, nil
+}
 
+// newStatusResponse returns the status of a snapshot, without devices.
+func newStatusResponse(snapshot types.Snapshot) *StatusResponse {
 	systemStatus := SystemStatus{
-		CPUPercent:       systemMetrics.CPUPercent,
-		MemoryPercent:    systemMetrics.MemoryPercent,
-		MemoryUsed:       systemMetrics.MemoryUsed,
-		MemoryTotal:      systemMetrics.MemoryTotal,
-		DiskPercent:      systemMetrics.DiskPercent,
-		DiskUsed:         systemMetrics.DiskUsed,
-		DiskTotal:        systemMetrics.DiskTotal,
-		NetworkIn:        systemMetrics.NetworkIn,
-		NetworkOut:       systemMetrics.NetworkOut,
-		Uptime:           formatDuration(systemMetrics.Uptime),
-		DatabaseSize:     systemMetrics.DatabaseSize,
-		DatabaseConnected: systemMetrics.DatabaseConnected,
-		HAProxyConnected: systemMetrics.HAProxyConnected,
-		DockerConnected:  systemMetrics.DockerConnected,
-		Pi5Connected:     systemMetrics.Pi5Connected,
-		Pi52Connected:    systemMetrics.Pi52Connected,
+		Metrics:     emptyIfNil(snapshot.DisplayMetrics()),
+		Connections: emptyIfNil(snapshot.Connections()),
+		Pi:          snapshot.System.Pi,
+		Mounts:      snapshot.System.Mounts,
+		Interfaces:  snapshot.System.Interfaces,
+		WAN:         snapshot.System.WAN,
+		Processes:   snapshot.System.Processes,
 	}
-	
+
 	return &StatusResponse{
-		Services: services,
-		System: systemStatus,
-		LastUpdated: time.Now(),
-	}, nil
+		Services:    emptyIfNil(snapshot.Services()),
+		System:      systemStatus,
+		LastUpdated: snapshot.Time,
+	}
 }
 
-func (s *Server) broadcastUpdates() {
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		status, err := s.getCurrentStatus()
-		if err != nil {
-			continue
-		}
-
-		// Create signals update for Datastar
-		signals := map[string]interface{}{
-			"cpuPercent":       fmt.Sprintf("%.1f", status.System.CPUPercent),
-			"memoryPercent":    fmt.Sprintf("%.1f", status.System.MemoryPercent),
-			"memoryUsed":       formatBytes(float64(status.System.MemoryUsed)),
-			"memoryTotal":      formatBytes(float64(status.System.MemoryTotal)),
-			"diskPercent":      fmt.Sprintf("%.1f", status.System.DiskPercent),
-			"diskUsed":         formatBytes(float64(status.System.DiskUsed)),
-			"diskTotal":        formatBytes(float64(status.System.DiskTotal)),
-			"networkIn":        formatBytes(status.System.NetworkIn),
-			"networkOut":       formatBytes(status.System.NetworkOut),
-			"uptime":           status.System.Uptime,
-			"databaseSize":     formatBytes(float64(status.System.DatabaseSize)),
-			"databaseConnected": status.System.DatabaseConnected,
-			"haproxyConnected": status.System.HAProxyConnected,
-			"dockerConnected":  status.System.DockerConnected,
-			"pi5Connected":     status.System.Pi5Connected,
-			"pi52Connected":    status.System.Pi52Connected,
-			"lastUpdated":      time.Now().Format("2006-01-02 15:04:05"),
-		}
-		
-		// Add service signals
-		for i, service := range status.Services {
-			signals[fmt.Sprintf("service%d_status", i)] = service.Status
-			signals[fmt.Sprintf("service%d_healthy", i)] = service.Healthy
-			signals[fmt.Sprintf("service%d_details", i)] = service.Details
-			signals[fmt.Sprintf("service%d_uptime", i)] = service.Uptime
-		}
-
-		event := Event{
-			Type: "signals",
-			Data: signals,
-		}
-
-		// Send event to all clients with read lock
-		s.sseMutex.RLock()
-		clients := make([]chan Event, 0, len(s.sseClients))
-		for client := range s.sseClients {
-			clients = append(clients, client)
-		}
-		clientCount := len(s.sseClients)
-		s.sseMutex.RUnlock()
-		
-		// Send to clients outside of lock
-		for _, client := range clients {
-			select {
-			case client <- event:
-				// Successfully sent
-			default:
-				// Client buffer full, skip
-			}
-		}
-		
-		if clientCount > 0 {
-			log.Printf("Broadcasted updates to %d clients", clientCount)
-		}
+// emptyIfNil returns an empty slice for nil, so lists are encoded as []
+// rather than null, e.g. before the first collection.
+func emptyIfNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
 	}
+	return s
 }
 
-func formatBytes(bytes float64) string {
-	const unit = 1024
-	if bytes < unit {
-		return fmt.Sprintf("%.0f B", bytes)
+// getWANHistory loads recent WAN quality samples for the dashboard
+// sparklines.
+func (s *Server) getWANHistory(duration time.Duration) templates.WANHistory {
+	var history templates.WANHistory
+	series := map[string]*[]float64{
+		"wan_latency":      &history.Latency,
+		"wan_loss":         &history.Loss,
+		"dns_resolve_time": &history.DNS,
 	}
-	
-	div, exp := int64(unit), 0
-	for n := bytes / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
+	for metricType, values := range series {
+		metrics, err := s.db.GetSystemMetricsHistory(metricType, duration)
+		if err != nil {
+			log.Printf("Failed to load %s history: %v", metricType, err)
+			continue
+		}
+		*values = metricValues(metrics)
 	}
-	
-	return fmt.Sprintf("%.1f %cB", bytes/float64(div), "KMGTPE"[exp])
+	return history
 }
 
-func formatDuration(d time.Duration) string {
-	days := int(d.Hours()) / 24
-	hours := int(d.Hours()) % 24
-	minutes := int(d.Minutes()) % 60
-
-	if days > 0 {
-		return fmt.Sprintf("%dd %dh %dm", days, hours, minutes)
-	} else if hours > 0 {
-		return fmt.Sprintf("%dh %dm", hours, minutes)
+func metricValues(metrics []storage.SystemMetric) []float64 {
+	values := make([]float64, len(metrics))
+	for i, m := range metrics {
+		values[i] = m.Value
 	}
-	return fmt.Sprintf("%dm", minutes)
+	return values
 }
-
@@ -0,0 +1,398 @@
+package web
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hra42/iot-hub-statuspage/internal/haproxy"
+	"github.com/hra42/iot-hub-statuspage/internal/pubsub"
+	"github.com/hra42/iot-hub-statuspage/internal/push"
+	"github.com/hra42/iot-hub-statuspage/internal/storage"
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	os.Exit(m.Run())
+}
+
+// fakeStore holds the rows of the database in memory. Rows are ordered by
+// ID. A set err fails every query.
+type fakeStore struct {
+	statuses []storage.ServiceStatus
+	metrics  []storage.SystemMetric
+	err      error
+	// historyQueries counts the queries of the dashboard history
+	historyQueries atomic.Int32
+	// pushStates are the pings stored by push checks
+	pushStates map[string]storage.PushState
+}
+
+func (f *fakeStore) Ping() error { return f.err }
+
+func (f *fakeStore) GetSystemMetricsHistory(metricType string, duration time.Duration) ([]storage.SystemMetric, error) {
+	f.historyQueries.Add(1)
+	var rows []storage.SystemMetric
+	since := time.Now().Add(-duration)
+	for _, m := range f.metrics {
+		if m.MetricType == metricType && !m.Timestamp.Before(since) {
+			rows = append(rows, m)
+		}
+	}
+	return rows, f.err
+}
+
+func (f *fakeStore) GetLabeledMetricsHistory(metricType, label string, duration time.Duration) (map[string][]storage.SystemMetric, error) {
+	f.historyQueries.Add(1)
+	rows, err := f.GetSystemMetricsHistory(metricType, duration)
+	series := make(map[string][]storage.SystemMetric)
+	for _, m := range rows {
+		if value, ok := m.Labels[label]; ok {
+			series[value] = append(series[value], m)
+		}
+	}
+	return series, err
+}
+
+func (f *fakeStore) GetLatestServiceStatuses() (map[string]storage.ServiceStatus, error) {
+	latest := make(map[string]storage.ServiceStatus)
+	for _, s := range f.statuses {
+		latest[s.Service] = s
+	}
+	return latest, f.err
+}
+
+func (f *fakeStore) GetServiceStatusHistory(service string, duration time.Duration) ([]storage.ServiceStatus, error) {
+	return f.QueryServiceStatuses(storage.StatusQuery{Service: service, From: time.Now().Add(-duration), To: time.Now()})
+}
+
+func (f *fakeStore) QueryServiceStatuses(q storage.StatusQuery) ([]storage.ServiceStatus, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	var rows []storage.ServiceStatus
+	for _, s := range slices.Backward(f.statuses) {
+		if (q.Service != "" && s.Service != q.Service) || s.Timestamp.Before(q.From) || !s.Timestamp.Before(q.To) {
+			continue
+		}
+		if q.Before != 0 && s.ID >= q.Before {
+			continue
+		}
+		if q.Limit > 0 && len(rows) == q.Limit {
+			break
+		}
+		rows = append(rows, s)
+	}
+	return rows, nil
+}
+
+func (f *fakeStore) QueryMetrics(q storage.MetricQuery) ([]storage.SystemMetric, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	var rows []storage.SystemMetric
+	for _, m := range slices.Backward(f.metrics) {
+		if m.MetricType != q.MetricType || m.Timestamp.Before(q.From) || !m.Timestamp.Before(q.To) || !hasLabels(m, q.Labels) {
+			continue
+		}
+		if q.Before != 0 && m.ID >= q.Before {
+			continue
+		}
+		if q.Limit > 0 && len(rows) == q.Limit {
+			break
+		}
+		rows = append(rows, m)
+	}
+	return rows, nil
+}
+
+// AggregateMetrics returns every matching row as a point of its own.
+func (f *fakeStore) AggregateMetrics(q storage.AggregateQuery) ([]storage.MetricSeries, error) {
+	f.historyQueries.Add(1)
+	if f.err != nil {
+		return nil, f.err
+	}
+	var series []storage.MetricSeries
+	for _, m := range f.metrics {
+		if !slices.Contains(q.MetricTypes, m.MetricType) || m.Timestamp.Before(q.From) || !m.Timestamp.Before(q.To) || !hasLabels(m, q.Labels) {
+			continue
+		}
+		series = append(series, storage.MetricSeries{
+			MetricType: m.MetricType,
+			Labels:     m.Labels,
+			Points:     []storage.MetricPoint{{Timestamp: m.Timestamp, Value: m.Value}},
+		})
+	}
+	return series, nil
+}
+
+func (f *fakeStore) ListMetricTypes() ([]storage.MetricType, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	var metricTypes []storage.MetricType
+	for _, m := range f.metrics {
+		i := slices.IndexFunc(metricTypes, func(t storage.MetricType) bool { return t.Name == m.MetricType })
+		if i < 0 {
+			metricTypes = append(metricTypes, storage.MetricType{Name: m.MetricType})
+			i = len(metricTypes) - 1
+		}
+		metricTypes[i].LastSeen = m.Timestamp
+	}
+	return metricTypes, nil
+}
+
+func (f *fakeStore) GetMetricType(name string) (*storage.MetricType, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	var metricType *storage.MetricType
+	for _, m := range f.metrics {
+		if m.MetricType != name {
+			continue
+		}
+		if metricType == nil {
+			metricType = &storage.MetricType{Name: name, LabelNames: []string{}}
+		}
+		for label := range m.Labels {
+			if !slices.Contains(metricType.LabelNames, label) {
+				metricType.LabelNames = append(metricType.LabelNames, label)
+			}
+		}
+		metricType.LastSeen = m.Timestamp
+	}
+	return metricType, nil
+}
+
+func (f *fakeStore) ServiceTimelines(service string, from, to time.Time, step time.Duration) (map[string][]storage.StatusBucket, error) {
+	f.historyQueries.Add(1)
+	timelines := make(map[string][]storage.StatusBucket)
+	for _, s := range f.statuses {
+		if (service == "" || s.Service == service) && !s.Timestamp.Before(from) && s.Timestamp.Before(to) {
+			timelines[s.Service] = append(timelines[s.Service], storage.StatusBucket{Start: s.Timestamp.Truncate(step), Status: s.Status})
+		}
+	}
+	return timelines, f.err
+}
+
+func (f *fakeStore) ServiceUptime(service string, from, to time.Time) (float64, bool, error) {
+	return 1, false, f.err
+}
+
+func (f *fakeStore) ServiceTransitions(service string, limit int) ([]storage.ServiceStatus, error) {
+	return f.QueryServiceStatuses(storage.StatusQuery{Service: service, To: time.Now(), Limit: limit})
+}
+
+func (f *fakeStore) GetPushStates() (map[string]storage.PushState, error) {
+	return make(map[string]storage.PushState), f.err
+}
+
+func (f *fakeStore) SavePushState(state storage.PushState) error {
+	if f.pushStates == nil {
+		f.pushStates = make(map[string]storage.PushState)
+	}
+	f.pushStates[state.Check] = state
+	return f.err
+}
+
+func hasLabels(m storage.SystemMetric, labels map[string]string) bool {
+	for name, value := range labels {
+		if m.Labels[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// fakeCollector serves snapshots set by the test.
+type fakeCollector struct {
+	mu        sync.Mutex
+	snapshot  types.Snapshot
+	processes *types.ProcessSnapshot
+	snapshots *pubsub.Hub[types.Snapshot]
+}
+
+func newFakeCollector() *fakeCollector {
+	return &fakeCollector{snapshots: pubsub.New[types.Snapshot]()}
+}
+
+// publish replaces the snapshot and publishes it like the collector does
+// after a source ran.
+func (f *fakeCollector) publish(snapshot types.Snapshot) {
+	f.mu.Lock()
+	f.snapshot = snapshot
+	f.mu.Unlock()
+	f.snapshots.Publish(snapshot)
+}
+
+func (f *fakeCollector) Snapshot() types.Snapshot {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.snapshot
+}
+
+func (f *fakeCollector) Subscribe() *pubsub.Subscription[types.Snapshot] {
+	return f.snapshots.Subscribe()
+}
+
+func (f *fakeCollector) GetReadings() []types.SourceReading {
+	return f.Snapshot().Readings
+}
+
+func (f *fakeCollector) GetServices() []types.ServiceStatus {
+	return f.Snapshot().Services()
+}
+
+func (f *fakeCollector) GetDevices() []types.DeviceStatus { return nil }
+
+func (f *fakeCollector) GetProcesses() *types.ProcessSnapshot { return f.processes }
+
+func (f *fakeCollector) GetSourceStatus() []types.SourceStatus {
+	statuses := make([]types.SourceStatus, 0)
+	for _, reading := range f.GetReadings() {
+		statuses = append(statuses, types.SourceStatus{Name: reading.Source, IntervalMs: 5000, TimeoutMs: 4000})
+	}
+	return statuses
+}
+
+func (f *fakeCollector) InspectContainer(ctx context.Context, host, name string) (*types.ContainerDetails, error) {
+	return nil, nil
+}
+
+// newTestServer creates a server on db and collector. HAProxy is never
+// reachable.
+func newTestServer(t *testing.T, db *fakeStore, collector *fakeCollector) *Server {
+	t.Helper()
+	return newServer(db, haproxy.NewClient(filepath.Join(t.TempDir(), "haproxy.sock")), collector, nil)
+}
+
+// post sends a POST request with a body of contentType to the server and
+// returns the recorded response.
+func post(s *Server, target, contentType, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.Router().ServeHTTP(recorder, req)
+	return recorder
+}
+
+// get sends a GET request to the server and returns the recorded response.
+func get(s *Server, target string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	s.Router().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+	return recorder
+}
+
+// testSnapshot returns a snapshot with the services of a source, keyed by
+// the collector.
+func testSnapshot(source string, services ...types.ServiceStatus) types.Snapshot {
+	for i := range services {
+		services[i].ID = types.ServiceKey(source, services[i])
+	}
+	return types.Snapshot{
+		Time: time.Now(),
+		Readings: []types.SourceReading{{
+			Source:   source,
+			Metrics:  []types.Metric{{Name: "cpu", Title: "CPU", Value: 12.5, Unit: types.UnitPercent}},
+			Services: services,
+		}},
+	}
+}
+
+func TestDashboardCachesHistory(t *testing.T) {
+	db := testStore()
+	// Without a snapshot no services grid is rendered in the background
+	s := newTestServer(t, db, newFakeCollector())
+
+	if recorder := get(s, "/"); recorder.Code != http.StatusOK {
+		t.Fatalf("GET / = %d: %s", recorder.Code, recorder.Body)
+	}
+	loaded := db.historyQueries.Load()
+	if loaded == 0 {
+		t.Fatal("the dashboard loaded no history")
+	}
+
+	// A reload and the range buttons reuse what was loaded
+	get(s, "/")
+	get(s, "/history")
+	if queries := db.historyQueries.Load(); queries != loaded {
+		t.Errorf("reload ran %d more history queries, want none", queries-loaded)
+	}
+
+	// Other ranges are loaded on their own
+	if recorder := get(s, "/?range=7d"); recorder.Code != http.StatusOK {
+		t.Fatalf("GET /?range=7d = %d", recorder.Code)
+	}
+	if db.historyQueries.Load() == loaded {
+		t.Error("the 7d range was not loaded")
+	}
+}
+
+func TestPush(t *testing.T) {
+	db := &fakeStore{}
+	monitor, err := push.NewMonitor(db, []push.Check{{Name: "backup", Token: "f3a9c1", Period: time.Hour}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newServer(db, haproxy.NewClient(filepath.Join(t.TempDir(), "haproxy.sock")), newFakeCollector(), monitor)
+	value := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+		want        storage.PushState
+	}{
+		{"empty", "/api/push/f3a9c1", "", "", storage.PushState{Status: "ok"}},
+		{"query", "/api/push/f3a9c1?status=1&message=exit+1", "", "", storage.PushState{Status: "fail", Message: "exit 1"}},
+		{"form", "/api/push/f3a9c1", "application/x-www-form-urlencoded", "status=warning&message=slow&value=2.5", storage.PushState{Status: "warning", Message: "slow", Value: value(2.5)}},
+		{"JSON", "/api/push/f3a9c1", "application/json", `{"status": "fail", "message": "disk full", "value": 97}`, storage.PushState{Status: "fail", Message: "disk full", Value: value(97)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := post(s, tt.target, tt.contentType, tt.body)
+			if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), `"check":"backup"`) {
+				t.Fatalf("POST = %d: %s", recorder.Code, recorder.Body)
+			}
+			got := db.pushStates["backup"]
+			if got.Status != tt.want.Status || got.Message != tt.want.Message || !reflect.DeepEqual(got.Value, tt.want.Value) {
+				t.Errorf("stored %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	for name, tt := range map[string]struct {
+		server      *Server
+		target      string
+		contentType string
+		body        string
+		code        int
+	}{
+		"unknown token":    {s, "/api/push/unknown", "", "", http.StatusNotFound},
+		"no push checks":   {newTestServer(t, db, newFakeCollector()), "/api/push/f3a9c1", "", "", http.StatusNotFound},
+		"invalid status":   {s, "/api/push/f3a9c1", "application/json", `{"status": "maybe"}`, http.StatusBadRequest},
+		"malformed JSON":   {s, "/api/push/f3a9c1", "application/json", `{"status":`, http.StatusBadRequest},
+		"too large":        {s, "/api/push/f3a9c1", "application/json", `{"message": "` + strings.Repeat("x", maxPushBody) + `"}`, http.StatusBadRequest},
+		"wrong value type": {s, "/api/push/f3a9c1", "application/x-www-form-urlencoded", "value=many", http.StatusBadRequest},
+	} {
+		if recorder := post(tt.server, tt.target, tt.contentType, tt.body); recorder.Code != tt.code {
+			t.Errorf("%s: POST = %d, want %d: %s", name, recorder.Code, tt.code, recorder.Body)
+		}
+	}
+}
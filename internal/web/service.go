@@ -0,0 +1,279 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hra42/iot-hub-statuspage/internal/storage"
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+	"github.com/hra42/iot-hub-statuspage/internal/web/templates"
+)
+
+// serviceTransitions is the number of status changes listed on a service
+// page.
+const serviceTransitions = 20
+
+// uptimeWindows are the periods uptime is shown for. The longest matches
+// the retention of the database.
+var uptimeWindows = []struct {
+	name     string
+	duration time.Duration
+}{
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+}
+
+// serviceChart is a chart of a labeled metric on a service page.
+type serviceChart struct {
+	title  string
+	icon   string
+	metric string
+	// unit is used when the latest readings do not tell the unit
+	unit types.Unit
+	// seriesLabel names the lines of metrics with one series per instance,
+	// e.g. per HAProxy server
+	seriesLabel string
+}
+
+var haproxyCharts = []serviceChart{
+	{title: "Sessions", icon: "fas fa-users text-blue-400", metric: "haproxy_sessions"},
+	{title: "Session Rate", icon: "fas fa-gauge-high text-cyan-400", metric: "haproxy_session_rate", unit: types.UnitPerSecond},
+	{title: "Check Duration", icon: "fas fa-stopwatch text-yellow-400", metric: "haproxy_check_duration", unit: types.UnitMilliseconds, seriesLabel: "server"},
+}
+
+var containerCharts = []serviceChart{
+	{title: "CPU", icon: "fas fa-microchip text-blue-400", metric: "container_cpu", unit: types.UnitPercent},
+	{title: "Memory", icon: "fas fa-memory text-purple-400", metric: "container_memory_used", unit: types.UnitBytes},
+}
+
+// chartColors are assigned to the series of a chart in order.
+var chartColors = []string{"#60a5fa", "#c084fc", "#4ade80", "#fb923c", "#22d3ee", "#f472b6"}
+
+// handleService renders the detail page of a service. The ID is the
+// service key and may contain slashes for services of a host. Services
+// that are no longer reported, e.g. removed containers, are shown from
+// their stored history.
+func (s *Server) handleService(c *gin.Context) {
+	id := strings.TrimPrefix(c.Param("id"), "/")
+	service, source, ok := s.findService(id)
+	if !ok {
+		service, source, ok = s.storedService(id)
+	}
+	if !ok {
+		c.String(http.StatusNotFound, "Service not found")
+		return
+	}
+
+	page := s.getServicePage(c.Request.Context(), service, source, c.Query("range"))
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	if err := templates.ServiceDetail(page).Render(c.Request.Context(), c.Writer); err != nil {
+		log.Printf("Template render error: %v", err)
+		c.String(http.StatusInternalServerError, "Template render error")
+		return
+	}
+}
+
+// findService returns the current status of a service and the source that
+// reported it.
+func (s *Server) findService(id string) (types.ServiceStatus, string, bool) {
+	for _, reading := range s.collector.GetReadings() {
+		for _, service := range reading.Services {
+			if service.ID == id {
+				return service, reading.Source, true
+			}
+		}
+	}
+	return types.ServiceStatus{}, "", false
+}
+
+// storedService returns a service that is no longer reported from its
+// latest stored status. ok is false when nothing is stored under id.
+func (s *Server) storedService(id string) (types.ServiceStatus, string, bool) {
+	rows, err := s.db.QueryServiceStatuses(storage.StatusQuery{Service: id, To: time.Now(), Limit: 1})
+	if err != nil {
+		log.Printf("Failed to load the last status of %s: %v", id, err)
+		return types.ServiceStatus{}, "", false
+	}
+	if len(rows) == 0 {
+		return types.ServiceStatus{}, "", false
+	}
+	last := rows[0]
+
+	// IDs are <source>_<host>/<name>, see types.ServiceKey
+	source, name, _ := strings.Cut(id, "_")
+	service := types.ServiceStatus{
+		ID:     id,
+		Name:   name,
+		Status: "not reported",
+		Health: types.HealthUnknown,
+		Details: fmt.Sprintf("No longer reported since %s, last as %s",
+			last.Timestamp.Format("2006-01-02 15:04"), types.ParseHealth(last.Status).Label()),
+	}
+	if host, name, found := strings.Cut(name, "/"); found {
+		service.Host, service.Name = host, name
+	}
+	return service, source, true
+}
+
+// getServicePage loads the history of a service and the details of its
+// source. Failed queries leave their part of the page empty.
+func (s *Server) getServicePage(ctx context.Context, service types.ServiceStatus, source, rangeName string) templates.ServicePage {
+	r := historyRange(rangeName)
+	to := time.Now()
+	page := templates.ServicePage{
+		Service: service,
+		Source:  source,
+		History: templates.History{
+			Range: r.Name,
+			From:  to.Add(-r.Duration),
+			To:    to,
+			Step:  r.Duration / historyPoints,
+		},
+	}
+
+	timelines, err := s.db.ServiceTimelines(service.ID, page.History.From, page.History.To, page.History.Step)
+	if err != nil {
+		log.Printf("Failed to load timeline of %s: %v", service.ID, err)
+	}
+	timeline := templates.ServiceTimeline{Name: service.Title()}
+	for _, bucket := range timelines[service.ID] {
+		timeline.Buckets = append(timeline.Buckets, templates.TimelineBucket{
+			Start:  bucket.Start,
+			Health: types.ParseHealth(bucket.Status),
+		})
+	}
+	page.History.Timelines = []templates.ServiceTimeline{timeline}
+
+	for _, window := range uptimeWindows {
+		uptime := templates.Uptime{Window: window.name}
+		ratio, ok, err := s.db.ServiceUptime(service.ID, to.Add(-window.duration), to)
+		if err != nil {
+			log.Printf("Failed to load uptime of %s: %v", service.ID, err)
+		}
+		if ok {
+			uptime.Percent = ratio * 100
+			uptime.OK = true
+		}
+		page.Uptime = append(page.Uptime, uptime)
+	}
+
+	transitions, err := s.db.ServiceTransitions(service.ID, serviceTransitions)
+	if err != nil {
+		log.Printf("Failed to load transitions of %s: %v", service.ID, err)
+	}
+	until := to
+	for _, transition := range transitions {
+		page.Transitions = append(page.Transitions, templates.Transition{
+			Time:     transition.Timestamp,
+			Health:   types.ParseHealth(transition.Status),
+			Details:  transition.Details,
+			Duration: until.Sub(transition.Timestamp),
+		})
+		until = transition.Timestamp
+	}
+
+	switch {
+	case source == "haproxy":
+		filter := map[string]string{"backend": service.Name}
+		page.History.Charts = s.getServiceCharts(haproxyCharts, filter, page.History)
+		page.Servers, err = s.getBackendServers(ctx, service.Name)
+		if err != nil {
+			page.Error = err.Error()
+		}
+	case source == "docker":
+		filter := map[string]string{"container": service.QualifiedName()}
+		page.History.Charts = s.getServiceCharts(containerCharts, filter, page.History)
+		// Removed containers cannot be inspected
+		if service.Container != nil {
+			page.Container, err = s.collector.InspectContainer(ctx, service.Host, service.Name)
+			if err != nil {
+				page.Error = err.Error()
+			}
+		}
+	}
+	return page
+}
+
+// getServiceCharts loads the series of charts matching filter.
+func (s *Server) getServiceCharts(charts []serviceChart, filter map[string]string, history templates.History) []templates.Chart {
+	var result []templates.Chart
+	for _, chart := range charts {
+		query := url.Values{
+			"metric": {chart.metric},
+			"from":   {fmt.Sprintf("%.0fh", history.To.Sub(history.From).Hours())},
+			"step":   {history.Step.String()},
+		}
+		for name, value := range filter {
+			query.Add("label", name+":"+value)
+		}
+		c := templates.Chart{
+			Title: chart.title,
+			Icon:  chart.icon,
+			Unit:  chart.unit,
+			Query: "/api/v1/metrics/query?" + query.Encode(),
+		}
+
+		series, err := s.queryMetricSeries(storage.AggregateQuery{
+			MetricTypes: []string{chart.metric},
+			From:        history.From,
+			To:          history.To,
+			Labels:      filter,
+			Step:        history.Step,
+			Aggregation: "avg",
+		})
+		if err != nil {
+			series = nil
+		}
+		for i, stored := range series {
+			if stored.Unit != types.UnitNone {
+				c.Unit = stored.Unit
+			}
+			cs := templates.ChartSeries{Label: chart.title, Color: chartColors[i%len(chartColors)]}
+			if chart.seriesLabel != "" {
+				cs.Label = stored.Labels[chart.seriesLabel]
+			}
+			for _, point := range stored.Points {
+				cs.Points = append(cs.Points, templates.ChartPoint{Time: point.Timestamp, Value: point.Value})
+			}
+			c.Series = append(c.Series, cs)
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+// getBackendServers reads the server rows of a HAProxy backend.
+func (s *Server) getBackendServers(ctx context.Context, backend string) ([]templates.BackendServer, error) {
+	stats, err := s.haproxy.GetStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range stats.Backends {
+		if b.Name != backend {
+			continue
+		}
+		servers := make([]templates.BackendServer, 0, len(b.Servers))
+		for _, server := range b.Servers {
+			servers = append(servers, templates.BackendServer{
+				Name:          server.Name,
+				Status:        server.Status,
+				Health:        server.Health,
+				CheckStatus:   server.CheckStatus,
+				CheckDuration: time.Duration(server.CheckDuration) * time.Millisecond,
+				LastChange:    time.Duration(server.LastChange) * time.Second,
+				Sessions:      server.SessionCur,
+				SessionRate:   server.SessionRate,
+				Weight:        server.Weight,
+			})
+		}
+		return servers, nil
+	}
+	return nil, fmt.Errorf("backend %s is no longer reported by HAProxy", backend)
+}
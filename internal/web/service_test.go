@@ -0,0 +1,70 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+)
+
+func TestServicePage(t *testing.T) {
+	collector := newFakeCollector()
+	collector.publish(testSnapshot("docker", types.ServiceStatus{Name: "mosquitto", Status: "running", Health: types.HealthOperational}))
+	s := newTestServer(t, testStore(), collector)
+
+	recorder := get(s, "/services/docker_mosquitto")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /services/docker_mosquitto = %d", recorder.Code)
+	}
+	if body := recorder.Body.String(); !strings.Contains(body, "running") {
+		t.Error("the page of a live service does not show its status")
+	}
+}
+
+func TestServicePageFromHistory(t *testing.T) {
+	db := testStore()
+	db.statuses[len(db.statuses)-1].Service = "docker_nas/grafana"
+	s := newTestServer(t, db, newFakeCollector())
+
+	// The container is gone, its stored statuses are not
+	recorder := get(s, "/services/docker_nas/grafana")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /services/docker_nas/grafana = %d, want the page from history", recorder.Code)
+	}
+	body := recorder.Body.String()
+	for _, want := range []string{"grafana", "nas", "not reported", "No longer reported since"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page of a removed service lacks %q", want)
+		}
+	}
+
+	if recorder := get(s, "/services/docker_never_seen"); recorder.Code != http.StatusNotFound {
+		t.Errorf("GET /services/docker_never_seen = %d, want 404", recorder.Code)
+	}
+}
+
+func TestStoredServiceName(t *testing.T) {
+	db := testStore()
+	db.statuses[0].Service = "systemd_nginx.service"
+	db.statuses[1].Service = "docker_nas/grafana"
+	s := newTestServer(t, db, newFakeCollector())
+
+	tests := []struct {
+		id, source, host, name string
+	}{
+		{"systemd_nginx.service", "systemd", "", "nginx.service"},
+		{"docker_nas/grafana", "docker", "nas", "grafana"},
+		{"docker_mosquitto", "docker", "", "mosquitto"},
+	}
+	for _, tt := range tests {
+		service, source, ok := s.storedService(tt.id)
+		if !ok {
+			t.Errorf("storedService(%q) found nothing", tt.id)
+			continue
+		}
+		if source != tt.source || service.Host != tt.host || service.Name != tt.name || service.ID != tt.id {
+			t.Errorf("storedService(%q) = %s %+v, want %s/%s of %s", tt.id, source, service, tt.host, tt.name, tt.source)
+		}
+	}
+}
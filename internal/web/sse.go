@@ -0,0 +1,360 @@
+package web
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hra42/iot-hub-statuspage/internal/metrics"
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+	"github.com/hra42/iot-hub-statuspage/internal/web/templates"
+)
+
+const (
+	// sseHeartbeat is how long a stream may go without an event before it
+	// gets a comment, so proxies and browsers keep it open.
+	sseHeartbeat = 15 * time.Second
+	// sseRetry is the reconnection delay suggested to browsers.
+	sseRetry = 3 * time.Second
+	// sseReplay is the number of recent events kept for clients that
+	// reconnect with a Last-Event-ID.
+	sseReplay = 32
+	// sseClientBuffer is the number of events a client may fall behind
+	// before it is disconnected. It holds a full replay.
+	sseClientBuffer = 2 * sseReplay
+)
+
+// Event is an update pushed to dashboards. Signals are patched before
+// Elements, so patched elements find their signals.
+type Event struct {
+	// ID increases with every event. The full state sent on connect has
+	// the ID of the latest event it includes.
+	ID      uint64
+	Signals map[string]interface{}
+	// Elements is HTML morphed into the page by element id
+	Elements string
+}
+
+// eventHub keeps the dashboard state last sent to clients and fans out the
+// changes to it.
+type eventHub struct {
+	mu      sync.Mutex
+	clients map[*sseClient]struct{}
+	// signals and grid are the full state, sent to clients when they
+	// connect
+	signals map[string]interface{}
+	grid    string
+	// serviceIDs are the services shown in grid
+	serviceIDs []string
+	// lastID is the ID of the latest event and recent holds the latest
+	// events for replay, oldest first
+	lastID uint64
+	recent []Event
+	// dropped and resumed count clients disconnected for falling behind
+	// and reconnects served from recent
+	dropped uint64
+	resumed uint64
+}
+
+// sseClient is a connected dashboard. done is closed when the client is
+// disconnected for falling behind.
+type sseClient struct {
+	events chan Event
+	done   chan struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		clients: make(map[*sseClient]struct{}),
+		// IDs start at the current time, so they keep increasing across
+		// restarts and IDs of a previous run are never replayed
+		lastID: uint64(time.Now().UnixMilli()),
+	}
+}
+
+// subscribe registers a client. A client reconnecting with the ID of the
+// last event it received gets the events it missed, if they are still
+// kept. Otherwise its first event is the full state, if there is one yet.
+func (h *eventHub) subscribe(lastEventID uint64) *sseClient {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	client := &sseClient{
+		events: make(chan Event, sseClientBuffer),
+		done:   make(chan struct{}),
+	}
+	h.clients[client] = struct{}{}
+	switch {
+	case h.canReplay(lastEventID):
+		for _, event := range h.recent {
+			if event.ID > lastEventID {
+				client.events <- event
+			}
+		}
+		h.resumed++
+	case h.signals != nil:
+		client.events <- Event{ID: h.lastID, Signals: h.signals, Elements: h.grid}
+	}
+	log.Printf("SSE client connected, total clients: %d", len(h.clients))
+	return client
+}
+
+// canReplay reports whether all events after lastEventID are kept. h.mu
+// must be held.
+func (h *eventHub) canReplay(lastEventID uint64) bool {
+	if lastEventID == 0 || lastEventID > h.lastID || h.signals == nil {
+		return false
+	}
+	if lastEventID == h.lastID {
+		return true
+	}
+	return len(h.recent) > 0 && h.recent[0].ID <= lastEventID+1
+}
+
+func (h *eventHub) unsubscribe(client *sseClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients, client)
+}
+
+// update replaces the state and sends what changed to all clients. grid is
+// empty when the services did not change. Clients whose buffer is full are
+// disconnected, as they would otherwise miss changes for good.
+func (h *eventHub) update(signals map[string]interface{}, grid string, serviceIDs []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	event := Event{Signals: diffSignals(h.signals, signals), Elements: grid}
+	h.signals = signals
+	if grid != "" {
+		h.grid = grid
+		h.serviceIDs = serviceIDs
+	}
+	// lastUpdated alone is not worth an event
+	changed := len(event.Signals)
+	if _, ok := event.Signals["lastUpdated"]; ok {
+		changed--
+	}
+	if changed == 0 && grid == "" {
+		return
+	}
+
+	h.lastID++
+	event.ID = h.lastID
+	h.recent = append(h.recent, event)
+	if len(h.recent) > sseReplay {
+		h.recent = slices.Delete(h.recent, 0, len(h.recent)-sseReplay)
+	}
+
+	for client := range h.clients {
+		select {
+		case client.events <- event:
+		default:
+			delete(h.clients, client)
+			close(client.done)
+			h.dropped++
+			log.Printf("Disconnecting SSE client that fell %d events behind", sseClientBuffer)
+		}
+	}
+}
+
+// Name implements metrics.Source.
+func (h *eventHub) Name() string { return "sse" }
+
+// Collect reports the connected dashboards and how many were disconnected
+// or resumed since the start, as a metrics.Source.
+func (h *eventHub) Collect(ctx context.Context) (*metrics.Result, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return &metrics.Result{Metrics: []types.Metric{
+		{Name: "sse_clients", Value: float64(len(h.clients))},
+		{Name: "sse_dropped_clients", Value: float64(h.dropped)},
+		{Name: "sse_resumed_clients", Value: float64(h.resumed)},
+	}}, nil
+}
+
+// shownServices returns the services of the grid sent last.
+func (h *eventHub) shownServices() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.serviceIDs
+}
+
+// diffSignals returns the signals of next that differ from previous.
+// Signals missing from next are set to nil, which removes them on the
+// client.
+func diffSignals(previous, next map[string]interface{}) map[string]interface{} {
+	diff := make(map[string]interface{})
+	for name, value := range next {
+		if old, ok := previous[name]; !ok || !reflect.DeepEqual(old, value) {
+			diff[name] = value
+		}
+	}
+	for name := range previous {
+		if _, ok := next[name]; !ok {
+			diff[name] = nil
+		}
+	}
+	return diff
+}
+
+// runEventHub turns the snapshots published by the collector into
+// dashboard updates. The services grid is only rendered again when services
+// came, went or changed order.
+func (s *Server) runEventHub() {
+	sub := s.collector.Subscribe()
+	defer sub.Close()
+
+	for snapshot := range sub.C() {
+		status := newStatusResponse(snapshot)
+		signals := dashboardSignals(status)
+
+		var grid string
+		ids := serviceIDs(status.Services)
+		if !slices.Equal(ids, s.events.shownServices()) {
+			var err error
+			if grid, err = s.renderServices(status.Services); err != nil {
+				log.Printf("Failed to render services: %v", err)
+			}
+		}
+		s.events.update(signals, grid, ids)
+	}
+}
+
+func (s *Server) handleSSE(c *gin.Context) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("Access-Control-Allow-Origin", "*")
+	c.Header("X-Accel-Buffering", "no")
+
+	// Browsers send the ID of the last event they got when reconnecting
+	lastEventID, _ := strconv.ParseUint(c.GetHeader("Last-Event-ID"), 10, 64)
+	client := s.events.subscribe(lastEventID)
+	defer s.events.unsubscribe(client)
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", sseRetry.Milliseconds())
+	flush(c.Writer)
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event := <-client.events:
+			if err := writeEvent(w, event); err != nil {
+				log.Printf("Error marshaling signals: %v", err)
+			}
+			heartbeat.Reset(sseHeartbeat)
+			return true
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+			flush(w)
+			return true
+		case <-client.done:
+			return false
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// dashboardSignals returns the signals of everything on the dashboard that
+// is updated live.
+func dashboardSignals(status *StatusResponse) map[string]interface{} {
+	signals := map[string]interface{}{
+		"lastUpdated": status.LastUpdated.Format("2006-01-02 15:04:05"),
+	}
+
+	templates.AddMetricSignals(signals, status.System.Metrics)
+	templates.AddConnectionSignals(signals, status.System.Connections)
+	if status.System.Pi != nil {
+		templates.AddPiSignals(signals, status.System.Pi)
+	}
+	templates.AddMountSignals(signals, status.System.Mounts)
+	templates.AddNetworkSignals(signals, status.System.Interfaces, status.System.WAN)
+	templates.AddProcessSignals(signals, status.System.Processes)
+	templates.AddServiceSignals(signals, status.Services)
+	return signals
+}
+
+// renderServices renders the services grid of the dashboard.
+func (s *Server) renderServices(services []types.ServiceStatus) (string, error) {
+	var buf bytes.Buffer
+	containerHistory, _ := s.getSparklines()
+	err := templates.ServicesGrid(services, containerHistory).Render(context.Background(), &buf)
+	return buf.String(), err
+}
+
+func serviceIDs(services []types.ServiceStatus) []string {
+	ids := make([]string, len(services))
+	for i, service := range services {
+		ids[i] = service.ID
+	}
+	return ids
+}
+
+// writeEvent writes an event as Datastar patches. The ID goes on the last
+// one, so a browser only resumes after an event it received completely.
+func writeEvent(w io.Writer, event Event) error {
+	id := strconv.FormatUint(event.ID, 10)
+	if event.Elements == "" {
+		return writePatchSignals(w, id, event.Signals)
+	}
+	if event.Signals != nil {
+		if err := writePatchSignals(w, "", event.Signals); err != nil {
+			return err
+		}
+	}
+	writePatchElements(w, id, event.Elements)
+	return nil
+}
+
+// writePatchSignals writes a datastar-patch-signals event with an optional
+// id. Signals set to nil are removed on the client.
+func writePatchSignals(w io.Writer, id string, signals map[string]interface{}) error {
+	data, err := json.Marshal(signals)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(w, "event: datastar-patch-signals\n")
+	writeID(w, id)
+	fmt.Fprintf(w, "data: signals %s\n\n", data)
+	flush(w)
+	return nil
+}
+
+// writePatchElements writes a datastar-patch-elements event with an
+// optional id. Elements are morphed into the page by their id.
+func writePatchElements(w io.Writer, id, elements string) {
+	fmt.Fprint(w, "event: datastar-patch-elements\n")
+	writeID(w, id)
+	for _, line := range strings.Split(elements, "\n") {
+		fmt.Fprintf(w, "data: elements %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+	flush(w)
+}
+
+func writeID(w io.Writer, id string) {
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+}
+
+func flush(w io.Writer) {
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,380 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hra42/iot-hub-statuspage/internal/types"
+	"github.com/hra42/iot-hub-statuspage/internal/web/templates"
+)
+
+// sseFrame is an event read from the stream. Signals and Elements hold the
+// data of patch-signals and patch-elements events.
+type sseFrame struct {
+	Event    string
+	ID       string
+	Signals  map[string]interface{}
+	Elements string
+}
+
+// sseStream is a connection to /events.
+type sseStream struct {
+	frames chan sseFrame
+	errs   chan error
+}
+
+// connectSSE opens /events on server, sending lastEventID when set, and
+// reads its frames until the test ends. Comments and the retry field are
+// skipped.
+func connectSSE(t *testing.T, server *httptest.Server, lastEventID string) *sseStream {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to connect to /events: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("/events has content type %q", contentType)
+	}
+
+	stream := &sseStream{frames: make(chan sseFrame, 100), errs: make(chan error, 1)}
+	go stream.read(bufio.NewReader(resp.Body))
+	return stream
+}
+
+func (s *sseStream) read(reader *bufio.Reader) {
+	var frame sseFrame
+	var elements []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			s.errs <- err
+			return
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if frame.Event != "" {
+				frame.Elements = strings.Join(elements, "\n")
+				s.frames <- frame
+			}
+			frame, elements = sseFrame{}, nil
+		case strings.HasPrefix(line, "event: "):
+			frame.Event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "id: "):
+			frame.ID = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: signals "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: signals ")), &frame.Signals); err != nil {
+				s.errs <- err
+				return
+			}
+		case strings.HasPrefix(line, "data: elements "):
+			elements = append(elements, strings.TrimPrefix(line, "data: elements "))
+		}
+	}
+}
+
+// next returns the next frame, failing the test if none arrives in time.
+func (s *sseStream) next(t *testing.T) sseFrame {
+	t.Helper()
+	select {
+	case frame := <-s.frames:
+		return frame
+	case err := <-s.errs:
+		t.Fatalf("Stream ended: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("No event within 5s")
+	}
+	return sseFrame{}
+}
+
+// nextEvent returns the frames of the next event: a patch-signals frame,
+// and the patch-elements frame following it when the grid changed. Only the
+// last frame of an event carries its ID.
+func (s *sseStream) nextEvent(t *testing.T) (signals, elements sseFrame) {
+	t.Helper()
+	signals = s.next(t)
+	if signals.Event != "datastar-patch-signals" {
+		t.Fatalf("event starts with %s, want datastar-patch-signals", signals.Event)
+	}
+	if signals.ID != "" {
+		return signals, sseFrame{}
+	}
+	elements = s.next(t)
+	if elements.Event != "datastar-patch-elements" || elements.ID == "" {
+		t.Fatalf("signals without an ID are followed by %s with ID %q, want datastar-patch-elements with an ID", elements.Event, elements.ID)
+	}
+	return signals, elements
+}
+
+// expectNone fails the test if a frame arrives within d.
+func (s *sseStream) expectNone(t *testing.T, d time.Duration) {
+	t.Helper()
+	select {
+	case frame := <-s.frames:
+		t.Fatalf("unexpected %s event: %+v", frame.Event, frame)
+	case <-time.After(d):
+	}
+}
+
+var (
+	mosquitto = types.ServiceStatus{Name: "mosquitto", Group: "Docker", Status: "running", Health: types.HealthOperational, Uptime: "2h"}
+	grafana   = types.ServiceStatus{Name: "grafana", Group: "Docker", Status: "running", Health: types.HealthOperational, Uptime: "5m"}
+)
+
+// newSSEServer serves a server whose collector published the services.
+func newSSEServer(t *testing.T, services ...types.ServiceStatus) (*fakeCollector, *httptest.Server) {
+	t.Helper()
+	collector := newFakeCollector()
+	collector.publish(testSnapshot("docker", services...))
+	server := httptest.NewServer(newTestServer(t, &fakeStore{}, collector).Router())
+	t.Cleanup(server.Close)
+	return collector, server
+}
+
+func TestSSEPatchesChangedSignals(t *testing.T) {
+	collector, server := newSSEServer(t, mosquitto)
+	stream := connectSSE(t, server, "")
+	cpu := templates.MetricSignal(types.Metric{Name: "cpu"}, "value")
+
+	// The full state comes first, with the grid
+	signals, elements := stream.nextEvent(t)
+	status := templates.ServiceSignal("docker_mosquitto", "status")
+	if signals.Signals[status] != "running" {
+		t.Errorf("%s = %v, want running", status, signals.Signals[status])
+	}
+	if _, ok := signals.Signals[cpu]; !ok {
+		t.Errorf("full state lacks %s", cpu)
+	}
+	if !strings.Contains(elements.Elements, `id="services-grid"`) || !strings.Contains(elements.Elements, "mosquitto") {
+		t.Errorf("elements do not replace #services-grid with mosquitto: %s", elements.Elements)
+	}
+
+	// A status change only patches the signals of the service
+	stopped := mosquitto
+	stopped.Status, stopped.Health = "exited", types.HealthMajorOutage
+	collector.publish(testSnapshot("docker", stopped))
+	signals, elements = stream.nextEvent(t)
+	if elements.Event != "" {
+		t.Errorf("a status change re-rendered the grid")
+	}
+	if signals.Signals[status] != "exited" || signals.Signals[templates.ServiceSignal("docker_mosquitto", "health")] != "major_outage" {
+		t.Errorf("signals = %v, want the new status and health of mosquitto", signals.Signals)
+	}
+	// Unchanged signals are not sent again
+	for _, name := range []string{templates.ServiceSignal("docker_mosquitto", "uptime"), cpu} {
+		if _, ok := signals.Signals[name]; ok {
+			t.Errorf("unchanged signal %s was sent", name)
+		}
+	}
+
+	// A snapshot without changes sends nothing
+	collector.publish(testSnapshot("docker", stopped))
+	stream.expectNone(t, 200*time.Millisecond)
+}
+
+func TestSSEPatchesGridWhenServicesChange(t *testing.T) {
+	collector, server := newSSEServer(t, mosquitto)
+	stream := connectSSE(t, server, "")
+	stream.nextEvent(t)
+
+	// A new service gets its signals and a card in the grid
+	collector.publish(testSnapshot("docker", mosquitto, grafana))
+	signals, elements := stream.nextEvent(t)
+	if signals.Signals[templates.ServiceSignal("docker_grafana", "status")] != "running" {
+		t.Errorf("signals = %v, want those of grafana", signals.Signals)
+	}
+	if _, ok := signals.Signals[templates.ServiceSignal("docker_mosquitto", "status")]; ok {
+		t.Error("signals of mosquitto were sent again")
+	}
+	if !strings.Contains(elements.Elements, `id="services-grid"`) || !strings.Contains(elements.Elements, "grafana") {
+		t.Errorf("grid was not replaced with grafana: %s", elements.Elements)
+	}
+
+	// A removed service has its signals removed and its card dropped
+	collector.publish(testSnapshot("docker", grafana))
+	signals, elements = stream.nextEvent(t)
+	for _, field := range []string{"status", "health", "details", "uptime"} {
+		name := templates.ServiceSignal("docker_mosquitto", field)
+		if value, ok := signals.Signals[name]; !ok || value != nil {
+			t.Errorf("%s = %v, want null", name, value)
+		}
+	}
+	if !strings.Contains(elements.Elements, `id="services-grid"`) || strings.Contains(elements.Elements, "mosquitto") {
+		t.Errorf("grid still shows mosquitto: %s", elements.Elements)
+	}
+}
+
+func TestSSEKeepsSignalsOnReorder(t *testing.T) {
+	collector, server := newSSEServer(t, mosquitto, grafana)
+	stream := connectSSE(t, server, "")
+	stream.nextEvent(t)
+
+	// Signals are keyed by ID, so only the grid changes
+	collector.publish(testSnapshot("docker", grafana, mosquitto))
+	signals, elements := stream.nextEvent(t)
+	for name := range signals.Signals {
+		if strings.HasPrefix(name, "service_") {
+			t.Errorf("reordering sent signal %s", name)
+		}
+	}
+	if strings.Index(elements.Elements, "grafana") > strings.Index(elements.Elements, "mosquitto") {
+		t.Error("grid is not in the new order")
+	}
+}
+
+// publishUptimes publishes n changes of the uptime of mosquitto and returns
+// the IDs of the events stream got for them.
+func publishUptimes(t *testing.T, collector *fakeCollector, stream *sseStream, n int) []string {
+	t.Helper()
+	ids := make([]string, n)
+	for i := range ids {
+		service := mosquitto
+		service.Uptime = fmt.Sprintf("%dm", i)
+		collector.publish(testSnapshot("docker", service))
+		signals, _ := stream.nextEvent(t)
+		ids[i] = signals.ID
+	}
+	return ids
+}
+
+func TestSSEResumesAfterLastEventID(t *testing.T) {
+	collector, server := newSSEServer(t, mosquitto)
+	stream := connectSSE(t, server, "")
+	_, full := stream.nextEvent(t)
+	ids := publishUptimes(t, collector, stream, 2)
+
+	// Only the missed events are sent, without the full state
+	resumed := connectSSE(t, server, full.ID)
+	for _, id := range ids {
+		signals, elements := resumed.nextEvent(t)
+		if signals.ID != id || elements.Event != "" {
+			t.Errorf("resumed with %s event %q, want signals of event %s", elements.Event, signals.ID, id)
+		}
+	}
+	resumed.expectNone(t, 200*time.Millisecond)
+
+	// A client that missed nothing gets nothing
+	current := connectSSE(t, server, ids[len(ids)-1])
+	current.expectNone(t, 200*time.Millisecond)
+}
+
+func TestSSESendsFullStateWithoutReplay(t *testing.T) {
+	collector, server := newSSEServer(t, mosquitto)
+	stream := connectSSE(t, server, "")
+	_, full := stream.nextEvent(t)
+	// The first of the events is no longer kept
+	ids := publishUptimes(t, collector, stream, sseReplay+1)
+	lastID := ids[len(ids)-1]
+	next, _ := strconv.ParseUint(lastID, 10, 64)
+
+	for name, lastEventID := range map[string]string{
+		"older than the replay": full.ID,
+		"from the future":       strconv.FormatUint(next+1, 10),
+		"invalid":               "resume",
+	} {
+		t.Run(name, func(t *testing.T) {
+			reconnected := connectSSE(t, server, lastEventID)
+			signals, elements := reconnected.nextEvent(t)
+			if elements.ID != lastID {
+				t.Errorf("full state has ID %q, want %s", elements.ID, lastID)
+			}
+			if !strings.Contains(elements.Elements, `id="services-grid"`) {
+				t.Errorf("full state lacks the grid: %s", elements.Elements)
+			}
+			uptime := templates.ServiceSignal("docker_mosquitto", "uptime")
+			if signals.Signals[uptime] != fmt.Sprintf("%dm", sseReplay) {
+				t.Errorf("%s = %v, want the latest uptime", uptime, signals.Signals[uptime])
+			}
+			reconnected.expectNone(t, 100*time.Millisecond)
+		})
+	}
+}
+
+func TestEventHubDropsSlowClients(t *testing.T) {
+	hub := newEventHub()
+	slow := hub.subscribe(0)
+	fast := hub.subscribe(0)
+
+	for i := 0; i <= sseClientBuffer; i++ {
+		hub.update(map[string]interface{}{"n": i}, "", nil)
+		<-fast.events
+	}
+
+	select {
+	case <-slow.done:
+	default:
+		t.Fatalf("client %d events behind was not disconnected", sseClientBuffer+1)
+	}
+	select {
+	case <-fast.done:
+		t.Error("client keeping up was disconnected")
+	default:
+	}
+	if _, ok := hub.clients[slow]; ok {
+		t.Error("disconnected client is still subscribed")
+	}
+
+	result, err := hub.Collect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{"sse_clients": 1, "sse_dropped_clients": 1, "sse_resumed_clients": 0}
+	for _, metric := range result.Metrics {
+		if metric.Value != want[metric.Name] {
+			t.Errorf("%s = %v, want %v", metric.Name, metric.Value, want[metric.Name])
+		}
+	}
+}
+
+func TestSSEPatchesDetailsAddedLater(t *testing.T) {
+	noUptime := mosquitto
+	noUptime.Uptime = ""
+	collector, server := newSSEServer(t, noUptime)
+	stream := connectSSE(t, server, "")
+
+	// Empty details and uptime are rendered hidden, ready for their signals
+	_, elements := stream.nextEvent(t)
+	for _, field := range []string{"details", "uptime"} {
+		name := templates.ServiceSignal("docker_mosquitto", field)
+		if !strings.Contains(elements.Elements, `data-show="$`+name) || !strings.Contains(elements.Elements, `data-text="$`+name+`"`) {
+			t.Errorf("grid has no hidden element showing %s: %s", name, elements.Elements)
+		}
+	}
+	if hidden := strings.Count(elements.Elements, `style="display: none"`); hidden != 2 {
+		t.Errorf("grid has %d hidden elements, want details and uptime", hidden)
+	}
+
+	// Gaining details only patches their signal
+	unhealthy := noUptime
+	unhealthy.Details, unhealthy.Uptime = "health check failing", "1m"
+	collector.publish(testSnapshot("docker", unhealthy))
+	signals, elements := stream.nextEvent(t)
+	if elements.Event != "" {
+		t.Error("new details re-rendered the grid")
+	}
+	if details := signals.Signals[templates.ServiceSignal("docker_mosquitto", "details")]; details != "health check failing" {
+		t.Errorf("details = %v, want them patched", details)
+	}
+	if uptime := signals.Signals[templates.ServiceSignal("docker_mosquitto", "uptime")]; uptime != "1m" {
+		t.Errorf("uptime = %v, want it patched", uptime)
+	}
+}